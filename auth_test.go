@@ -810,6 +810,30 @@ func (s *S) TestDefaultDatabase(c *C) {
 	}
 }
 
+func (s *S) TestAuthDialWithInfo(c *C) {
+	info := &mgo.DialInfo{
+		Addrs:    []string{"localhost:40002"},
+		Timeout:  5 * time.Second,
+		Database: "mydb",
+		Source:   "admin",
+		Username: "root",
+		Password: "rapadura",
+	}
+	session, err := mgo.DialWithInfo(info)
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	c.Assert(session.DB("").Name, Equals, "mydb")
+
+	coll := session.DB("").C("mycoll")
+	err = coll.Insert(M{"n": 1})
+	c.Assert(err, IsNil)
+
+	n, err := coll.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+}
+
 func (s *S) TestAuthDirect(c *C) {
 	// Direct connections must work to the master and slaves.
 	for _, port := range []string{"40031", "40032", "40033"} {
@@ -846,6 +870,77 @@ func (s *S) TestAuthDirectWithLogin(c *C) {
 	}
 }
 
+func (s *S) TestAuthLoginSurvivesFailover(c *C) {
+	if *fast {
+		c.Skip("-fast")
+	}
+
+	session, err := mgo.Dial("localhost:40031")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	err = session.DB("admin").Login("root", "rapadura")
+	c.Assert(err, IsNil)
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"n": 1})
+	c.Assert(err, IsNil)
+
+	// Kill the master.
+	result := &struct{ Host string }{}
+	err = session.Run("serverStatus", result)
+	c.Assert(err, IsNil)
+	s.Stop(result.Host)
+
+	// Wait for the new master, without logging in again.
+	session.Refresh()
+	session.SetSyncTimeout(3 * time.Minute)
+	for i := 0; i < 60; i++ {
+		err = coll.Insert(M{"n": 2})
+		if err == nil {
+			break
+		}
+		c.Logf("Waiting for replica set to elect a new master. Last error: %v", err)
+		time.Sleep(500 * time.Millisecond)
+		session.Refresh()
+	}
+	c.Assert(err, IsNil)
+
+	err = session.Run("serverStatus", result)
+	c.Assert(err, IsNil)
+
+	n, err := coll.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+}
+
+func (s *S) TestAuthLoginCredentialSource(c *C) {
+	session, err := mgo.Dial("localhost:40002")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"n": 1})
+	c.Assert(err, ErrorMatches, "unauthorized|need to login|not authorized .*")
+
+	// The user is defined in admin, but grants access to mydb as well.
+	cred := &mgo.Credential{Username: "root", Password: "rapadura", Source: "admin"}
+	err = session.Login(cred)
+	c.Assert(err, IsNil)
+
+	err = coll.Insert(M{"n": 1})
+	c.Assert(err, IsNil)
+
+	// Logging out from the database used isn't enough.
+	session.DB("mydb").Logout()
+	err = coll.Insert(M{"n": 2})
+	c.Assert(err, IsNil)
+
+	session.DB("admin").Logout()
+	err = coll.Insert(M{"n": 3})
+	c.Assert(err, ErrorMatches, "unauthorized|need to login|not authorized .*")
+}
+
 func (s *S) TestAuthScramSha1Cred(c *C) {
 	if !s.versionAtLeast(2, 7, 7) {
 		c.Skip("SCRAM-SHA-1 tests depend on 2.7.7")
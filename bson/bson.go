@@ -524,10 +524,11 @@ func handleErr(err *error) {
 //     minsize    Marshal an int64 value as an int32, if that's feasible
 //                while preserving the numeric value.
 //
-//     inline     Inline the field, which must be a struct or a map,
-//                causing all of its fields or keys to be processed as if
-//                they were part of the outer struct. For maps, keys must
-//                not conflict with the bson keys of other struct fields.
+//     inline     Inline the field, which must be a struct, a map or a
+//                RawD, causing all of its fields, keys or elements to be
+//                processed as if they were part of the outer struct. For
+//                maps and RawD, keys must not conflict with the bson keys
+//                of other struct fields.
 //
 // Some examples:
 //
@@ -569,11 +570,14 @@ func MarshalBuffer(in interface{}, buf []byte) (out []byte, err error) {
 // The following flags are currently supported during unmarshal (see the
 // Marshal method for other flags):
 //
-//     inline     Inline the field, which must be a struct or a map.
-//                Inlined structs are handled as if its fields were part
-//                of the outer struct. An inlined map causes keys that do
-//                not match any other struct field to be inserted in the
-//                map rather than being discarded as usual.
+//     inline     Inline the field, which must be a struct, a map or a
+//                RawD. Inlined structs are handled as if its fields were
+//                part of the outer struct. An inlined map causes keys that
+//                do not match any other struct field to be inserted in the
+//                map rather than being discarded as usual. An inlined RawD
+//                collects those elements in their original order without
+//                decoding them, so that they survive a read-modify-write
+//                cycle untouched.
 //
 // The target field or element types of out may not necessarily match
 // the BSON values of the provided data.  The following conversions are
@@ -737,8 +741,13 @@ func getStructInfo(st reflect.Type) (*structInfo, error) {
 		}
 
 		if inline {
-			switch field.Type.Kind() {
-			case reflect.Map:
+			switch {
+			case field.Type.Kind() == reflect.Slice && field.Type.Elem() == typeRawDocElem:
+				if inlineMap >= 0 {
+					return nil, errors.New("Multiple ,inline maps in struct " + st.String())
+				}
+				inlineMap = info.Num
+			case field.Type.Kind() == reflect.Map:
 				if inlineMap >= 0 {
 					return nil, errors.New("Multiple ,inline maps in struct " + st.String())
 				}
@@ -746,7 +755,7 @@ func getStructInfo(st reflect.Type) (*structInfo, error) {
 					return nil, errors.New("Option ,inline needs a map with string keys in struct " + st.String())
 				}
 				inlineMap = info.Num
-			case reflect.Struct:
+			case field.Type.Kind() == reflect.Struct:
 				sinfo, err := getStructInfo(field.Type)
 				if err != nil {
 					return nil, err
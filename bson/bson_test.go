@@ -723,6 +723,35 @@ var marshalErrorItems = []testItemType{
 		"Option ,inline needs a map with string keys in struct bson_test.inlineBadKeyMap"},
 	{&inlineMap{A: 1, M: map[string]interface{}{"a": 1}},
 		`Can't have key "a" in inlined map; conflicts with struct field`},
+	{&inlineRawD{A: 1, R: bson.RawD{{Name: "a", Value: bson.Raw{Kind: 0x10, Data: []byte{2, 0, 0, 0}}}}},
+		`Can't have key "a" in inlined RawD; conflicts with struct field`},
+	{&inlineDupRawD{},
+		"Multiple ,inline maps in struct bson_test.inlineDupRawD"},
+}
+
+func (s *S) TestInlineRawD(c *C) {
+	data, err := bson.Marshal(bson.D{{Name: "z", Value: "last"}, {Name: "a", Value: 1}, {Name: "b", Value: bson.M{"c": 2}}})
+	c.Assert(err, IsNil)
+
+	v := inlineRawD{R: bson.RawD{{Name: "stale"}}}
+	err = bson.Unmarshal(data, &v)
+	c.Assert(err, IsNil)
+	c.Assert(v.A, Equals, 1)
+	c.Assert(v.R, HasLen, 2)
+	c.Assert(v.R[0].Name, Equals, "z")
+	c.Assert(v.R[1].Name, Equals, "b")
+	var str string
+	c.Assert(v.R[0].Value.Unmarshal(&str), IsNil)
+	c.Assert(str, Equals, "last")
+
+	// Unknown elements survive a round trip.
+	v.A = 2
+	data, err = bson.Marshal(&v)
+	c.Assert(err, IsNil)
+	var doc bson.D
+	err = bson.Unmarshal(data, &doc)
+	c.Assert(err, IsNil)
+	c.Assert(doc, DeepEquals, bson.D{{Name: "z", Value: "last"}, {Name: "b", Value: bson.D{{Name: "c", Value: 2}}}, {Name: "a", Value: 2}})
 }
 
 func (s *S) TestMarshalErrorItems(c *C) {
@@ -1167,6 +1196,14 @@ type inlineDupMap struct {
 	M1 map[string]interface{} `bson:",inline"`
 	M2 map[string]interface{} `bson:",inline"`
 }
+type inlineRawD struct {
+	A int
+	R bson.RawD `bson:",inline"`
+}
+type inlineDupRawD struct {
+	M map[string]interface{} `bson:",inline"`
+	R bson.RawD              `bson:",inline"`
+}
 type inlineBadKeyMap struct {
 	M map[int]int `bson:",inline"`
 }
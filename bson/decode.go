@@ -197,12 +197,16 @@ func (d *decoder) readDocTo(out reflect.Value) {
 		out.Set(sinfo.Zero)
 		if sinfo.InlineMap != -1 {
 			inlineMap = out.Field(sinfo.InlineMap)
-			if !inlineMap.IsNil() && inlineMap.Len() > 0 {
-				clearMap(inlineMap)
-			}
-			elemType = inlineMap.Type().Elem()
-			if elemType == typeIface {
-				d.docType = inlineMap.Type()
+			if inlineMap.Kind() == reflect.Slice {
+				inlineMap.Set(reflect.Zero(inlineMap.Type()))
+			} else {
+				if !inlineMap.IsNil() && inlineMap.Len() > 0 {
+					clearMap(inlineMap)
+				}
+				elemType = inlineMap.Type().Elem()
+				if elemType == typeIface {
+					d.docType = inlineMap.Type()
+				}
 			}
 		}
 	case reflect.Slice:
@@ -283,6 +287,9 @@ func (d *decoder) readDocTo(out reflect.Value) {
 				} else {
 					d.readElemTo(out.FieldByIndex(info.Inline), kind)
 				}
+			} else if inlineMap.Kind() == reflect.Slice {
+				elem := RawDocElem{Name: name, Value: d.readRaw(kind)}
+				inlineMap.Set(reflect.Append(inlineMap, reflect.ValueOf(elem)))
 			} else if inlineMap.IsValid() {
 				if inlineMap.IsNil() {
 					inlineMap.Set(reflect.MakeMap(inlineMap.Type()))
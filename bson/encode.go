@@ -213,7 +213,16 @@ func (e *encoder) addStruct(v reflect.Value) {
 		panic(err)
 	}
 	var value reflect.Value
-	if sinfo.InlineMap >= 0 {
+	if sinfo.InlineMap >= 0 && v.Field(sinfo.InlineMap).Kind() == reflect.Slice {
+		rest := v.Field(sinfo.InlineMap)
+		for i := 0; i < rest.Len(); i++ {
+			elem := rest.Index(i).Interface().(RawDocElem)
+			if _, found := sinfo.FieldsMap[elem.Name]; found {
+				panic(fmt.Sprintf("Can't have key %q in inlined RawD; conflicts with struct field", elem.Name))
+			}
+			e.addElem(elem.Name, reflect.ValueOf(elem.Value), false)
+		}
+	} else if sinfo.InlineMap >= 0 {
 		m := v.Field(sinfo.InlineMap)
 		if m.Len() > 0 {
 			for _, k := range m.MapKeys() {
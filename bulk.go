@@ -48,9 +48,14 @@ type bulkUpdateOp []interface{}
 type bulkDeleteOp []interface{}
 
 // BulkResult holds the results for a bulk operation.
+//
+// Matched counts the documents matched by both updates and removals, for
+// compatibility with earlier releases, while Removed counts the removals only.
 type BulkResult struct {
 	Matched  int
 	Modified int // Available only for MongoDB 2.6+
+	Inserted int
+	Removed  int
 
 	// Be conservative while we understand exactly how to report these
 	// results in a useful and convenient way, and also how to emulate
@@ -283,6 +288,12 @@ func (b *Bulk) Upsert(pairs ...interface{}) {
 // be an aggregation of all issues observed. As an exception to that, Insert
 // operations running on MongoDB versions prior to 2.6 will report the last
 // error only due to a limitation in the wire protocol.
+//
+// When an error is reported the returned result is still set, holding the
+// changes applied by the operations that succeeded, and the error is a
+// *BulkError whose Cases method reports each failed operation along with
+// its position in the bulk. Documents inserted by a failed insert batch
+// are only accounted for with MongoDB 2.6+.
 func (b *Bulk) Run() (*BulkResult, error) {
 	var result BulkResult
 	var berr BulkError
@@ -312,7 +323,7 @@ func (b *Bulk) Run() (*BulkResult, error) {
 	}
 	if failed {
 		sort.Sort(bulkErrorCases(berr.ecases))
-		return nil, &berr
+		return &result, &berr
 	}
 	return &result, nil
 }
@@ -323,7 +334,13 @@ func (b *Bulk) runInsert(action *bulkAction, result *BulkResult, berr *BulkError
 		op.flags = 1 // ContinueOnError
 	}
 	lerr, err := b.c.writeOp(op, b.ordered)
-	return b.checkSuccess(action, berr, lerr, err)
+	ok := b.checkSuccess(action, berr, lerr, err)
+	if ok {
+		result.Inserted += len(action.docs)
+	} else if lerr != nil {
+		result.Inserted += lerr.N
+	}
+	return ok
 }
 
 func (b *Bulk) runUpdate(action *bulkAction, result *BulkResult, berr *BulkError) bool {
@@ -340,6 +357,7 @@ func (b *Bulk) runRemove(action *bulkAction, result *BulkResult, berr *BulkError
 	if lerr != nil {
 		result.Matched += lerr.N
 		result.Modified += lerr.modified
+		result.Removed += lerr.N
 	}
 	return b.checkSuccess(action, berr, lerr, err)
 }
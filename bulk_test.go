@@ -453,6 +453,60 @@ func (s *S) TestBulkMixedUnordered(c *C) {
 	c.Assert(res, DeepEquals, []doc{{2}, {3}, {4}})
 }
 
+func (s *S) TestBulkResultCounts(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"n": 1})
+	c.Assert(err, IsNil)
+
+	bulk := coll.Bulk()
+	bulk.Insert(M{"n": 2}, M{"n": 3})
+	bulk.Update(M{"n": 1}, M{"$set": M{"n": 10}})
+	bulk.Remove(M{"n": 42})
+	bulk.RemoveAll(M{"n": 3})
+	r, err := bulk.Run()
+	c.Assert(err, IsNil)
+	c.Assert(r.Inserted, Equals, 2)
+	c.Assert(r.Removed, Equals, 1)
+	c.Assert(r.Matched, Equals, 2)
+	if s.versionAtLeast(2, 6) {
+		c.Assert(r.Modified, Equals, 1)
+	}
+}
+
+func (s *S) TestBulkResultOnError(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"_id": 1})
+	c.Assert(err, IsNil)
+
+	bulk := coll.Bulk()
+	bulk.Unordered()
+	bulk.Insert(M{"_id": 2})
+	bulk.Update(M{"_id": 1}, M{"$set": M{"n": 1}})
+	bulk.Insert(M{"_id": 1})
+	bulk.Remove(M{"_id": 2})
+	r, err := bulk.Run()
+	c.Assert(err, NotNil)
+	c.Assert(r, NotNil)
+
+	ecases := err.(*mgo.BulkError).Cases()
+	c.Assert(ecases, HasLen, 1)
+	c.Assert(ecases[0].Err, ErrorMatches, ".*duplicate.*")
+	if s.versionAtLeast(2, 6) {
+		c.Assert(ecases[0].Index, Equals, 2)
+		c.Assert(r.Inserted, Equals, 1)
+	}
+	c.Assert(r.Matched, Equals, 2)
+	c.Assert(r.Removed, Equals, 1)
+}
+
 func (s *S) TestBulkUpsert(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
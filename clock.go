@@ -0,0 +1,47 @@
+package mgo
+
+import (
+	"sync"
+	"time"
+)
+
+// clock abstracts the passage of time for timeouts and backoff delays, so
+// that tests may replace the system clock with a controllable fake via
+// setClock.
+type clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// systemClock is the clock backed by the time package, used by default.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time        { return time.Now() }
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+var (
+	clockMutex   sync.RWMutex
+	currentClock clock = systemClock{}
+)
+
+// getClock returns the clock currently in use.
+func getClock() clock {
+	clockMutex.RLock()
+	c := currentClock
+	clockMutex.RUnlock()
+	return c
+}
+
+// setClock replaces the clock in use and returns a function restoring the
+// previous one.
+func setClock(c clock) (restore func()) {
+	clockMutex.Lock()
+	old := currentClock
+	currentClock = c
+	clockMutex.Unlock()
+	return func() {
+		clockMutex.Lock()
+		currentClock = old
+		clockMutex.Unlock()
+	}
+}
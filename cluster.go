@@ -29,6 +29,7 @@ package mgo
 import (
 	"errors"
 	"fmt"
+	"math/rand"
 	"net"
 	"runtime"
 	"strconv"
@@ -65,6 +66,12 @@ type mongoCluster struct {
 	appName       string
 	minPoolSize   int
 	maxIdleTimeMS int
+	keepAlive     time.Duration
+	backoffMin    time.Duration
+	backoffMax    time.Duration
+	seedTimeout   time.Duration
+	maxSetVersion int
+	maxElection   bson.ObjectId
 }
 
 func newCluster(userSeeds []string, direct, failFast bool, dial dialer, setName string, appName string) *mongoCluster {
@@ -102,8 +109,10 @@ func (cluster *mongoCluster) Release() {
 	cluster.references--
 	debugf("Cluster %p released (refs=%d)", cluster, cluster.references)
 	if cluster.references == 0 {
+		// Operations still waiting on the servers belong to sessions
+		// that are now all closed.
 		for _, server := range cluster.servers.Slice() {
-			server.Close()
+			server.CloseWithError(ErrClosed)
 		}
 		// Wake up the sync loop so it can die.
 		cluster.syncServers()
@@ -121,6 +130,97 @@ func (cluster *mongoCluster) LiveServers() (servers []string) {
 	return servers
 }
 
+// PrimaryServer returns the address of the server currently known
+// to be the primary, or an error if no primary is known.
+func (cluster *mongoCluster) PrimaryServer() (string, error) {
+	cluster.RLock()
+	defer cluster.RUnlock()
+	masters := cluster.masters.Slice()
+	if len(masters) == 0 {
+		return "", errors.New("no primary server known")
+	}
+	return masters[0].Addr, nil
+}
+
+// ServerStats returns the socket pool state of every known server,
+// keyed by server address.
+func (cluster *mongoCluster) ServerStats() map[string]ServerStat {
+	cluster.RLock()
+	servers := cluster.servers.Slice()
+	cluster.RUnlock()
+	stats := make(map[string]ServerStat, len(servers))
+	for _, server := range servers {
+		stats[server.Addr] = server.Stat()
+	}
+	return stats
+}
+
+// checkWriteConcern reports an error if the write concern w, as held by a
+// getLastError command, cannot be satisfied by the data-bearing members of
+// the cluster as known from the last topology sync. Tag set modes and
+// clusters behind mongos are not checked, since their members are not
+// visible to the driver.
+func (cluster *mongoCluster) checkWriteConcern(w interface{}) error {
+	cluster.RLock()
+	defer cluster.RUnlock()
+	if cluster.servers.HasMongos() {
+		return nil
+	}
+	live := cluster.servers.Len()
+	members := live
+	for _, server := range cluster.servers.Slice() {
+		if size := server.Info().SetSize; size > members {
+			members = size
+		}
+	}
+	switch w := w.(type) {
+	case int:
+		if w > members {
+			return fmt.Errorf("write concern w=%d cannot be satisfied by %d data-bearing members", w, members)
+		}
+	case string:
+		if w == "majority" && live < members/2+1 {
+			return fmt.Errorf("write concern w=majority cannot be satisfied with %d of %d data-bearing members reachable", live, members)
+		}
+	}
+	return nil
+}
+
+// Resync requests a synchronization of the cluster topology, waits for it
+// to complete, and reports whether the set of known servers or masters
+// changed in the meantime.
+func (cluster *mongoCluster) Resync() (changed bool) {
+	cluster.RLock()
+	servers := cluster.topology()
+	syncCount := cluster.syncCount
+	cluster.RUnlock()
+
+	cluster.syncServers()
+
+	cluster.RLock()
+	for cluster.syncCount == syncCount && cluster.references > 0 {
+		// Remember: this will release and reacquire the lock.
+		cluster.serverSynced.Wait()
+	}
+	changed = cluster.topology() != servers
+	cluster.RUnlock()
+	return changed
+}
+
+// topology returns a description of the known servers and masters that
+// changes whenever either of them changes. The cluster lock must be held.
+func (cluster *mongoCluster) topology() string {
+	var addrs []string
+	for _, serv := range cluster.servers.Slice() {
+		addrs = append(addrs, serv.Addr)
+	}
+	addrs = append(addrs, "")
+	for _, serv := range cluster.masters.Slice() {
+		addrs = append(addrs, serv.Addr)
+	}
+	return strings.Join(addrs, " ")
+}
+
 func (cluster *mongoCluster) removeServer(server *mongoServer) {
 	cluster.Lock()
 	cluster.masters.Remove(server)
@@ -141,8 +241,14 @@ type isMasterResult struct {
 	Passives       []string
 	Tags           bson.D
 	Msg            string
-	SetName        string `bson:"setName"`
-	MaxWireVersion int    `bson:"maxWireVersion"`
+	SetName        string        `bson:"setName"`
+	MinWireVersion int           `bson:"minWireVersion"`
+	MaxWireVersion int           `bson:"maxWireVersion"`
+	MaxBsonSize    int           `bson:"maxBsonObjectSize"`
+	MaxMessageSize int           `bson:"maxMessageSizeBytes"`
+	MaxWriteBatch  int           `bson:"maxWriteBatchSize"`
+	SetVersion     int           `bson:"setVersion"`
+	ElectionId     bson.ObjectId `bson:"electionId"`
 }
 
 func (cluster *mongoCluster) isMaster(socket *mongoSocket, result *isMasterResult) error {
@@ -159,25 +265,9 @@ func (cluster *mongoCluster) isMaster(socket *mongoSocket, result *isMasterResul
 	// 		https://github.com/mongodb/specifications/blob/master/source/mongodb-handshake/handshake.rst#connection-handshake
 	//
 	socket.sendMeta.Do(func() {
-		var meta = bson.M{
-			"driver": bson.M{
-				"name":    "mgo",
-				"version": "globalsign",
-			},
-			"os": bson.M{
-				"type":         runtime.GOOS,
-				"architecture": runtime.GOARCH,
-			},
-		}
-
-		// Include the application name if set
-		if cluster.appName != "" {
-			meta["application"] = bson.M{"name": cluster.appName}
-		}
-
 		cmd = append(cmd, bson.DocElem{
 			Name:  "client",
-			Value: meta,
+			Value: cluster.clientMetadata(),
 		})
 	})
 
@@ -186,13 +276,53 @@ func (cluster *mongoCluster) isMaster(socket *mongoSocket, result *isMasterResul
 	return err
 }
 
+// clientMetadata returns the client document sent in the connection
+// handshake, identifying the driver, the platform and the application.
+func (cluster *mongoCluster) clientMetadata() bson.M {
+	var meta = bson.M{
+		"driver": bson.M{
+			"name":    "mgo",
+			"version": "globalsign",
+		},
+		"os": bson.M{
+			"type":         runtime.GOOS,
+			"architecture": runtime.GOARCH,
+		},
+	}
+
+	// Include the application name if set
+	cluster.RLock()
+	appName := cluster.appName
+	cluster.RUnlock()
+	if appName != "" {
+		meta["application"] = bson.M{"name": appName}
+	}
+	return meta
+}
+
+// SetAppName sets the application name sent in the handshake of
+// connections established from now on.
+func (cluster *mongoCluster) SetAppName(appName string) {
+	cluster.Lock()
+	cluster.appName = appName
+	cluster.Unlock()
+}
+
 type possibleTimeout interface {
 	Timeout() bool
 }
 
 var syncSocketTimeout = 5 * time.Second
 
-func (cluster *mongoCluster) syncServer(server *mongoServer) (info *mongoServerInfo, hosts []string, err error) {
+// serverSyncTimeout returns how long synchronization waits for a single
+// server to be dialed and to answer before giving up on it.
+func (cluster *mongoCluster) serverSyncTimeout() time.Duration {
+	cluster.RLock()
+	seedTimeout := cluster.seedTimeout
+	cluster.RUnlock()
+	if seedTimeout > 0 {
+		return seedTimeout
+	}
 	var syncTimeout time.Duration
 	if raceDetector {
 		// This variable is only ever touched by tests.
@@ -202,6 +332,19 @@ func (cluster *mongoCluster) syncServer(server *mongoServer) (info *mongoServerI
 	} else {
 		syncTimeout = syncSocketTimeout
 	}
+	return syncTimeout
+}
+
+// SetSeedTimeout changes how long synchronization waits for each server.
+// Zero restores the default.
+func (cluster *mongoCluster) SetSeedTimeout(timeout time.Duration) {
+	cluster.Lock()
+	cluster.seedTimeout = timeout
+	cluster.Unlock()
+}
+
+func (cluster *mongoCluster) syncServer(server *mongoServer) (info *mongoServerInfo, hosts []string, err error) {
+	syncTimeout := cluster.serverSyncTimeout()
 
 	addr := server.Addr
 	log("SYNC Processing ", addr, "...")
@@ -246,6 +389,11 @@ func (cluster *mongoCluster) syncServer(server *mongoServer) (info *mongoServerI
 		return nil, nil, fmt.Errorf("server %s is not a member of replica set %q", addr, cluster.setName)
 	}
 
+	if result.IsMaster && cluster.stalePrimary(&result) {
+		logf("SYNC %s claims to be master with an outdated election (setVersion %d, electionId %s).", addr, result.SetVersion, result.ElectionId.Hex())
+		return nil, nil, fmt.Errorf("server %s is a stale primary", addr)
+	}
+
 	if result.IsMaster {
 		debugf("SYNC %s is a master.", addr)
 		if !server.info.Master {
@@ -268,7 +416,14 @@ func (cluster *mongoCluster) syncServer(server *mongoServer) (info *mongoServerI
 		Mongos:         result.Msg == "isdbgrid",
 		Tags:           result.Tags,
 		SetName:        result.SetName,
+		MinWireVersion: result.MinWireVersion,
 		MaxWireVersion: result.MaxWireVersion,
+		MaxBsonSize:    result.MaxBsonSize,
+		MaxMessageSize: result.MaxMessageSize,
+		MaxWriteBatch:  result.MaxWriteBatch,
+	}
+	if result.SetName != "" {
+		info.SetSize = len(result.Hosts) + len(result.Passives)
 	}
 
 	hosts = make([]string, 0, 1+len(result.Hosts)+len(result.Passives))
@@ -283,6 +438,27 @@ func (cluster *mongoCluster) syncServer(server *mongoServer) (info *mongoServerI
 	return info, hosts, nil
 }
 
+// stalePrimary reports whether the isMaster result of a server claiming to
+// be primary comes from an election older than one already seen, which means
+// it was deposed but doesn't know about it yet. Otherwise the election is
+// recorded as the most recent one. Elections are ordered by setVersion and
+// then electionId, as described in the server discovery and monitoring
+// specification.
+func (cluster *mongoCluster) stalePrimary(result *isMasterResult) bool {
+	if result.SetVersion == 0 || result.ElectionId == "" {
+		return false
+	}
+	cluster.Lock()
+	defer cluster.Unlock()
+	if cluster.maxSetVersion > result.SetVersion ||
+		cluster.maxSetVersion == result.SetVersion && cluster.maxElection > result.ElectionId {
+		return true
+	}
+	cluster.maxSetVersion = result.SetVersion
+	cluster.maxElection = result.ElectionId
+	return false
+}
+
 type syncKind bool
 
 const (
@@ -377,6 +553,7 @@ const syncShortDelay = 500 * time.Millisecond
 // cluster, and then attempt to do the same with all the peers
 // retrieved.
 func (cluster *mongoCluster) syncServersLoop() {
+	var failures uint
 	for {
 		debugf("SYNC Cluster %p is starting a sync loop iteration.", cluster)
 
@@ -420,9 +597,11 @@ func (cluster *mongoCluster) syncServersLoop() {
 
 		if restart {
 			log("SYNC No masters found. Will synchronize again.")
-			time.Sleep(syncShortDelay)
+			cluster.backoffSleep(cluster.syncBackoff(failures))
+			failures++
 			continue
 		}
+		failures = 0
 
 		debugf("SYNC Cluster %p waiting for next requested or scheduled sync.", cluster)
 
@@ -436,16 +615,110 @@ func (cluster *mongoCluster) syncServersLoop() {
 	debugf("SYNC Cluster %p is stopping its sync loop.", cluster)
 }
 
+// SetSyncBackoff changes the bounds of the delay between consecutive
+// synchronization attempts that fail to find usable servers.
+func (cluster *mongoCluster) SetSyncBackoff(min, max time.Duration) {
+	cluster.Lock()
+	cluster.backoffMin = min
+	cluster.backoffMax = max
+	cluster.Unlock()
+}
+
+// syncBackoff returns how long to wait before retrying a synchronization
+// that follows the given number of consecutive failed attempts. Without
+// configured bounds this is just syncShortDelay. Otherwise the delay
+// doubles with each failure up to the maximum, and is randomized within
+// its upper half so that many clients don't probe servers in lockstep.
+func (cluster *mongoCluster) syncBackoff(failures uint) time.Duration {
+	cluster.RLock()
+	min, max := cluster.backoffMin, cluster.backoffMax
+	cluster.RUnlock()
+	if min <= 0 {
+		return syncShortDelay
+	}
+	if max < min {
+		max = min
+	}
+	delay := max
+	// Compare without shifting min, which could overflow.
+	if failures < 63 && min <= max>>failures {
+		delay = min << failures
+	}
+	if half := int64(delay / 2); half > 0 {
+		delay = time.Duration(half + rand.Int63n(half+1))
+	}
+	if delay < min {
+		delay = min
+	}
+	return delay
+}
+
+// backoffSleep sleeps for the given duration, periodically waking up any
+// goroutines waiting for servers so they may honor their sync timeout.
+func (cluster *mongoCluster) backoffSleep(delay time.Duration) {
+	clock := getClock()
+	for delay > 0 {
+		step := delay
+		if step > syncShortDelay {
+			step = syncShortDelay
+		}
+		clock.Sleep(step)
+		delay -= step
+		cluster.serverSynced.Broadcast()
+	}
+}
+
 func (cluster *mongoCluster) server(addr string, tcpaddr *net.TCPAddr) *mongoServer {
 	cluster.RLock()
 	server := cluster.servers.Search(tcpaddr.String())
 	minPoolSize := cluster.minPoolSize
 	maxIdleTimeMS := cluster.maxIdleTimeMS
+	keepAlive := cluster.keepAlive
 	cluster.RUnlock()
 	if server != nil {
 		return server
 	}
-	return newServer(addr, tcpaddr, cluster.sync, cluster.dial, minPoolSize, maxIdleTimeMS)
+	return newServer(addr, tcpaddr, cluster.sync, cluster.dial, minPoolSize, maxIdleTimeMS, keepAlive)
+}
+
+// HasSlave returns whether a known server that isn't a master has all of
+// the serverTags, if provided.
+func (cluster *mongoCluster) HasSlave(serverTags []bson.D) bool {
+	cluster.RLock()
+	defer cluster.RUnlock()
+	for _, server := range cluster.servers.Slice() {
+		server.RLock()
+		ok := !server.info.Master && (serverTags == nil || server.hasTags(serverTags))
+		server.RUnlock()
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SetKeepAlive sets the keepalive period of connections established from
+// now on with any server of the cluster.
+func (cluster *mongoCluster) SetKeepAlive(period time.Duration) {
+	cluster.Lock()
+	cluster.keepAlive = period
+	servers := cluster.servers.Slice()
+	cluster.Unlock()
+	for _, server := range servers {
+		server.SetKeepAlive(period)
+	}
+}
+
+// SetMinPoolSize sets the minimum number of idle connections kept open
+// with each server of the cluster.
+func (cluster *mongoCluster) SetMinPoolSize(n int) {
+	cluster.Lock()
+	cluster.minPoolSize = n
+	servers := cluster.servers.Slice()
+	cluster.Unlock()
+	for _, server := range servers {
+		server.SetMinPoolSize(n)
+	}
 }
 
 func resolveAddr(addr string) (*net.TCPAddr, error) {
@@ -618,14 +891,17 @@ func (cluster *mongoCluster) syncServersIteration(direct bool) {
 // true, it will attempt to return a socket to a slave server.  If it is
 // false, the socket will necessarily be to a master server.
 func (cluster *mongoCluster) AcquireSocket(mode Mode, slaveOk bool, syncTimeout time.Duration, socketTimeout time.Duration, serverTags []bson.D, poolLimit int) (s *mongoSocket, err error) {
-	return cluster.AcquireSocketWithPoolTimeout(mode, slaveOk, syncTimeout, socketTimeout, serverTags, poolLimit, 0)
+	return cluster.AcquireSocketWithPoolTimeout(mode, slaveOk, syncTimeout, socketTimeout, serverTags, poolLimit, 0, 0, nil)
 }
 
 // AcquireSocketWithPoolTimeout returns a socket to a server in the cluster.  If slaveOk is
 // true, it will attempt to return a socket to a slave server.  If it is
-// false, the socket will necessarily be to a master server.
+// false, the socket will necessarily be to a master server.  Servers whose
+// ping time is within localThreshold of each other are considered equally
+// near; if zero, defaultLocalThreshold is used.  If avoid is not nil, any other
+// suitable server is preferred over it.
 func (cluster *mongoCluster) AcquireSocketWithPoolTimeout(
-	mode Mode, slaveOk bool, syncTimeout time.Duration, socketTimeout time.Duration, serverTags []bson.D, poolLimit int, poolTimeout time.Duration,
+	mode Mode, slaveOk bool, syncTimeout time.Duration, socketTimeout time.Duration, serverTags []bson.D, poolLimit int, poolTimeout time.Duration, localThreshold time.Duration, avoid *mongoServer,
 ) (s *mongoSocket, err error) {
 	var started time.Time
 	var syncCount uint
@@ -643,9 +919,9 @@ func (cluster *mongoCluster) AcquireSocketWithPoolTimeout(
 			}
 			if started.IsZero() {
 				// Initialize after fast path above.
-				started = time.Now()
+				started = getClock().Now()
 				syncCount = cluster.syncCount
-			} else if syncTimeout != 0 && started.Before(time.Now().Add(-syncTimeout)) || cluster.failFast && cluster.syncCount != syncCount {
+			} else if syncTimeout != 0 && started.Before(getClock().Now().Add(-syncTimeout)) || cluster.failFast && cluster.syncCount != syncCount {
 				cluster.RUnlock()
 				return nil, errors.New("no reachable servers")
 			}
@@ -658,9 +934,14 @@ func (cluster *mongoCluster) AcquireSocketWithPoolTimeout(
 
 		var server *mongoServer
 		if slaveOk {
-			server = cluster.servers.BestFit(mode, serverTags)
+			if avoid != nil {
+				server = cluster.servers.Without(avoid).BestFit(mode, serverTags, localThreshold)
+			}
+			if server == nil {
+				server = cluster.servers.BestFit(mode, serverTags, localThreshold)
+			}
 		} else {
-			server = cluster.masters.BestFit(mode, nil)
+			server = cluster.masters.BestFit(mode, nil, localThreshold)
 		}
 		cluster.RUnlock()
 
@@ -727,3 +1008,14 @@ func (cluster *mongoCluster) ResetIndexCache() {
 	cluster.cachedIndex = make(map[string]bool)
 	cluster.Unlock()
 }
+
+// ResetIndexCachePrefix drops the cached index keys that start with prefix.
+func (cluster *mongoCluster) ResetIndexCachePrefix(prefix string) {
+	cluster.Lock()
+	for cacheKey := range cluster.cachedIndex {
+		if strings.HasPrefix(cacheKey, prefix) {
+			delete(cluster.cachedIndex, cacheKey)
+		}
+	}
+	cluster.Unlock()
+}
@@ -160,6 +160,45 @@ func (s *S) TestCloneSession(c *C) {
 	c.Assert(stats.ReceivedDocs, Equals, 1)
 }
 
+func (s *S) TestCopySessionIndependentSocket(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	// Do a dummy operation to wait for connection.
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"_id": 1})
+	c.Assert(err, IsNil)
+
+	session.SetBatch(-1)
+	copy1 := session.Copy()
+	defer copy1.Close()
+	copy2 := session.Copy()
+	defer copy2.Close()
+	session.Refresh()
+
+	err = copy1.DB("mydb").C("mycoll").Insert(M{"_id": 2})
+	c.Assert(err, IsNil)
+	err = copy2.DB("mydb").C("mycoll").Insert(M{"_id": 3})
+	c.Assert(err, IsNil)
+
+	// Unlike Clone(), each copy holds its own socket.
+	stats := mgo.GetStats()
+	c.Assert(stats.SocketsInUse, Equals, 2)
+	c.Assert(stats.SocketRefs, Equals, 2)
+
+	mgo.ResetStats()
+
+	// Settings were preserved.
+	iter := copy1.DB("mydb").C("mycoll").Find(nil).Iter()
+	m := M{}
+	c.Assert(iter.Next(m), Equals, true)
+	c.Assert(iter.Close(), IsNil)
+
+	stats = mgo.GetStats()
+	c.Assert(stats.ReceivedDocs, Equals, 1)
+}
+
 func (s *S) TestModeStrong(c *C) {
 	session, err := mgo.Dial("localhost:40012")
 	c.Assert(err, IsNil)
@@ -394,6 +433,206 @@ func (s *S) TestModeEventual(c *C) {
 	c.Assert(stats.SocketsInUse, Equals, 0)
 }
 
+func (s *S) TestMonotonicReadCommand(c *C) {
+	master, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer master.Close()
+	master.SetSafe(&mgo.Safe{W: 3})
+	err = master.DB("mydb").C("mycoll").Insert(M{"a": 1})
+	c.Assert(err, IsNil)
+
+	// Must necessarily connect to a slave, otherwise the
+	// master connection will be available first.
+	session, err := mgo.Dial("localhost:40012")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	session.SetMode(mgo.Monotonic, false)
+
+	var stats struct {
+		Ns    string
+		Count int
+	}
+	err = session.DB("mydb").Run(bson.D{{Name: "collStats", Value: "mycoll"}}, &stats)
+	c.Assert(err, IsNil)
+	c.Assert(stats.Ns, Equals, "mydb.mycoll")
+	c.Assert(stats.Count, Equals, 1)
+
+	// Read commands leave the session on the slave.
+	result := M{}
+	err = session.Run("ismaster", &result)
+	c.Assert(err, IsNil)
+	c.Assert(result["ismaster"], Equals, false)
+
+	// Other commands switch it to the master.
+	err = session.DB("mydb").Run(bson.D{{Name: "create", Value: "othercoll"}}, nil)
+	c.Assert(err, IsNil)
+
+	result = M{}
+	err = session.Run("ismaster", &result)
+	c.Assert(err, IsNil)
+	c.Assert(result["ismaster"], Equals, true)
+}
+
+func (s *S) TestMonotonicFsyncLock(c *C) {
+	// Must necessarily connect to a slave, otherwise the
+	// master connection will be available first.
+	session, err := mgo.Dial("localhost:40012")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	session.SetMode(mgo.Monotonic, false)
+
+	result := M{}
+	err = session.Run("ismaster", &result)
+	c.Assert(err, IsNil)
+	c.Assert(result["ismaster"], Equals, false)
+
+	// Server-local commands run on the slave reserved by the session.
+	err = session.FsyncLock()
+	c.Assert(err, IsNil)
+	err = session.FsyncUnlock()
+	c.Assert(err, IsNil)
+
+	result = M{}
+	err = session.Run("ismaster", &result)
+	c.Assert(err, IsNil)
+	c.Assert(result["ismaster"], Equals, false)
+}
+
+func (s *S) TestCopyStrongFromEventual(c *C) {
+	// Must necessarily connect to a slave, otherwise the
+	// master connection will be available first.
+	session, err := mgo.Dial("localhost:40012")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	session.SetMode(mgo.Eventual, false)
+
+	scopy := session.CopyStrong()
+	defer scopy.Close()
+
+	c.Assert(scopy.Mode(), Equals, mgo.Strong)
+	c.Assert(session.Mode(), Equals, mgo.Eventual)
+
+	result := M{}
+	err = scopy.Run("ismaster", &result)
+	c.Assert(err, IsNil)
+	c.Assert(result["ismaster"], Equals, true)
+
+	var before, after struct {
+		Host       string
+		Opcounters struct{ Insert int }
+	}
+	err = scopy.Run("serverStatus", &before)
+	c.Assert(err, IsNil)
+
+	err = scopy.DB("mydb").C("mycoll").Insert(M{"a": 1})
+	c.Assert(err, IsNil)
+
+	err = scopy.Run("serverStatus", &after)
+	c.Assert(err, IsNil)
+	c.Assert(after.Host, Equals, before.Host)
+	c.Assert(after.Opcounters.Insert, Equals, before.Opcounters.Insert+1)
+
+	// The original session still reads from the slave.
+	result = M{}
+	err = session.Run("ismaster", &result)
+	c.Assert(err, IsNil)
+	c.Assert(result["ismaster"], Equals, false)
+}
+
+func (s *S) TestRetryReadsOnSlaveDeath(c *C) {
+	session, err := mgo.Dial("localhost:40021")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	for len(session.LiveServers()) != 3 {
+		c.Log("Waiting for cluster sync to finish...")
+		time.Sleep(5e8)
+	}
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"_id": 1})
+	c.Assert(err, IsNil)
+
+	session.SetMode(mgo.Secondary, true)
+	session.SetRetryReads(true)
+
+	// Make sure the document has reached the slaves.
+	var result M
+	for i := 0; i < 10; i++ {
+		if err = coll.FindId(1).One(&result); err == nil {
+			break
+		}
+		time.Sleep(5e8)
+	}
+	c.Assert(err, IsNil)
+
+	status := &struct{ Host string }{}
+	err = session.Run("serverStatus", status)
+	c.Assert(err, IsNil)
+
+	done := make(chan error, 1)
+	go func() {
+		var result M
+		done <- coll.Find(M{"_id": 1, "$where": "sleep(1000); return true"}).One(&result)
+	}()
+
+	// Kill the slave while the read is in progress.
+	time.Sleep(300 * time.Millisecond)
+	s.Stop(status.Host)
+
+	select {
+	case err = <-done:
+		c.Assert(err, IsNil)
+	case <-time.After(30 * time.Second):
+		c.Fatalf("read was not retried")
+	}
+}
+
+func (s *S) TestSlaveFallbackToPrimary(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	for len(session.LiveServers()) != 3 {
+		c.Log("Waiting for cluster sync to finish...")
+		time.Sleep(5e8)
+	}
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"_id": 1})
+	c.Assert(err, IsNil)
+
+	session.SetMode(mgo.Secondary, true)
+	session.SetSyncTimeout(5 * time.Second)
+	session.SetSlaveFallbackToPrimary(true)
+
+	s.Stop("localhost:40012")
+	s.Stop("localhost:40013")
+	session.Refresh()
+
+	var result M
+	err = coll.FindId(1).One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result["_id"], Equals, 1)
+
+	var ismaster struct{ IsMaster bool }
+	err = session.Run("ismaster", &ismaster)
+	c.Assert(err, IsNil)
+	c.Assert(ismaster.IsMaster, Equals, true)
+
+	// Without the fallback the read fails.
+	other := session.Copy()
+	defer other.Close()
+	other.SetSlaveFallbackToPrimary(false)
+	other.SetSyncTimeout(2 * time.Second)
+	other.Refresh()
+	err = other.DB("mydb").C("mycoll").FindId(1).One(&result)
+	c.Assert(err, ErrorMatches, "no reachable servers")
+}
+
 func (s *S) TestModeEventualAfterStrong(c *C) {
 	// Test that a strong session shifting to an eventual
 	// one preserves the socket untouched.
@@ -646,6 +885,44 @@ func (s *S) TestModeMonotonicWithSlaveFallover(c *C) {
 	c.Assert(ssresult.Host, Not(Equals), master)
 }
 
+func (s *S) TestWaitForPrimary(c *C) {
+	if *fast {
+		c.Skip("-fast")
+	}
+
+	session, err := mgo.Dial("localhost:40021")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	err = session.WaitForPrimary(10 * time.Second)
+	c.Assert(err, IsNil)
+
+	result := &struct{ Host string }{}
+	err = session.Run("serverStatus", result)
+	c.Assert(err, IsNil)
+	master := result.Host
+
+	// Kill the master, leaving the set without a primary until
+	// a new one is elected.
+	s.Stop(master)
+	session.Refresh()
+
+	started := time.Now()
+	err = session.WaitForPrimary(60 * time.Second)
+	c.Assert(err, IsNil)
+	c.Logf("New primary elected after %v", time.Since(started))
+
+	err = session.Run("serverStatus", result)
+	c.Assert(err, IsNil)
+	c.Assert(result.Host, Not(Equals), master)
+
+	// Without a majority no primary may be elected.
+	s.Stop(result.Host)
+	session.Refresh()
+	err = session.WaitForPrimary(2 * time.Second)
+	c.Assert(err, ErrorMatches, "no primary available after waiting 2s: .*")
+}
+
 func (s *S) TestModeEventualFallover(c *C) {
 	if *fast {
 		c.Skip("-fast")
@@ -773,6 +1050,43 @@ func (s *S) TestModeSecondaryPreferredFallover(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *S) TestSafeAfterFailover(c *C) {
+	if *fast {
+		c.Skip("-fast")
+	}
+
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	// An unknown write concern mode makes the server reject writes,
+	// which reveals whether the mode was actually sent along.
+	session.SetSafe(&mgo.Safe{WMode: "nosuchmode"})
+	badMode := "(?s).*(unrecognized getLastError mode|No write concern mode named).*"
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"a": 1})
+	c.Assert(err, ErrorMatches, badMode)
+
+	session.Refresh()
+	err = coll.Insert(M{"a": 2})
+	c.Assert(err, ErrorMatches, badMode)
+
+	// Kill the primary and let another one take over.
+	s.Stop("localhost:40011")
+	session.Refresh()
+
+	err = coll.Insert(M{"a": 3})
+	c.Assert(err, ErrorMatches, badMode)
+
+	result := &struct{ Host string }{}
+	err = session.Run("serverStatus", result)
+	c.Assert(err, IsNil)
+	c.Assert(supvName(result.Host), Not(Equals), "rs1a")
+
+	c.Assert(session.Safe(), DeepEquals, &mgo.Safe{WMode: "nosuchmode"})
+}
+
 func (s *S) TestModePrimaryPreferredFallover(c *C) {
 	if *fast {
 		c.Skip("-fast")
@@ -952,6 +1266,129 @@ func (s *S) TestTopologySyncWithSingleMaster(c *C) {
 	c.Assert(stats.SocketsInUse, Equals, 0)
 }
 
+func (s *S) TestPrimaryServer(c *C) {
+	session, err := mgo.Dial("localhost:40021")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	result := &struct{ Host string }{}
+	err = session.Run("serverStatus", result)
+	c.Assert(err, IsNil)
+
+	primary, err := session.PrimaryServer()
+	c.Assert(err, IsNil)
+	c.Assert(hostPort(primary), Equals, hostPort(result.Host))
+
+	for len(session.LiveServers()) != 3 {
+		c.Log("Waiting for cluster sync to finish...")
+		time.Sleep(5e8)
+	}
+	c.Assert(session.LiveServers(), HasLen, 3)
+}
+
+func (s *S) TestServerStats(c *C) {
+	session, err := mgo.Dial("localhost:40021")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	for len(session.LiveServers()) != 3 {
+		c.Log("Waiting for cluster sync to finish...")
+		time.Sleep(5e8)
+	}
+
+	// Reserve a socket to the primary.
+	err = session.Ping()
+	c.Assert(err, IsNil)
+	primary, err := session.PrimaryServer()
+	c.Assert(err, IsNil)
+
+	stats := session.ServerStats()
+	for _, addr := range session.LiveServers() {
+		_, ok := stats[addr]
+		c.Assert(ok, Equals, true, Commentf("missing stats for %s", addr))
+	}
+	c.Assert(stats[primary].InUse >= 1, Equals, true)
+
+	session.Refresh()
+	stats = session.ServerStats()
+	c.Assert(stats[primary].Idle >= 1, Equals, true)
+}
+
+func (s *S) TestSafeValidation(c *C) {
+	session, err := mgo.Dial("localhost:40021")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	for len(session.LiveServers()) != 3 {
+		c.Log("Waiting for cluster sync to finish...")
+		time.Sleep(5e8)
+	}
+
+	coll := session.DB("mydb").C("mycoll")
+
+	session.SetSafeValidation(true)
+	session.SetSafe(&mgo.Safe{W: 9, WTimeout: 5000})
+
+	started := time.Now()
+	err = coll.Insert(M{"n": 1})
+	c.Assert(err, ErrorMatches, "write concern w=9 cannot be satisfied by 3 data-bearing members")
+	c.Assert(time.Since(started) < 5*time.Second, Equals, true)
+
+	session.SetSafe(&mgo.Safe{WMode: "majority"})
+	err = coll.Insert(M{"n": 2})
+	c.Assert(err, IsNil)
+
+	// Without validation the server waits for the timeout.
+	session.SetSafeValidation(false)
+	session.SetSafe(&mgo.Safe{W: 9, WTimeout: 100})
+	err = coll.Insert(M{"n": 3})
+	c.Assert(err, ErrorMatches, "timeout|timed out waiting for slaves|Not enough data-bearing nodes|waiting for replication timed out")
+}
+
+func (s *S) TestResyncReportsTopologyChange(c *C) {
+	if *fast {
+		c.Skip("-fast")
+	}
+
+	session, err := mgo.Dial("localhost:40021")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	for len(session.LiveServers()) != 3 {
+		c.Log("Waiting for cluster sync to finish...")
+		time.Sleep(5e8)
+	}
+
+	// Nothing changed.
+	c.Assert(session.Resync(), Equals, false)
+
+	result := &struct{ Host string }{}
+	err = session.Run("serverStatus", result)
+	c.Assert(err, IsNil)
+
+	// Kill the master.
+	s.Stop(result.Host)
+	session.Refresh()
+
+	changed := false
+	for i := 0; i < 30 && !changed; i++ {
+		c.Log("Waiting for the topology change to be noticed...")
+		changed = session.Resync()
+	}
+	c.Assert(changed, Equals, true)
+
+	// Bring it back.
+	s.StartAll()
+
+	changed = false
+	for i := 0; i < 30 && !changed; i++ {
+		c.Log("Waiting for the restarted server to be noticed...")
+		time.Sleep(5e8)
+		changed = session.Resync()
+	}
+	c.Assert(changed, Equals, true)
+}
+
 func (s *S) TestTopologySyncWithSlaveSeed(c *C) {
 	// That's supposed to be a slave. Must run discovery
 	// and find out master to insert successfully.
@@ -1006,6 +1443,34 @@ func (s *S) TestSyncTimeout(c *C) {
 	c.Assert(started.After(time.Now().Add(-timeout*2)), Equals, true)
 }
 
+func (s *S) TestServerSelectionTimeout(c *C) {
+	if *fast {
+		c.Skip("-fast")
+	}
+
+	session, err := mgo.Dial("localhost:40021")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	result := &struct{ Host string }{}
+	err = session.Run("serverStatus", result)
+	c.Assert(err, IsNil)
+
+	// Kill the master, leaving the set without one until an election.
+	s.Stop(result.Host)
+	session.Refresh()
+
+	timeout := 2 * time.Second
+	session.SetSyncTimeout(time.Minute)
+	session.SetServerSelectionTimeout(timeout)
+
+	started := time.Now()
+	err = session.DB("mydb").C("mycoll").Insert(M{"n": 1})
+	c.Assert(err, ErrorMatches, "no reachable servers")
+	c.Assert(started.Before(time.Now().Add(-timeout)), Equals, true)
+	c.Assert(started.After(time.Now().Add(-timeout*3)), Equals, true)
+}
+
 func (s *S) TestDialWithTimeout(c *C) {
 	if *fast {
 		c.Skip("-fast")
@@ -1044,6 +1509,48 @@ func (s *S) TestSocketTimeout(c *C) {
 	result := struct{ Ok bool }{}
 	err = session.Run("getLastError", &result)
 	c.Assert(err, ErrorMatches, ".*: i/o timeout")
+	c.Assert(mgo.IsTimeout(err), Equals, true)
+	c.Assert(started.Before(time.Now().Add(-timeout)), Equals, true)
+	c.Assert(started.After(time.Now().Add(-timeout*2)), Equals, true)
+}
+
+func (s *S) TestRunWithTimeout(c *C) {
+	if *fast {
+		c.Skip("-fast")
+	}
+
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	session.SetSocketTimeout(time.Second)
+
+	// A generous timeout survives a server that takes longer than
+	// the session timeout to respond.
+	s.Freeze("localhost:40001")
+	go func() {
+		time.Sleep(3 * time.Second)
+		s.Thaw("localhost:40001")
+	}()
+
+	result := struct{ Ok bool }{}
+	err = session.RunWithTimeout(time.Minute, "ping", &result)
+	c.Assert(err, IsNil)
+	c.Assert(result.Ok, Equals, true)
+
+	// A tight timeout fails even though the session timeout is longer.
+	session.Refresh()
+	session.SetSocketTimeout(time.Minute)
+	err = session.Ping()
+	c.Assert(err, IsNil)
+
+	s.Freeze("localhost:40001")
+
+	timeout := 2 * time.Second
+	started := time.Now()
+	err = session.RunWithTimeout(timeout, "ping", &result)
+	c.Assert(err, ErrorMatches, ".*: i/o timeout")
+	c.Assert(mgo.IsTimeout(err), Equals, true)
 	c.Assert(started.Before(time.Now().Add(-timeout)), Equals, true)
 	c.Assert(started.After(time.Now().Add(-timeout*2)), Equals, true)
 }
@@ -1280,6 +1787,85 @@ func (s *S) countCommands(c *C, server, commandName string) (n int) {
 	return result.Metrics.Commands[commandName].Total
 }
 
+func (s *S) TestMongosReadPref(c *C) {
+	session, err := mgo.Dial("localhost:40021")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	ssresult := &struct{ Host string }{}
+	err = session.Run("serverStatus", ssresult)
+	c.Assert(err, IsNil)
+	master := ssresult.Host
+
+	// Ensure mongos is aware about the current topology.
+	s.Stop(":40201")
+	s.StartAll()
+
+	mongos, err := mgo.Dial("localhost:40202")
+	c.Assert(err, IsNil)
+	defer mongos.Close()
+
+	err = mongos.DB("mydb").C("mycoll").Insert(bson.M{"n": 1})
+	c.Assert(err, IsNil)
+
+	// Wait until all servers see the data.
+	for _, addr := range []string{"localhost:40021", "localhost:40022", "localhost:40023"} {
+		session, err := mgo.Dial(addr + "?connect=direct")
+		c.Assert(err, IsNil)
+		defer session.Close()
+		session.SetMode(mgo.Monotonic, true)
+		for i := 300; i >= 0; i-- {
+			n, err := session.DB("mydb").C("mycoll").Find(nil).Count()
+			c.Assert(err, IsNil)
+			if n == 1 {
+				break
+			}
+			if i == 0 {
+				c.Fatalf("Inserted data never reached %s", addr)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	q21a := s.countQueries(c, "localhost:40021")
+	q22a := s.countQueries(c, "localhost:40022")
+	q23a := s.countQueries(c, "localhost:40023")
+
+	// The session is Strong, but the query asks mongos for a secondary.
+	coll := mongos.DB("mydb").C("mycoll")
+	var result struct{ N int }
+	for i := 0; i != 5; i++ {
+		err = coll.Find(nil).SetMongosReadPref("secondary", nil).One(&result)
+		c.Assert(err, IsNil)
+		c.Assert(result.N, Equals, 1)
+	}
+
+	q21b := s.countQueries(c, "localhost:40021")
+	q22b := s.countQueries(c, "localhost:40022")
+	q23b := s.countQueries(c, "localhost:40023")
+
+	var masterDelta, slaveDelta int
+	switch hostPort(master) {
+	case "40021":
+		masterDelta = q21b - q21a
+		slaveDelta = (q22b - q22a) + (q23b - q23a)
+	case "40022":
+		masterDelta = q22b - q22a
+		slaveDelta = (q21b - q21a) + (q23b - q23a)
+	case "40023":
+		masterDelta = q23b - q23a
+		slaveDelta = (q21b - q21a) + (q22b - q22a)
+	default:
+		c.Fatal("Uh?")
+	}
+
+	c.Check(masterDelta, Equals, 0)
+	c.Check(slaveDelta, Equals, 5)
+
+	err = coll.Find(nil).SetMongosReadPref("bogus", nil).One(&result)
+	c.Assert(err, ErrorMatches, `unsupported read preference mode: "bogus"`)
+}
+
 func (s *S) TestMonotonicSlaveOkFlagWithMongos(c *C) {
 	if s.versionAtLeast(3, 4) {
 		c.Skip("fail on 3.4+ ? ")
@@ -1803,6 +2389,167 @@ func (s *S) TestCustomDialNew(c *C) {
 	}
 }
 
+// keepAliveRecorder records the keepalive period set on a connection.
+type keepAliveRecorder struct {
+	*net.TCPConn
+	periods chan time.Duration
+}
+
+func (conn keepAliveRecorder) SetKeepAlivePeriod(d time.Duration) error {
+	conn.periods <- d
+	return conn.TCPConn.SetKeepAlivePeriod(d)
+}
+
+func (s *S) TestDialKeepAlive(c *C) {
+	periods := make(chan time.Duration, 16)
+	dial := func(addr *mgo.ServerAddr) (net.Conn, error) {
+		conn, err := net.DialTCP("tcp", nil, addr.TCPAddr())
+		if err != nil {
+			return nil, err
+		}
+		return keepAliveRecorder{conn, periods}, nil
+	}
+	info := mgo.DialInfo{
+		Addrs:      []string{"localhost:40012"},
+		DialServer: dial,
+		KeepAlive:  30 * time.Second,
+	}
+
+	session, err := mgo.DialWithInfo(&info)
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	select {
+	case period := <-periods:
+		c.Assert(period, Equals, 30*time.Second)
+	case <-time.After(5 * time.Second):
+		c.Fatalf("keepalive period was never set")
+	}
+
+	// Changing it affects connections established afterwards.
+	session.SetKeepAlive(time.Minute)
+	for len(periods) > 0 {
+		<-periods
+	}
+	copies := make([]*mgo.Session, 3)
+	for i := range copies {
+		copies[i] = session.Copy()
+		defer copies[i].Close()
+		err = copies[i].Ping()
+		c.Assert(err, IsNil)
+	}
+	select {
+	case period := <-periods:
+		c.Assert(period, Equals, time.Minute)
+	case <-time.After(5 * time.Second):
+		c.Fatalf("keepalive period was never set")
+	}
+}
+
+func (s *S) TestDialMinPoolSize(c *C) {
+	info := mgo.DialInfo{
+		Addrs:       []string{"localhost:40001"},
+		MinPoolSize: 5,
+	}
+	session, err := mgo.DialWithInfo(&info)
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	// The connections are established by the time Dial returns.
+	stats := mgo.GetStats()
+	c.Assert(stats.SocketsAlive >= 5, Equals, true, Commentf("sockets alive: %d", stats.SocketsAlive))
+
+	// And they're idle, ready to be used.
+	c.Assert(stats.SocketsInUse, Equals, 1)
+
+	// Raising the minimum establishes more of them.
+	err = session.SetMinPoolSize(8)
+	c.Assert(err, IsNil)
+	stats = mgo.GetStats()
+	c.Assert(stats.SocketsAlive >= 8, Equals, true, Commentf("sockets alive: %d", stats.SocketsAlive))
+
+	// The pool limit caps it rather than blocking.
+	session.SetPoolLimit(10)
+	err = session.SetMinPoolSize(20)
+	c.Assert(err, IsNil)
+	stats = mgo.GetStats()
+	c.Assert(stats.SocketsInUse, Equals, 1)
+}
+
+func (s *S) TestCustomDialProxy(c *C) {
+	// A trivial forwarding proxy standing in for a bastion.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+	proxied := make(chan bool, 16)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			server, err := net.Dial("tcp", "localhost:40001")
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			proxied <- true
+			go func() {
+				io.Copy(server, conn)
+				server.Close()
+			}()
+			go func() {
+				io.Copy(conn, server)
+				conn.Close()
+			}()
+		}
+	}()
+
+	info := mgo.DialInfo{
+		Addrs: []string{"localhost:40001"},
+		DialServer: func(addr *mgo.ServerAddr) (net.Conn, error) {
+			return net.Dial("tcp", l.Addr().String())
+		},
+	}
+	session, err := mgo.DialWithInfo(&info)
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	err = session.DB("mydb").C("mycoll").Insert(M{"a": 1})
+	c.Assert(err, IsNil)
+	n, err := session.DB("mydb").C("mycoll").Find(M{"a": 1}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+
+	select {
+	case <-proxied:
+	default:
+		c.Fatalf("no connection went through the proxy")
+	}
+}
+
+func (s *S) TestDialTimeoutPerSeed(c *C) {
+	// A blackholed seed doesn't hold up the live one, as seeds are
+	// dialed in parallel.
+	started := time.Now()
+	info := mgo.DialInfo{
+		Addrs:   []string{"10.255.255.1:40001", "localhost:40001"},
+		Timeout: 10 * time.Second,
+	}
+	session, err := mgo.DialWithInfo(&info)
+	c.Assert(err, IsNil)
+	defer session.Close()
+	c.Assert(time.Since(started) < 2*time.Second, Equals, true)
+
+	// Later synchronizations give up on the dead seed quickly, rather
+	// than after the default 5 seconds per attempt.
+	session.SetDialTimeoutPerSeed(500 * time.Millisecond)
+	started = time.Now()
+	session.Resync()
+	c.Assert(time.Since(started) < 5*time.Second, Equals, true)
+	c.Assert(session.LiveServers(), DeepEquals, []string{"localhost:40001"})
+}
+
 func (s *S) TestPrimaryShutdownOnAuthShard(c *C) {
 	if *fast {
 		c.Skip("-fast")
@@ -2,6 +2,7 @@ package mgo
 
 import (
 	"net"
+	"sync"
 	"time"
 )
 
@@ -33,6 +34,52 @@ func HackSyncSocketTimeout(newTimeout time.Duration) (restore func()) {
 	return
 }
 
+// FakeClock is a clock that only moves when told to. If Step is set, each
+// call to Now advances the clock by Step after reading it.
+type FakeClock struct {
+	m     sync.Mutex
+	now   time.Time
+	Step  time.Duration
+	slept []time.Duration
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+	now := c.now
+	c.now = c.now.Add(c.Step)
+	return now
+}
+
+// Sleep advances the clock by d and returns immediately.
+func (c *FakeClock) Sleep(d time.Duration) {
+	c.m.Lock()
+	c.now = c.now.Add(d)
+	c.slept = append(c.slept, d)
+	c.m.Unlock()
+}
+
+func (c *FakeClock) Advance(d time.Duration) {
+	c.m.Lock()
+	c.now = c.now.Add(d)
+	c.m.Unlock()
+}
+
+// Slept returns the durations passed to Sleep so far.
+func (c *FakeClock) Slept() []time.Duration {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return append([]time.Duration(nil), c.slept...)
+}
+
+func HackClock(c *FakeClock) (restore func()) {
+	return setClock(c)
+}
+
 func (s *Session) Cluster() *mongoCluster {
 	return s.cluster()
 }
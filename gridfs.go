@@ -676,6 +676,10 @@ func (file *GridFile) insertChunk(data []byte) {
 // the origin of the file, 1 means relative to the current offset,
 // and 2 means relative to the end. It returns the new offset and
 // an error, if any.
+//
+// Only the chunk holding the new offset is loaded, so Seek together
+// with Read provides random access into large files, as required by
+// io.ReadSeeker consumers such as http.ServeContent.
 func (file *GridFile) Seek(offset int64, whence int) (pos int64, err error) {
 	file.m.Lock()
 	debugf("GridFile %p: seeking for %s (whence=%d)", file, offset, whence)
@@ -689,6 +693,9 @@ func (file *GridFile) Seek(offset int64, whence int) (pos int64, err error) {
 	default:
 		panic("unsupported whence value")
 	}
+	if offset < 0 {
+		return file.offset, errors.New("seek before start of file")
+	}
 	if offset > file.doc.Length {
 		return file.offset, errors.New("seek past end of file")
 	}
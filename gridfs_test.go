@@ -578,6 +578,52 @@ func (s *S) TestGridFSSeek(c *C) {
 	o, err = file.Seek(23, os.SEEK_SET)
 	c.Assert(err, ErrorMatches, "seek past end of file")
 	c.Assert(o, Equals, int64(3))
+
+	// Try seeking before start of file.
+	o, err = file.Seek(-4, os.SEEK_CUR)
+	c.Assert(err, ErrorMatches, "seek before start of file")
+	c.Assert(o, Equals, int64(3))
+	o, err = file.Seek(-23, os.SEEK_END)
+	c.Assert(err, ErrorMatches, "seek before start of file")
+	c.Assert(o, Equals, int64(3))
+}
+
+func (s *S) TestGridFSSeekRange(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFS("fs")
+	file, err := gfs.Create("")
+	c.Assert(err, IsNil)
+	id := file.Id()
+
+	file.SetChunkSize(4)
+
+	data := []byte("abcdefghijklmnopqrstuvwxyz")
+	_, err = file.Write(data)
+	c.Assert(err, IsNil)
+	err = file.Close()
+	c.Assert(err, IsNil)
+
+	file, err = gfs.OpenId(id)
+	c.Assert(err, IsNil)
+	defer file.Close()
+
+	// Read ranges spanning several chunks, as an HTTP range request would.
+	ranges := []struct{ start, end int }{{10, 19}, {2, 3}, {0, 26}, {23, 26}, {13, 14}}
+	for _, r := range ranges {
+		o, err := file.Seek(int64(r.start), os.SEEK_SET)
+		c.Assert(err, IsNil)
+		c.Assert(o, Equals, int64(r.start))
+
+		b := make([]byte, r.end-r.start)
+		_, err = io.ReadFull(file, b)
+		c.Assert(err, IsNil)
+		c.Assert(string(b), Equals, string(data[r.start:r.end]))
+	}
 }
 
 func (s *S) TestGridFSRemoveId(c *C) {
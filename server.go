@@ -69,6 +69,7 @@ type mongoServer struct {
 	abended       bool
 	minPoolSize   int
 	maxIdleTimeMS int
+	keepAlive     time.Duration
 	poolWaiter    *sync.Cond
 }
 
@@ -85,13 +86,18 @@ type mongoServerInfo struct {
 	Master         bool
 	Mongos         bool
 	Tags           bson.D
+	MinWireVersion int
 	MaxWireVersion int
 	SetName        string
+	SetSize        int // Data-bearing replica set members, or 0 if not in a set
+	MaxBsonSize    int // Largest document accepted, or 0 if unknown
+	MaxMessageSize int // Largest wire message accepted, or 0 if unknown
+	MaxWriteBatch  int // Most operations accepted in a write command, or 0 if unknown
 }
 
 var defaultServerInfo mongoServerInfo
 
-func newServer(addr string, tcpaddr *net.TCPAddr, syncChan chan bool, dial dialer, minPoolSize, maxIdleTimeMS int) *mongoServer {
+func newServer(addr string, tcpaddr *net.TCPAddr, syncChan chan bool, dial dialer, minPoolSize, maxIdleTimeMS int, keepAlive time.Duration) *mongoServer {
 	server := &mongoServer{
 		Addr:          addr,
 		ResolvedAddr:  tcpaddr.String(),
@@ -102,6 +108,7 @@ func newServer(addr string, tcpaddr *net.TCPAddr, syncChan chan bool, dial diale
 		pingValue:     time.Hour, // Push it back before an actual ping.
 		minPoolSize:   minPoolSize,
 		maxIdleTimeMS: maxIdleTimeMS,
+		keepAlive:     keepAlive,
 	}
 	server.poolWaiter = sync.NewCond(server)
 	go server.pinger(true)
@@ -235,6 +242,7 @@ func (server *mongoServer) Connect(timeout time.Duration) (*mongoSocket, error)
 	server.RLock()
 	master := server.info.Master
 	dial := server.dial
+	keepAlive := server.keepAlive
 	server.RUnlock()
 
 	logf("Establishing new connection to %s (timeout=%s)...", server.Addr, timeout)
@@ -245,9 +253,7 @@ func (server *mongoServer) Connect(timeout time.Duration) (*mongoSocket, error)
 		// Cannot do this because it lacks timeout support. :-(
 		//conn, err = net.DialTCP("tcp", nil, server.tcpaddr)
 		conn, err = net.DialTimeout("tcp", server.ResolvedAddr, timeout)
-		if tcpconn, ok := conn.(*net.TCPConn); ok {
-			tcpconn.SetKeepAlive(true)
-		} else if err == nil {
+		if _, ok := conn.(*net.TCPConn); !ok && err == nil {
 			panic("internal error: obtained TCP connection is not a *net.TCPConn!?")
 		}
 	case dial.old != nil:
@@ -263,23 +269,70 @@ func (server *mongoServer) Connect(timeout time.Duration) (*mongoSocket, error)
 	}
 	logf("Connection to %s established.", server.Addr)
 
+	if kconn, ok := conn.(keepAliveConn); ok && (keepAlive > 0 || !dial.isSet()) {
+		if err := setKeepAlive(kconn, keepAlive); err != nil {
+			logf("Cannot enable keepalive on connection to %s: %v", server.Addr, err)
+		}
+	}
+
 	stats.conn(+1, master)
 	return newSocket(server, conn, timeout), nil
 }
 
+// keepAliveConn is implemented by connections supporting TCP keepalive,
+// such as *net.TCPConn.
+type keepAliveConn interface {
+	SetKeepAlive(keepalive bool) error
+	SetKeepAlivePeriod(d time.Duration) error
+}
+
+// setKeepAlive enables keepalive on conn, probing idle connections every
+// period, or at the operating system's default interval if period is zero.
+func setKeepAlive(conn keepAliveConn, period time.Duration) error {
+	if err := conn.SetKeepAlive(true); err != nil {
+		return err
+	}
+	if period > 0 {
+		return conn.SetKeepAlivePeriod(period)
+	}
+	return nil
+}
+
+// SetKeepAlive sets the keepalive period of connections established from
+// now on.
+func (server *mongoServer) SetKeepAlive(period time.Duration) {
+	server.Lock()
+	server.keepAlive = period
+	server.Unlock()
+}
+
+// SetMinPoolSize sets how many idle sockets are spared from being closed
+// after the maximum idle time.
+func (server *mongoServer) SetMinPoolSize(n int) {
+	server.Lock()
+	server.minPoolSize = n
+	server.Unlock()
+}
+
 // Close forces closing all sockets that are alive, whether
 // they're currently in use or not.
 func (server *mongoServer) Close() {
-	server.close(false)
+	server.close(false, nil)
+}
+
+// CloseWithError works like Close, but operations in progress on the
+// sockets being closed fail with err.
+func (server *mongoServer) CloseWithError(err error) {
+	server.close(false, err)
 }
 
 // CloseIdle closing all sockets that are idle,
 // sockets currently in use will be closed after idle.
 func (server *mongoServer) CloseIdle() {
-	server.close(true)
+	server.close(true, nil)
 }
 
-func (server *mongoServer) close(waitForIdle bool) {
+func (server *mongoServer) close(waitForIdle bool, err error) {
 	server.Lock()
 	server.closed = true
 	liveSockets := server.liveSockets
@@ -291,6 +344,8 @@ func (server *mongoServer) close(waitForIdle bool) {
 	for i, s := range liveSockets {
 		if waitForIdle {
 			s.CloseAfterIdle()
+		} else if err != nil {
+			s.kill(err, false)
 		} else {
 			s.Close()
 		}
@@ -357,6 +412,15 @@ func (server *mongoServer) SetInfo(info *mongoServerInfo) {
 	server.Unlock()
 }
 
+// Stat returns the current pool state for the server.
+func (server *mongoServer) Stat() ServerStat {
+	server.Lock()
+	idle := len(server.unusedSockets)
+	stat := ServerStat{InUse: len(server.liveSockets) - idle, Idle: idle}
+	server.Unlock()
+	return stat
+}
+
 func (server *mongoServer) Info() *mongoServerInfo {
 	server.Lock()
 	info := server.info
@@ -539,6 +603,17 @@ func (servers *mongoServers) Remove(other *mongoServer) (server *mongoServer) {
 	return
 }
 
+// Without returns a copy of servers that does not include other.
+func (servers *mongoServers) Without(other *mongoServer) *mongoServers {
+	result := &mongoServers{}
+	for _, server := range servers.slice {
+		if server != other {
+			result.slice = append(result.slice, server)
+		}
+	}
+	return result
+}
+
 func (servers *mongoServers) Slice() []*mongoServer {
 	return ([]*mongoServer)(servers.slice)
 }
@@ -564,9 +639,19 @@ func (servers *mongoServers) HasMongos() bool {
 	return false
 }
 
+// defaultLocalThreshold is the difference in ping time under which
+// servers are considered equally near when choosing one of them.
+const defaultLocalThreshold = 15 * time.Millisecond
+
 // BestFit returns the best guess of what would be the most interesting
-// server to perform operations on at this point in time.
-func (servers *mongoServers) BestFit(mode Mode, serverTags []bson.D) *mongoServer {
+// server to perform operations on at this point in time.  Servers whose
+// ping times differ by no more than localThreshold are considered equally
+// near, in which case the least loaded one is preferred.  If localThreshold
+// is zero, defaultLocalThreshold is used.
+func (servers *mongoServers) BestFit(mode Mode, serverTags []bson.D, localThreshold time.Duration) *mongoServer {
+	if localThreshold <= 0 {
+		localThreshold = defaultLocalThreshold
+	}
 	var best *mongoServer
 	for _, next := range servers.slice {
 		if best == nil {
@@ -588,7 +673,7 @@ func (servers *mongoServers) BestFit(mode Mode, serverTags []bson.D) *mongoServe
 		case next.info.Master != best.info.Master && mode != Nearest:
 			// Prefer slaves, unless the mode is PrimaryPreferred.
 			swap = (mode == PrimaryPreferred) != best.info.Master
-		case absDuration(next.pingValue-best.pingValue) > 15*time.Millisecond:
+		case absDuration(next.pingValue-best.pingValue) > localThreshold:
 			// Prefer nearest server.
 			swap = next.pingValue < best.pingValue
 		case len(next.liveSockets)-len(next.unusedSockets) < len(best.liveSockets)-len(best.unusedSockets):
@@ -93,6 +93,18 @@ type Session struct {
 	sockTimeout      time.Duration
 	poolLimit        int
 	poolTimeout      time.Duration
+	selectTimeout    time.Duration
+	localThreshold   time.Duration
+	throttle         *opThrottle
+	checkSafe        bool
+	opHook           func(OpInfo)
+	retryReads       bool
+	slaveFallback    bool
+	baseSelector     interface{}
+	writeBuffering   bool
+	coerceIds        bool
+	iterResume       bool
+	deadline         time.Time
 	consistency      Mode
 	creds            []Credential
 	dialCred         *Credential
@@ -130,16 +142,41 @@ type Collection struct {
 }
 
 // Query keeps info on the query.
+//
+// Methods that adjust the query, such as Sort, Skip and Limit, leave the
+// receiver untouched and return an adjusted copy instead, so a base query
+// may be safely reused, including concurrently, to derive different
+// queries:
+//
+//     base := collection.Find(bson.M{"status": "A"}).Sort("-created")
+//     recent := base.Limit(10)
+//     older := base.Skip(10).Limit(10)
+//
+// The result of these methods must therefore be used:
+//
+//     query = query.Limit(10) // Not just query.Limit(10)
+//
+// This is a breaking change from earlier releases, in which these methods
+// modified the receiver: code adjusting a query without using the result,
+// as in query.Sort("n") followed by query.Iter(), silently runs the query
+// without the adjustment.  Methods adjusting a Pipe, such as Batch and
+// AllowDiskUse, still modify the receiver.
+//
 type Query struct {
 	m       sync.Mutex
 	session *Session
-	query   // Enables default settings in session.
+	err     error       // Reported when the query is run.
+	scope   interface{} // Base selector of the session, see SetBaseSelector.
+	query               // Enables default settings in session.
 }
 
 type query struct {
-	op       queryOp
-	prefetch float64
-	limit    int32
+	op          queryOp
+	prefetch    float64
+	limit       int32
+	batchBytes  int
+	decodeField string
+	decodeTypes map[string]reflect.Type
 }
 
 type getLastError struct {
@@ -173,6 +210,15 @@ type Iter struct {
 	isFindCmd      bool
 	isChangeStream bool
 	maxTimeMS      int64
+	batchBytes     int
+	docsSeen       int
+	bytesSeen      int
+	resumeOp       *queryOp
+	delivered      int
+	resumedAt      int
+	nextTimeout    time.Duration
+	decodeField    string
+	decodeTypes    map[string]reflect.Type
 }
 
 var (
@@ -181,6 +227,21 @@ var (
 	// ErrCursor error returned when trying to retrieve documents from
 	// an invalid cursor
 	ErrCursor = errors.New("invalid cursor")
+	// ErrStopped error returned by an iterator after its Stop method
+	// was called
+	ErrStopped = errors.New("iteration stopped")
+	// ErrClosed error returned when an operation needing a connection
+	// is attempted on a session that was closed
+	ErrClosed = errors.New("session closed")
+	// ErrDeadline error returned when an operation is attempted on a
+	// session obtained via CopyWithDeadline after its deadline passed
+	ErrDeadline = errors.New("session deadline exceeded")
+	// ErrTimeout error reported when the server didn't answer, or didn't
+	// accept a request, before the socket timeout set via SetSocketTimeout
+	// expired. A connection closed by the server yields io.EOF instead.
+	// The error returned is a *TimeoutError holding the original network
+	// error, which matches ErrTimeout with IsTimeout or errors.Is.
+	ErrTimeout = errors.New("i/o timeout")
 )
 
 const (
@@ -351,7 +412,7 @@ func ParseURL(url string) (*DialInfo, error) {
 				return nil, errors.New("bad value for maxPoolSize: " + opt.value)
 			}
 		case "appName":
-			if len(opt.value) > 128 {
+			if len(opt.value) > maxAppNameSize {
 				return nil, errors.New("appName too long, must be < 128 bytes: " + opt.value)
 			}
 			appName = opt.value
@@ -493,6 +554,9 @@ type DialInfo struct {
 	PoolTimeout time.Duration
 
 	// The identifier of the client application which ran the operation.
+	// It's sent to the server in the handshake of every new connection,
+	// and shows up in the server logs, the profiler and currentOp.
+	// See Session.SetAppName for details.
 	AppName string
 
 	// ReadPreference defines the manner in which servers are chosen. See
@@ -512,6 +576,9 @@ type DialInfo struct {
 	Direct bool
 
 	// MinPoolSize defines The minimum number of connections in the connection pool.
+	// That many connections to the primary are established and authenticated
+	// while dialing, so that the first operations don't pay for them, and idle
+	// connections are not closed below that number (see MaxIdleTimeMS).
 	// Defaults to 0.
 	MinPoolSize int
 
@@ -519,8 +586,17 @@ type DialInfo struct {
 	// before being removed and closed.
 	MaxIdleTimeMS int
 
+	// KeepAlive defines the interval between TCP keepalive probes sent on
+	// idle connections, so that NAT gateways and load balancers don't
+	// silently drop pooled connections. Defaults to zero, which keeps the
+	// operating system's interval. See Session.SetKeepAlive for details.
+	KeepAlive time.Duration
+
 	// DialServer optionally specifies the dial function for establishing
-	// connections with the MongoDB servers.
+	// connections with the MongoDB servers. When set, it's used instead of
+	// dialing addr over TCP for every connection the session makes,
+	// including seed, topology sync and pooled connections, so it may be
+	// used to route them through a SOCKS or HTTP proxy or an SSH tunnel.
 	DialServer func(addr *ServerAddr) (net.Conn, error)
 
 	// WARNING: This field is obsolete. See DialServer above.
@@ -601,6 +677,9 @@ func DialWithInfo(info *DialInfo) (*Session, error) {
 
 	cluster.minPoolSize = info.MinPoolSize
 	cluster.maxIdleTimeMS = info.MaxIdleTimeMS
+	if info.KeepAlive > 0 {
+		cluster.SetKeepAlive(info.KeepAlive)
+	}
 
 	if info.PoolTimeout > 0 {
 		session.poolTimeout = info.PoolTimeout
@@ -624,6 +703,12 @@ func DialWithInfo(info *DialInfo) (*Session, error) {
 		session.SetMode(Strong, true)
 	}
 
+	if info.MinPoolSize > 0 {
+		if err := session.warmPool(info.MinPoolSize); err != nil {
+			logf("Could not establish %d connections to the primary: %v", info.MinPoolSize, err)
+		}
+	}
+
 	return session, nil
 }
 
@@ -722,6 +807,18 @@ func copySession(session *Session, keepCreds bool) (s *Session) {
 		sockTimeout:      session.sockTimeout,
 		poolLimit:        session.poolLimit,
 		poolTimeout:      session.poolTimeout,
+		selectTimeout:    session.selectTimeout,
+		localThreshold:   session.localThreshold,
+		throttle:         session.throttle,
+		checkSafe:        session.checkSafe,
+		opHook:           session.opHook,
+		retryReads:       session.retryReads,
+		slaveFallback:    session.slaveFallback,
+		baseSelector:     session.baseSelector,
+		writeBuffering:   session.writeBuffering,
+		coerceIds:        session.coerceIds,
+		iterResume:       session.iterResume,
+		deadline:         session.deadline,
 		consistency:      session.consistency,
 		creds:            creds,
 		dialCred:         session.dialCred,
@@ -748,6 +845,38 @@ func (s *Session) LiveServers() (addrs []string) {
 	return addrs
 }
 
+// Resync forces a synchronization of the cluster topology, waits for it to
+// complete, and returns whether the set of known servers or the primary
+// changed as a result.  Unlike Refresh, it does not release the sockets
+// reserved by the session.
+func (s *Session) Resync() (changed bool) {
+	s.m.RLock()
+	cluster := s.cluster()
+	s.m.RUnlock()
+	return cluster.Resync()
+}
+
+// PrimaryServer returns the address of the server currently known
+// to be the primary of the cluster, as determined by the most recent
+// topology sync. An error is returned if no primary is known.
+func (s *Session) PrimaryServer() (addr string, err error) {
+	s.m.RLock()
+	addr, err = s.cluster().PrimaryServer()
+	s.m.RUnlock()
+	return addr, err
+}
+
+// ServerStats returns the number of in-use and idle sockets held in the
+// pool of each server known to the cluster, keyed by server address.
+// Unlike GetStats, which aggregates across all clusters, the result
+// reflects only the cluster this session belongs to.
+func (s *Session) ServerStats() map[string]ServerStat {
+	s.m.RLock()
+	cluster := s.cluster()
+	s.m.RUnlock()
+	return cluster.ServerStats()
+}
+
 // DB returns a value representing the named database. If name
 // is empty, the database name provided in the dialed URL is
 // used instead. If that is also empty, "test" is used as a
@@ -770,6 +899,13 @@ func (db *Database) C(name string) *Collection {
 	return &Collection{db, name, db.Name + "." + name}
 }
 
+// CreateCollection explicitly creates the named collection with details
+// of info.  It is a shortcut for db.C(name).Create(info); see the Create
+// method of Collection for details on the supported options.
+func (db *Database) CreateCollection(name string, info *CollectionInfo) error {
+	return db.C(name).Create(info)
+}
+
 // CreateView creates a view as the result of the applying the specified
 // aggregation pipeline to the source collection or view. Views act as
 // read-only collections, and are computed on demand during read operations.
@@ -843,13 +979,27 @@ func (db *Database) GridFS(prefix string) *GridFS {
 // For privilleged commands typically run on the "admin" database, see
 // the Run method in the Session type.
 //
+// If result is a *[]byte, the raw BSON reply is copied into it without
+// being unmarshalled, which is convenient when proxying replies.
+//
+// In the Eventual and Monotonic modes, read-only commands such as count,
+// distinct, aggregate without $out, collStats, dbStats and listIndexes,
+// and diagnostic commands answered by the server itself such as
+// serverStatus and isMaster, may run on a secondary.  So may maintenance
+// commands acting on the server answering them, such as fsync, compact,
+// profile and setParameter.  Any other command runs on the primary, which
+// in the Monotonic mode switches the session to it as a write would.
+//
 // Relevant documentation:
 //
 //     http://www.mongodb.org/display/DOCS/Commands
 //     http://www.mongodb.org/display/DOCS/List+of+Database+CommandSkips
 //
-func (db *Database) Run(cmd interface{}, result interface{}) error {
-	socket, err := db.Session.acquireSocket(true)
+func (db *Database) Run(cmd interface{}, result interface{}) (err error) {
+	trace := db.Session.traceOp("command", db.Name+".$cmd")
+	defer func() { trace.done(err) }()
+
+	socket, err := db.Session.acquireSocket(slaveOkCommand(cmd))
 	if err != nil {
 		return err
 	}
@@ -859,6 +1009,71 @@ func (db *Database) Run(cmd interface{}, result interface{}) error {
 	return db.run(socket, cmd, result)
 }
 
+// RunWithTimeout works like Run, but waits up to the provided timeout for the
+// database to respond to this command only, instead of the socket timeout set
+// for the session.  This is useful with long running administrative commands
+// that would otherwise require changing the socket timeout for the whole
+// session.  See the SetSocketTimeout method for details on the timeout.
+//
+// Note that while the command is in progress the timeout also applies to other
+// operations sharing the same socket, as is the case with sessions in Strong
+// or Monotonic mode.
+func (db *Database) RunWithTimeout(timeout time.Duration, cmd interface{}, result interface{}) (err error) {
+	trace := db.Session.traceOp("command", db.Name+".$cmd")
+	defer func() { trace.done(err) }()
+
+	socket, err := db.Session.acquireSocket(slaveOkCommand(cmd))
+	if err != nil {
+		return err
+	}
+	defer socket.Release()
+
+	db.Session.m.RLock()
+	sockTimeout := db.Session.sockTimeout
+	db.Session.m.RUnlock()
+
+	socket.SetTimeout(timeout)
+	defer socket.SetTimeout(sockTimeout)
+	return db.run(socket, cmd, result)
+}
+
+// RunIter issues a command that replies with a cursor, such as aggregate,
+// listCollections or listIndexes, and returns an iterator over all of its
+// results.  Documents from the first batch included in the reply are
+// provided first, and the remaining ones are fetched from the server in
+// further batches as necessary.  The batch size may be requested via the
+// cursor document of the command itself.  For example:
+//
+//     iter, err := db.RunIter(bson.D{
+//         {"aggregate", "mycoll"},
+//         {"pipeline", []bson.M{{"$match": bson.M{"n": 1}}}},
+//         {"cursor", bson.M{"batchSize": 100}},
+//     })
+//
+// An error is returned if the command fails or its reply holds no cursor.
+func (db *Database) RunIter(cmd interface{}) (*Iter, error) {
+	// Clone session and set it to Monotonic mode so that the server
+	// used for the command may be safely obtained afterwards, as the
+	// cursor is only available on the server that created it.
+	cloned := db.Session.nonEventual()
+	defer cloned.Close()
+
+	var result struct {
+		Cursor *cursorData
+	}
+	if err := db.With(cloned).Run(cmd, &result); err != nil {
+		return nil, err
+	}
+	if result.Cursor == nil {
+		return nil, errors.New("command reply holds no cursor")
+	}
+	c := db.With(cloned).C("")
+	if ns := strings.SplitN(result.Cursor.NS, ".", 2); len(ns) == 2 {
+		c = cloned.DB(ns[0]).C(ns[1])
+	}
+	return c.NewIter(db.Session, result.Cursor.FirstBatch, result.Cursor.Id, nil), nil
+}
+
 // runOnSocket does the same as Run, but guarantees that your command will be run
 // on the provided socket instance; if it's unhealthy, you will receive the error
 // from it.
@@ -913,7 +1128,9 @@ func (db *Database) Login(user, pass string) error {
 // Login authenticates with MongoDB using the provided credential.  The
 // authentication is valid for the whole session and will stay valid until
 // Logout is explicitly called for the same database, or the session is
-// closed.
+// closed.  Credentials are transparently applied to every socket the session
+// acquires afterwards, including sockets to a newly elected primary after a
+// failover, so there's no need to login again in that case.
 func (s *Session) Login(cred *Credential) error {
 	socket, err := s.acquireSocket(true)
 	if err != nil {
@@ -1460,7 +1677,8 @@ func parseIndexKey(key []string) (*indexKeyInfo, error) {
 }
 
 // EnsureIndexKey ensures an index with the given key exists, creating it
-// if necessary.
+// with the default options if necessary. A field name may be prefixed by
+// - (minus) for it to be indexed in descending order.
 //
 // This example:
 //
@@ -1530,6 +1748,8 @@ func (c *Collection) EnsureIndexKey(key ...string) error {
 //     err := collection.EnsureIndex(index)
 //
 // The example above requests the creation of a "2d" index for the "loc" field.
+// Similarly, a "$2dsphere:loc" key requests a "2dsphere" index, which supports
+// GeoJSON data and queries on an earth-like sphere, such as Query.Near.
 //
 // The 2D index bounds may be changed using the Min and Max attributes of the
 // Index value.  The default bound setting of (-180, 180) is suitable for
@@ -1901,12 +2121,47 @@ func (s *Session) Copy() *Session {
 	return scopy
 }
 
+// CopyStrong works just like Copy, but the returned session is in the Strong
+// consistency mode regardless of the mode of the original session, so that
+// its reads and writes go to the primary.  This is convenient for
+// write-after-read flows started from sessions in more relaxed modes.
+// The original session is not affected.
+func (s *Session) CopyStrong() *Session {
+	s.m.Lock()
+	scopy := copySession(s, true)
+	s.m.Unlock()
+	scopy.SetMode(Strong, true)
+	return scopy
+}
+
+// CopyWithDeadline works just like Copy, but all operations made through the
+// returned session, and sessions copied or cloned from it, must complete
+// within d from now.  Once the deadline passes, operations fail
+// with ErrDeadline without contacting the database, and operations in
+// progress have their socket timeout shortened so that they don't outlive
+// it, failing with a timeout error instead.  This is convenient for bounding
+// all the work done on behalf of a single request, such as in HTTP handlers:
+//
+//     session := globalSession.CopyWithDeadline(2 * time.Second)
+//     defer session.Close()
+//
+// The original session is not affected.
+func (s *Session) CopyWithDeadline(d time.Duration) *Session {
+	scopy := s.Copy()
+	scopy.m.Lock()
+	scopy.deadline = getClock().Now().Add(d)
+	scopy.m.Unlock()
+	return scopy
+}
+
 // Clone works just like Copy, but also reuses the same socket as the original
 // session, in case it had already reserved one due to its consistency
 // guarantees.  This behavior ensures that writes performed in the old session
 // are necessarily observed when using the new session, as long as it was a
 // strong or monotonic session.  That said, it also means that long operations
-// may cause other goroutines using the original session to wait.
+// may cause other goroutines using the original session to wait.  Use Copy
+// instead to obtain a session with the same settings and credentials that
+// acquires its own socket on first use.
 func (s *Session) Clone() *Session {
 	s.m.Lock()
 	scopy := copySession(s, true)
@@ -1915,7 +2170,13 @@ func (s *Session) Clone() *Session {
 }
 
 // Close terminates the session.  It's a runtime error to use a session
-// after it has been closed.
+// after it has been closed, but operations that need a connection to the
+// database, such as queries and writes, report ErrClosed rather than panic.
+//
+// Close may be called several times, including concurrently, and only the
+// first call has an effect. Once the last of the sessions created from the
+// same Dial call is closed, operations still waiting for the server, such
+// as a Next call blocked on a tailable cursor, fail with ErrClosed.
 func (s *Session) Close() {
 	s.m.Lock()
 	if s.mgoCluster != nil {
@@ -1936,6 +2197,7 @@ func (s *Session) cluster() *mongoCluster {
 
 // Refresh puts back any reserved sockets in use and restarts the consistency
 // guarantees according to the current consistency setting for the session.
+// Other session settings, such as the safety mode, are left unchanged.
 func (s *Session) Refresh() {
 	s.m.Lock()
 	s.slaveOk = s.consistency != Strong
@@ -2018,6 +2280,366 @@ func (s *Session) SetSyncTimeout(d time.Duration) {
 	s.m.Unlock()
 }
 
+// SetServerSelectionTimeout sets the amount of time a write operation with
+// this session will wait for a primary server to become available before
+// returning an error.  This allows writes to fail fast on a cluster that is
+// reachable but has no primary, while other operations keep waiting up to
+// the sync timeout (see SetSyncTimeout).  Set it to zero to have writes use
+// the sync timeout as well, which is the default.
+func (s *Session) SetServerSelectionTimeout(d time.Duration) {
+	s.m.Lock()
+	s.selectTimeout = d
+	s.m.Unlock()
+}
+
+// SetLocalThreshold sets the difference in ping time under which servers
+// are considered equally near when choosing which one to use for an
+// operation.  Among the servers acceptable for the session mode, those
+// within the threshold of each other are treated as equivalent and the least
+// loaded one is picked, while farther servers are avoided.  Ping times are
+// measured periodically by the driver.  Set it to zero to use the default
+// of 15 milliseconds.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/core/read-preference-mechanics/#default-threshold
+//
+func (s *Session) SetLocalThreshold(d time.Duration) {
+	s.m.Lock()
+	s.localThreshold = d
+	s.m.Unlock()
+}
+
+// SetOpsPerSecond limits the rate at which operations are sent to the
+// database through the session, delaying them as necessary so that no more
+// than n start within any one second.  This helps background jobs such as
+// bulk migrations avoid starving other traffic on the cluster.  Sessions
+// obtained via Copy, Clone or New after the call share the same limit, so
+// the rate holds across all goroutines using them.  Set n to zero to
+// disable throttling, which is the default.
+func (s *Session) SetOpsPerSecond(n int) {
+	s.m.Lock()
+	s.throttle = newOpThrottle(n)
+	s.m.Unlock()
+}
+
+// OpInfo describes an operation sent to the database through a session,
+// as reported to the hook set via SetOpHook.
+type OpInfo struct {
+	Op        string        // "query", "getMore", "command", "insert", "update" or "delete"
+	Namespace string        // "db.collection", or "db.$cmd" for commands
+	Started   time.Time     // When the operation was sent
+	Duration  time.Duration // Time until its reply was received
+	Err       error         // Error the operation failed with, if any
+}
+
+// SetOpHook sets a function to be called once each operation sent through
+// the session completes, for example to report tracing spans or metrics.
+// Sessions obtained via Copy, Clone or New after the call inherit the hook.
+// Set it to nil to stop reporting operations, which is the default.
+//
+// The hook may be called concurrently from multiple goroutines, including
+// the ones reading replies from the database, so it must be quick and must
+// not use the session. For iterators, the initial query and each getMore
+// are reported when their reply arrives, and Err only holds errors that
+// prevented the reply from being delivered; errors reported by the server
+// in the reply are available via Iter.Err.
+func (s *Session) SetOpHook(hook func(info OpInfo)) {
+	s.m.Lock()
+	s.opHook = hook
+	s.m.Unlock()
+}
+
+// SetRetryReads enables or disables retrying of reads that fail because the
+// connection to the server died while they were in progress.  When enabled,
+// such a read is retried once, preferably on a different server, as long as
+// the session is allowed to read from slaves (see SetMode).  Only Query.One
+// and the operations built on it are retried; iterators are bound to a cursor
+// on the server that created it and fail as usual.
+//
+// Retrying is disabled by default.
+func (s *Session) SetRetryReads(enabled bool) {
+	s.m.Lock()
+	s.retryReads = enabled
+	s.m.Unlock()
+}
+
+// SetSlaveFallbackToPrimary enables or disables falling back to the primary
+// for reads that may go to a slave when no acceptable slave is available,
+// rather than waiting for one until the sync timeout and failing with
+// "no reachable servers".  A slave is acceptable when it satisfies the mode
+// and the server tags set for the session.  Modes other than Secondary
+// already prefer the primary over failing when no slave is known, so the
+// fallback mostly matters for the Secondary mode and for tagged reads.
+//
+// Once the session falls back, it holds on to the primary connection like
+// any other reserved socket, until the session is refreshed.
+//
+// Falling back is disabled by default.
+func (s *Session) SetSlaveFallbackToPrimary(enabled bool) {
+	s.m.Lock()
+	s.slaveFallback = enabled
+	s.m.Unlock()
+}
+
+// SetWriteBuffering enables or disables buffering of writes performed
+// while the session is in unsafe mode (see SetSafe).  With buffering
+// enabled, such inserts, updates and removals are queued on the connection
+// and several of them are written to it at once, rather than issuing a
+// system call for each, which speeds up bulk loads considerably.
+//
+// Queued writes are sent once enough of them are accumulated, before any
+// other operation on the same connection, such as a query, and when the
+// connection is released by the session, as done by Refresh and Close.
+// This is most effective in the Strong and Monotonic modes, where the
+// session holds on to its connection to the primary between operations.
+//
+// To be queued, buffered writes are sent using the legacy wire protocol
+// operations rather than write commands, so they can't bypass document
+// validation (see SetBypassValidation).  As with any unsafe write, errors
+// aren't reported.
+//
+// Buffering is disabled by default.
+func (s *Session) SetWriteBuffering(enabled bool) {
+	s.m.Lock()
+	s.writeBuffering = enabled
+	s.m.Unlock()
+}
+
+// SetBaseSelector sets a selector document that is combined with the
+// selector of every query, update and remove issued through the session,
+// such as Find, FindId, Update, UpdateAll, Upsert, UpdateWith, Remove and
+// RemoveAll.  This is useful for scoping all operations of a session to a
+// single tenant of a shared collection, for example:
+//
+//     session.SetBaseSelector(bson.M{"tenant": tenantId})
+//
+// Both the base selector and the one provided to each operation must match
+// for a document to be affected.  When the two documents have no fields in
+// common they are merged into a single document, and otherwise they are
+// combined with $and, so the provided selector can narrow the base
+// selector but never override it.  Upserts insert the equality conditions
+// of the base selector into the new document as usual.
+//
+// The base selector is not applied to commands, to system collections,
+// to pipelines, to bulk operations or to inserts.  It applies to the
+// collections used by GridFS like any other collection, so a session with
+// a base selector set should not be used for GridFS unless the files and
+// chunks documents carry the scoped fields.
+//
+// Sessions created with Copy, Clone or New inherit the base selector of
+// the original session.  Setting it to nil disables it.
+func (s *Session) SetBaseSelector(selector interface{}) {
+	s.m.Lock()
+	s.baseSelector = selector
+	s.m.Unlock()
+}
+
+// canRetryRead returns whether a read that failed on socket may be retried
+// on another server.
+func (s *Session) canRetryRead(socket *mongoSocket) bool {
+	s.m.RLock()
+	retry := s.retryReads && s.slaveOk
+	s.m.RUnlock()
+	if !retry {
+		return false
+	}
+	socket.Lock()
+	dead := socket.dead != nil
+	socket.Unlock()
+	return dead
+}
+
+// opTrace reports a single operation to a session's hook.
+type opTrace struct {
+	once sync.Once
+	hook func(OpInfo)
+	info OpInfo
+}
+
+// traceOp starts tracing an operation, returning nil if the session has
+// no hook set.
+func (s *Session) traceOp(op, namespace string) *opTrace {
+	s.m.RLock()
+	hook := s.opHook
+	s.m.RUnlock()
+	if hook == nil {
+		return nil
+	}
+	return &opTrace{hook: hook, info: OpInfo{Op: op, Namespace: namespace, Started: time.Now()}}
+}
+
+// done reports the traced operation as completed with err. Only the first
+// call has an effect, and calling it on a nil trace is a no-op.
+func (t *opTrace) done(err error) {
+	if t == nil {
+		return
+	}
+	t.once.Do(func() {
+		t.info.Duration = time.Since(t.info.Started)
+		t.info.Err = err
+		t.hook(t.info)
+	})
+}
+
+// replyFunc returns a replyFunc calling f, that reports the traced
+// operation as done once the first reply arrives.
+func (t *opTrace) replyFunc(f replyFunc) replyFunc {
+	if t == nil {
+		return f
+	}
+	return func(err error, op *replyOp, docNum int, docData []byte) {
+		t.done(err)
+		f(err, op, docNum, docData)
+	}
+}
+
+// writeOpName returns the OpInfo name of a write operation.
+func writeOpName(op interface{}) string {
+	switch op.(type) {
+	case *updateOp, bulkUpdateOp:
+		return "update"
+	case *deleteOp, bulkDeleteOp:
+		return "delete"
+	}
+	return "insert"
+}
+
+// SetDialTimeoutPerSeed sets how long cluster synchronization waits for each
+// individual server to be dialed and to answer before giving up on it.  All
+// known servers are contacted in parallel, and operations proceed as soon as
+// a suitable one answers, so a short timeout keeps unreachable servers from
+// holding up synchronization.  The timeout is capped by the session's sync
+// timeout (see SetSyncTimeout), and zero restores the default of 5 seconds.
+//
+// The timeout applies to the cluster shared by this session and all sessions
+// copied or cloned from it, starting with the next synchronization.
+func (s *Session) SetDialTimeoutPerSeed(timeout time.Duration) {
+	s.m.Lock()
+	if s.syncTimeout > 0 && timeout > s.syncTimeout {
+		timeout = s.syncTimeout
+	}
+	s.cluster().SetSeedTimeout(timeout)
+	s.m.Unlock()
+}
+
+// SetKeepAlive sets the interval between TCP keepalive probes sent on idle
+// connections, so that intermediaries such as NAT gateways and load
+// balancers don't silently drop pooled connections, which would otherwise
+// surface as errors on their next use. Zero restores the operating
+// system's default interval.
+//
+// Keepalive is enabled by default on connections dialed by the driver.
+// With a custom DialInfo.Dial or DialServer function the interval is only
+// applied when set, and only if the returned connection supports it, as
+// *net.TCPConn does.
+//
+// The interval applies to the cluster shared by this session and all sessions
+// copied or cloned from it, for connections established from now on.
+func (s *Session) SetKeepAlive(period time.Duration) {
+	s.m.Lock()
+	s.cluster().SetKeepAlive(period)
+	s.m.Unlock()
+}
+
+// SetMinPoolSize sets the minimum number of connections kept open with each
+// server, and establishes and authenticates that many connections with the
+// primary right away, so that bursts of operations don't wait for them to
+// be dialed.  Idle connections are not closed below that number, as done
+// after the maximum idle time set via DialInfo.MaxIdleTimeMS.  No more
+// connections than the pool limit (see SetPoolLimit) are established.
+//
+// The setting applies to the cluster shared by this session and all sessions
+// copied or cloned from it.
+func (s *Session) SetMinPoolSize(n int) error {
+	s.m.Lock()
+	s.cluster().SetMinPoolSize(n)
+	s.m.Unlock()
+	return s.warmPool(n)
+}
+
+// warmPool establishes and authenticates n connections with the primary, or
+// as many as the pool limit allows, and puts them back in the pool.  The
+// session lock is not held while connections are established, so other
+// operations on the session may proceed meanwhile.
+func (s *Session) warmPool(n int) error {
+	s.m.RLock()
+	cluster := s.cluster()
+	syncTimeout := s.syncTimeout
+	sockTimeout := s.sockTimeout
+	poolLimit := s.poolLimit
+	poolTimeout := s.poolTimeout
+	creds := append([]Credential(nil), s.creds...)
+	s.m.RUnlock()
+
+	// Leave room for the socket the session may be holding on to.
+	if poolLimit > 0 && n > poolLimit-1 {
+		n = poolLimit - 1
+	}
+	sockets := make([]*mongoSocket, 0, n)
+	defer func() {
+		for _, socket := range sockets {
+			socket.Release()
+		}
+	}()
+	for len(sockets) < n {
+		socket, err := cluster.AcquireSocketWithPoolTimeout(
+			Strong, false, syncTimeout, sockTimeout, nil, poolLimit, poolTimeout, 0, nil,
+		)
+		if err == errPoolTimeout {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		sockets = append(sockets, socket)
+		for _, cred := range creds {
+			if err := socket.Login(cred); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// maxAppNameSize is the maximum size in bytes of the application name
+// sent in the connection handshake.
+const maxAppNameSize = 128
+
+// SetAppName sets the application name sent to the server in the handshake
+// of connections, so that it shows up in the server logs, the profiler
+// and the output of currentOp. It behaves like the AppName field of DialInfo
+// and the appName URL option, but may be changed after dialing.
+//
+// The name applies to the cluster shared by this session and all sessions
+// copied or cloned from it. Connections already established keep reporting
+// the name they were opened with. An error is returned if the name is
+// longer than 128 bytes, as the server would refuse the handshake.
+func (s *Session) SetAppName(appName string) error {
+	if len(appName) > maxAppNameSize {
+		return errors.New("appName too long, must be < 128 bytes: " + appName)
+	}
+	s.m.Lock()
+	s.cluster().SetAppName(appName)
+	s.m.Unlock()
+	return nil
+}
+
+// SetSyncBackoff sets the bounds of the delay between consecutive cluster
+// synchronization attempts that fail to find usable servers. The delay
+// starts at min and doubles with each failed attempt up to max, with some
+// random jitter so that a partitioned cluster isn't probed in a tight loop.
+// Setting min to zero restores the default fixed delay of 500 milliseconds.
+//
+// The backoff applies to the cluster shared by this session and all sessions
+// copied or cloned from it. The sync timeout still bounds how long an
+// operation waits for a usable server.
+func (s *Session) SetSyncBackoff(min, max time.Duration) {
+	s.m.Lock()
+	s.cluster().SetSyncBackoff(min, max)
+	s.m.Unlock()
+}
+
 // SetSocketTimeout sets the amount of time to wait for a non-responding
 // socket to the database before it is forcefully closed.
 //
@@ -2072,12 +2694,73 @@ func (s *Session) SetPoolTimeout(timeout time.Duration) {
 	s.m.Unlock()
 }
 
+// SetIterResume sets whether iterators obtained via Query.Iter, and the
+// methods built on it such as All and For, resume transparently when the
+// server reports that their cursor was not found, which happens for example
+// once it is left idle for longer than the server's cursor timeout.  When
+// enabled, the query is sent again skipping the documents already returned
+// by Next.  Otherwise, or if the new query can't make any progress, Next
+// returns false and the iterator reports ErrCursor.
+//
+// Resuming is only safe when the documents matched by the query and their
+// order don't change in the meantime, so queries should be sorted on a
+// unique key for it to produce consistent results.  Resuming is disabled by
+// default.
+func (s *Session) SetIterResume(enabled bool) {
+	s.m.Lock()
+	s.iterResume = enabled
+	s.m.Unlock()
+}
+
+// SetCoerceObjectIds sets whether string ids given to the FindId, UpdateId,
+// UpsertId and RemoveId collection methods are converted into bson.ObjectId
+// values.  When enabled, a string id must be the 24 characters long hex
+// representation of an ObjectId (see IsObjectIdHex), otherwise the
+// operation fails with an error rather than silently matching nothing.
+// Ids of other types are used as provided.
+//
+// Coercion is disabled by default, so that collections using string ids
+// are not affected.
+func (s *Session) SetCoerceObjectIds(enabled bool) {
+	s.m.Lock()
+	s.coerceIds = enabled
+	s.m.Unlock()
+}
+
+// IsObjectIdHex returns whether s is a valid hex representation of an
+// ObjectId, as accepted by bson.ObjectIdHex.
+func IsObjectIdHex(s string) bool {
+	return bson.IsObjectIdHex(s)
+}
+
+// idValue returns id as it should be sent to the database, converting
+// string ids into ObjectIds if the session was asked to.
+func (s *Session) idValue(id interface{}) (interface{}, error) {
+	str, ok := id.(string)
+	if !ok {
+		return id, nil
+	}
+	s.m.RLock()
+	coerce := s.coerceIds
+	s.m.RUnlock()
+	if !coerce {
+		return id, nil
+	}
+	if !bson.IsObjectIdHex(str) {
+		return nil, fmt.Errorf("invalid ObjectId %q: must be 24 hex characters", str)
+	}
+	return bson.ObjectIdHex(str), nil
+}
+
 // SetBypassValidation sets whether the server should bypass the registered
 // validation expressions executed when documents are inserted or modified,
 // in the interest of preserving invariants in the collection being modified.
 // The default is to not bypass, and thus to perform the validation
 // expressions registered for modified collections.
 //
+// The setting affects inserts, updates and upserts, including the ones
+// made via Bulk and Query.Apply.
+//
 // Document validation was introuced in MongoDB 3.2.
 //
 // Relevant documentation:
@@ -2107,6 +2790,16 @@ func (s *Session) SetBatch(n int) {
 	s.m.Unlock()
 }
 
+// Batch returns the default batch size used when fetching documents from
+// the database, as defined with SetBatch.  Zero means the batch size is
+// defined by the database itself.
+func (s *Session) Batch() int {
+	s.m.RLock()
+	n := int(s.queryConfig.op.limit)
+	s.m.RUnlock()
+	return n
+}
+
 // SetPrefetch sets the default point at which the next batch of results will be
 // requested.  When there are p*batch_size remaining documents cached in an
 // Iter, the next batch will be requested in background. For instance, when
@@ -2119,13 +2812,38 @@ func (s *Session) SetBatch(n int) {
 // next batch of 200 will be requested. It's possible to change this setting on
 // a per-query basis as well, using the Prefetch method of Query.
 //
+// The prefetch value must be within the [0, 1] range, and values outside of
+// it are clamped to the nearest bound. A value of 0 requests the next batch
+// only once all cached documents were processed, while 1 requests it as soon
+// as a batch is received.
+//
 // The default prefetch value is 0.25.
 func (s *Session) SetPrefetch(p float64) {
 	s.m.Lock()
-	s.queryConfig.prefetch = p
+	s.queryConfig.prefetch = clampPrefetch(p)
 	s.m.Unlock()
 }
 
+// Prefetch returns the default point at which the next batch of results
+// will be requested, as defined with SetPrefetch.
+func (s *Session) Prefetch() float64 {
+	s.m.RLock()
+	p := s.queryConfig.prefetch
+	s.m.RUnlock()
+	return p
+}
+
+// clampPrefetch limits the prefetch value p to the [0, 1] range.
+func clampPrefetch(p float64) float64 {
+	if !(p > 0) {
+		return 0
+	}
+	if p > 1 {
+		return 1
+	}
+	return p
+}
+
 // Safe session safety mode. See SetSafe for details on the Safe type.
 type Safe struct {
 	W        int    // Min # of servers to ack before success
@@ -2218,6 +2936,11 @@ func (s *Session) Safe() (safe *Safe) {
 //
 //     session.SetSafe(nil)
 //
+// The safety mode belongs to the session rather than to its connections,
+// and is applied to each write as it's sent.  It's therefore preserved by
+// Refresh and when the session moves to a new primary after a failover,
+// and is inherited by sessions obtained via Copy, Clone and New.
+//
 // See also the EnsureSafe method.
 //
 // Relevant documentation:
@@ -2268,6 +2991,19 @@ func (s *Session) EnsureSafe(safe *Safe) {
 	s.m.Unlock()
 }
 
+// SetSafeValidation enables or disables checking the write concern set via
+// SetSafe or EnsureSafe against the replica set topology before each write.
+// When enabled, a write requesting a numeric W larger than the number of
+// data-bearing members, or a "majority" WMode while fewer than a majority of
+// them are reachable, fails immediately with a descriptive error instead of
+// waiting for WTimeout to expire. Tag set modes and writes through mongos
+// are not checked.  Validation is disabled by default.
+func (s *Session) SetSafeValidation(enabled bool) {
+	s.m.Lock()
+	s.checkSafe = enabled
+	s.m.Unlock()
+}
+
 func (s *Session) ensureSafe(safe *Safe) {
 	if safe == nil {
 		return
@@ -2330,7 +3066,10 @@ func (s *Session) ensureSafe(safe *Safe) {
 //
 //     db.Run(bson.D{{"create", "mycollection"}, {"size", 1024}})
 //
-// For commands on arbitrary databases, see the Run method in
+// The command always runs against "admin", regardless of the default
+// database provided in the dialed URL, so it's the right choice for
+// administrative commands such as serverStatus, isMaster, listDatabases
+// and fsync. For commands on arbitrary databases, see the Run method in
 // the Database type.
 //
 // Relevant documentation:
@@ -2342,6 +3081,13 @@ func (s *Session) Run(cmd interface{}, result interface{}) error {
 	return s.DB("admin").Run(cmd, result)
 }
 
+// RunWithTimeout works like Run, but waits up to the provided timeout for the
+// database to respond to this command only.  See Database.RunWithTimeout
+// for details.
+func (s *Session) RunWithTimeout(timeout time.Duration, cmd interface{}, result interface{}) error {
+	return s.DB("admin").RunWithTimeout(timeout, cmd, result)
+}
+
 // runOnSocket does the same as Run, but guarantees that your command will be run
 // on the provided socket instance; if it's unhealthy, you will receive the error
 // from it.
@@ -2378,6 +3124,34 @@ func (s *Session) Ping() error {
 	return s.Run("ping", nil)
 }
 
+// WaitForPrimary blocks until the cluster has a primary that may be written
+// to, or until timeout has passed, in which case an error is
+// returned.  A zero timeout waits indefinitely.  The session itself is left
+// untouched, so it's convenient for ordering startup against a replica set
+// that is still electing its primary:
+//
+//     err := session.WaitForPrimary(30 * time.Second)
+//
+func (s *Session) WaitForPrimary(timeout time.Duration) error {
+	s.m.RLock()
+	if s.mgoCluster == nil {
+		s.m.RUnlock()
+		return ErrClosed
+	}
+	cluster := s.cluster()
+	sockTimeout := s.sockTimeout
+	poolLimit := s.poolLimit
+	poolTimeout := s.poolTimeout
+	s.m.RUnlock()
+
+	socket, err := cluster.AcquireSocketWithPoolTimeout(Primary, false, timeout, sockTimeout, nil, poolLimit, poolTimeout, 0, nil)
+	if err != nil {
+		return fmt.Errorf("no primary available after waiting %v: %v", timeout, err)
+	}
+	socket.Release()
+	return nil
+}
+
 // Fsync flushes in-memory writes to disk on the server the session
 // is established with. If async is true, the call returns immediately,
 // otherwise it returns after the flush has been made.
@@ -2423,22 +3197,74 @@ func (s *Session) FsyncUnlock() error {
 	return err
 }
 
-// Find prepares a query using the provided document.  The document may be a
-// map or a struct value capable of being marshalled with bson.  The map
-// may be a generic one using interface{} for its key and/or values, such as
-// bson.M, or it may be a properly typed map.  Providing nil as the document
-// is equivalent to providing an empty document such as bson.M{}.
-//
-// Further details of the query may be tweaked using the resulting Query value,
-// and then executed to retrieve results using methods such as One, For,
-// Iter, or Tail.
-//
-// In case the resulting document includes a field named $err or errmsg, which
-// are standard ways for MongoDB to return query errors, the returned err will
-// be set to a *QueryError value including the Err message and the Code.  In
-// those cases, the result argument is still unmarshalled into with the
-// received document so that any other custom values may be obtained if
-// desired.
+// OpDoc holds details about an operation in progress on the server, as
+// reported by CurrentOp.
+type OpDoc struct {
+	// OpId identifies the operation for KillOp. It's an integer when
+	// reported by a mongod, and a "shard:opid" string when reported by
+	// a mongos.
+	OpId             interface{} `bson:"opid"`
+	Active           bool
+	Op               string
+	Ns               string
+	Desc             string
+	Client           string
+	AppName          string `bson:"appName"`
+	SecsRunning      int    `bson:"secs_running"`
+	MicrosecsRunning int64  `bson:"microsecs_running"`
+	WaitingForLock   bool   `bson:"waitingForLock"`
+	Command          bson.M `bson:"command,omitempty"` // MongoDB 3.2+
+	Query            bson.M `bson:"query,omitempty"`
+}
+
+// CurrentOp returns the operations in progress on the server the session
+// is established with, so that runaway ones may be found and terminated
+// with KillOp.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/currentOp/
+//
+func (s *Session) CurrentOp() (ops []OpDoc, err error) {
+	var result struct{ Inprog []OpDoc }
+	err = s.Run(bson.D{{Name: "currentOp", Value: 1}}, &result)
+	if isNoCmd(err) {
+		err = s.DB("admin").C("$cmd.sys.inprog").Find(nil).One(&result)
+	}
+	return result.Inprog, err
+}
+
+// KillOp terminates the operation with the given opid, as reported in the
+// OpId field of the operations returned by CurrentOp.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/killOp/
+//
+func (s *Session) KillOp(opid interface{}) error {
+	err := s.Run(bson.D{{Name: "killOp", Value: 1}, {Name: "op", Value: opid}}, nil)
+	if isNoCmd(err) {
+		err = s.DB("admin").C("$cmd.sys.killop").Find(bson.M{"op": opid}).One(nil)
+	}
+	return err
+}
+
+// Find prepares a query using the provided document.  The document may be a
+// map or a struct value capable of being marshalled with bson.  The map
+// may be a generic one using interface{} for its key and/or values, such as
+// bson.M, or it may be a properly typed map.  Providing nil as the document
+// is equivalent to providing an empty document such as bson.M{}.
+//
+// Further details of the query may be tweaked using the resulting Query value,
+// and then executed to retrieve results using methods such as One, For,
+// Iter, or Tail.
+//
+// In case the resulting document includes a field named $err or errmsg, which
+// are standard ways for MongoDB to return query errors, the returned err will
+// be set to a *QueryError value including the Err message and the Code.  In
+// those cases, the result argument is still unmarshalled into with the
+// received document so that any other custom values may be obtained if
+// desired.
 //
 // Relevant documentation:
 //
@@ -2450,11 +3276,39 @@ func (c *Collection) Find(query interface{}) *Query {
 	session.m.RLock()
 	q := &Query{session: session, query: session.queryConfig}
 	session.m.RUnlock()
-	q.op.query = query
+	q.scope = c.baseSelector()
+	q.op.query = andSelectors(q.scope, query)
 	q.op.collection = c.FullName
 	return q
 }
 
+// baseSelector returns the base selector of the session, if any, unless
+// c is a command or system collection.  See Session.SetBaseSelector.
+func (c *Collection) baseSelector() interface{} {
+	if strings.HasPrefix(c.Name, "$cmd") || strings.HasPrefix(c.Name, "system.") {
+		return nil
+	}
+	session := c.Database.Session
+	session.m.RLock()
+	base := session.baseSelector
+	session.m.RUnlock()
+	return base
+}
+
+// scopedSelector returns selector combined with the base selector of the
+// session, if any.
+func (c *Collection) scopedSelector(selector interface{}) interface{} {
+	return andSelectors(c.baseSelector(), selector)
+}
+
+// clone returns a copy of q which may be adjusted without affecting q.
+func (q *Query) clone() *Query {
+	q.m.Lock()
+	cloned := &Query{session: q.session, err: q.err, scope: q.scope, query: q.query}
+	q.m.Unlock()
+	return cloned
+}
+
 type repairCmd struct {
 	RepairCursor string           `bson:"repairCursor"`
 	Cursor       *repairCmdCursor `bson:",omitempty"`
@@ -2498,7 +3352,10 @@ func (c *Collection) Repair() *Iter {
 //
 // See the Find method for more details.
 func (c *Collection) FindId(id interface{}) *Query {
-	return c.Find(bson.D{{Name: "_id", Value: id}})
+	id, err := c.Database.Session.idValue(id)
+	q := c.Find(bson.D{{Name: "_id", Value: id}})
+	q.err = err
+	return q
 }
 
 // Pipe is used to run aggregation queries against a
@@ -2698,6 +3555,36 @@ func (iter *Iter) State() (int64, []bson.Raw) {
 	return iter.op.cursorId, batch
 }
 
+// CursorId returns the id of the server cursor backing the iterator, or
+// zero if the server has no more results for it. The id may be persisted
+// and later handed to Collection.IterFromCursor to resume iteration.
+//
+// Documents already received by the iterator but not yet returned by Next
+// will not be sent again by the server, so the id is best captured once the
+// current batch has been consumed. See Iter.State for a way to preserve
+// those documents as well.
+func (iter *Iter) CursorId() int64 {
+	iter.m.Lock()
+	id := iter.op.cursorId
+	iter.m.Unlock()
+	return id
+}
+
+// IterFromCursor returns an iterator resuming the server cursor with the
+// given id, as previously obtained from Iter.CursorId, and fetching its
+// remaining documents in batches. It is a convenience equivalent to:
+//
+//     iter := collection.NewIter(nil, nil, cursorId, nil)
+//
+// The server discards cursors left idle for longer than its cursor timeout
+// (10 minutes by default) unless the original query disabled it with
+// SetCursorTimeout(0), in which case Next reports an error. Cursors are
+// also tied to the server that created them, so the same caveats as for
+// NewIter apply regarding the session mode.
+func (c *Collection) IterFromCursor(cursorId int64) *Iter {
+	return c.NewIter(nil, nil, cursorId, nil)
+}
+
 // All works like Iter.All.
 func (p *Pipe) All(result interface{}) error {
 	return p.Iter().All(result)
@@ -2765,6 +3652,98 @@ func (p *Pipe) SetMaxTime(d time.Duration) *Pipe {
 	return p
 }
 
+// PipeOutInfo stores details about a pipeline that wrote its results
+// into a collection via the $out stage.
+type PipeOutInfo struct {
+	Database   string // Output database
+	Collection string // Output collection
+	Count      int    // Number of documents in the output collection
+}
+
+// Out executes a pipeline whose last stage is $out and reports the
+// namespace that received the results along with the number of documents
+// it holds once the pipeline completes. An error is returned if the
+// pipeline does not end with a $out stage.
+//
+// For example:
+//
+//     pipe := collection.Pipe([]bson.M{
+//         {"$match": bson.M{"status": "A"}},
+//         {"$out": "archive"},
+//     })
+//     info, err := pipe.Out()
+//     if err == nil {
+//         fmt.Printf("Wrote %d documents to %s\n", info.Count, info.Collection)
+//     }
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/operator/aggregation/out/
+//
+func (p *Pipe) Out() (info *PipeOutInfo, err error) {
+	info, err = pipeOutTarget(p.pipeline)
+	if err != nil {
+		return nil, err
+	}
+	if info.Database == "" {
+		info.Database = p.collection.Database.Name
+	}
+	iter := p.Iter()
+	for iter.Next(&bson.Raw{}) {
+	}
+	if err = iter.Close(); err != nil {
+		return nil, err
+	}
+
+	// The output collection is written on the primary, so count there.
+	cloned := p.session.Clone()
+	defer cloned.Close()
+	cloned.SetMode(Strong, false)
+	info.Count, err = cloned.DB(info.Database).C(info.Collection).Count()
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// pipeOutTarget returns the namespace named by the $out stage that
+// terminates pipeline. The database is left empty when the stage only
+// names a collection.
+func pipeOutTarget(pipeline interface{}) (*PipeOutInfo, error) {
+	data, err := bson.Marshal(bson.M{"pipeline": pipeline})
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Pipeline []bson.D
+	}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if n := len(doc.Pipeline); n > 0 && len(doc.Pipeline[n-1]) == 1 {
+		stage := doc.Pipeline[n-1][0]
+		if stage.Name == "$out" {
+			switch out := stage.Value.(type) {
+			case string:
+				return &PipeOutInfo{Collection: out}, nil
+			case bson.D:
+				info := &PipeOutInfo{}
+				for _, elem := range out {
+					switch elem.Name {
+					case "db":
+						info.Database, _ = elem.Value.(string)
+					case "coll":
+						info.Collection, _ = elem.Value.(string)
+					}
+				}
+				if info.Collection != "" {
+					return info, nil
+				}
+			}
+		}
+	}
+	return nil, errors.New("pipeline does not end with a $out stage")
+}
 
 // Collation allows to specify language-specific rules for string comparison,
 // such as rules for lettercase and accent marks.
@@ -2784,6 +3763,11 @@ func (p *Pipe) Collation(collation *Collation) *Pipe {
 
 // LastError the error status of the preceding write operation on the current connection.
 //
+// The N field holds the number of documents affected by the operation, and
+// UpdatedExisting reports whether an update modified existing documents.
+// Successful write operations report these details through the ChangeInfo
+// value returned by methods such as UpdateAll, Upsert and RemoveAll.
+//
 // Relevant documentation:
 //
 //    https://docs.mongodb.com/manual/reference/command/getLastError/
@@ -2810,12 +3794,17 @@ type queryError struct {
 	ErrMsg        string
 	Assertion     string
 	Code          int
-	AssertionCode int `bson:"assertionCode"`
+	CodeName      string `bson:"codeName"`
+	AssertionCode int    `bson:"assertionCode"`
 }
 
-// QueryError is returned when a query fails
+// QueryError is returned when a query fails, and also when a command run
+// via Run or similar methods replies with {ok: 0} and an error message.
+// CodeName holds the symbolic name of Code as reported by MongoDB 3.4+
+// (for example "CommandNotFound"), and is empty otherwise.
 type QueryError struct {
 	Code      int
+	CodeName  string
 	Message   string
 	Assertion bool
 }
@@ -2824,6 +3813,88 @@ func (err *QueryError) Error() string {
 	return err.Message
 }
 
+// DocTooLargeError is returned by Insert and similar methods when one of
+// the documents provided is larger than the server accepts, in which case
+// nothing is sent to the server.
+type DocTooLargeError struct {
+	Index   int // Position of the document in the insertion
+	Size    int // Size of the document, in bytes
+	MaxSize int // Largest document size the server accepts, in bytes
+}
+
+func (err *DocTooLargeError) Error() string {
+	return fmt.Sprintf("document %d is %d bytes, larger than the maximum of %d bytes accepted by the server", err.Index, err.Size, err.MaxSize)
+}
+
+// defaultMaxBsonSize is the largest document size assumed to be accepted by
+// servers that don't report one.
+const defaultMaxBsonSize = 16 * 1024 * 1024
+
+// checkDocSizes marshals the documents of op, and returns an operation
+// inserting them as marshalled, or a *DocTooLargeError if any of them is
+// larger than maxSize bytes.
+func checkDocSizes(op *insertOp, maxSize int) (*insertOp, error) {
+	if maxSize <= 0 {
+		maxSize = defaultMaxBsonSize
+	}
+	docs := make([]interface{}, len(op.documents))
+	for i, doc := range op.documents {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > maxSize {
+			return nil, &DocTooLargeError{Index: i, Size: len(data), MaxSize: maxSize}
+		}
+		docs[i] = bson.Raw{Kind: 0x03, Data: data}
+	}
+	checked := *op
+	checked.documents = docs
+	return &checked, nil
+}
+
+// writeBatchLimits returns the largest number of operations, and the
+// largest total size of the documents, that a single write command sent to
+// a server described by info may hold.  Servers that don't report their
+// limits are assumed to accept 1000 operations, as old servers did.
+func writeBatchLimits(info *mongoServerInfo) (count, bytes int) {
+	count = info.MaxWriteBatch
+	if count <= 0 {
+		count = 1000
+	}
+	// The documents may take up to the maximum document size, as the server
+	// accepts commands up to 16KB larger than that, which leaves room for
+	// the fields other than the documents.
+	bytes = info.MaxBsonSize
+	if bytes <= 0 {
+		bytes = defaultMaxBsonSize
+	}
+	if info.MaxMessageSize > 0 && info.MaxMessageSize < bytes {
+		bytes = info.MaxMessageSize
+	}
+	return count, bytes
+}
+
+// insertBatchEnd returns the end of the batch of documents starting at
+// docs[start] that respects the provided limits.  A batch always holds at
+// least one document.  Only documents already marshalled into bson.Raw
+// values, as done by checkDocSizes, count towards the size limit.
+func insertBatchEnd(docs []interface{}, start, maxCount, maxBytes int) int {
+	end := start
+	size := 0
+	for end < len(docs) && end-start < maxCount {
+		if raw, ok := docs[end].(bson.Raw); ok {
+			// Each array element also holds its type and index.
+			size += len(raw.Data) + 2 + len(strconv.Itoa(end-start))
+			if end > start && size > maxBytes {
+				break
+			}
+		}
+		end++
+	}
+	return end
+}
+
 // IsDup returns whether err informs of a duplicate key error because
 // a primary key index or a secondary unique index already has an entry
 // with the given value.
@@ -2851,22 +3922,131 @@ func IsDup(err error) bool {
 // happens while inserting the provided documents, the returned error will
 // be of type *LastError.
 func (c *Collection) Insert(docs ...interface{}) error {
+	for _, doc := range docs {
+		if !isDocument(doc) {
+			return fmt.Errorf("Collection.Insert: cannot insert value of type %T as a document", doc)
+		}
+	}
 	_, err := c.writeOp(&insertOp{c.FullName, docs, 0}, true)
 	return err
 }
 
+// InsertSafe works like Insert, but applies the provided safety settings
+// to this operation only, regardless of the session's own settings as
+// defined by SetSafe. A nil safe value disables safety for the operation.
+// The session itself is left untouched, so other goroutines using it are
+// not affected.  For example, to make a single insert durable in an
+// otherwise unsafe session:
+//
+//     err := collection.InsertSafe(&mgo.Safe{FSync: true}, doc)
+//
+func (c *Collection) InsertSafe(safe *Safe, docs ...interface{}) error {
+	cloned := c.withSafe(safe)
+	defer cloned.Database.Session.Close()
+	return cloned.Insert(docs...)
+}
+
+// UpdateSafe works like Update, but applies the provided safety settings
+// to this operation only. See InsertSafe for details.
+func (c *Collection) UpdateSafe(safe *Safe, selector interface{}, update interface{}) error {
+	cloned := c.withSafe(safe)
+	defer cloned.Database.Session.Close()
+	return cloned.Update(selector, update)
+}
+
+// RemoveSafe works like Remove, but applies the provided safety settings
+// to this operation only. See InsertSafe for details.
+func (c *Collection) RemoveSafe(safe *Safe, selector interface{}) error {
+	cloned := c.withSafe(safe)
+	defer cloned.Database.Session.Close()
+	return cloned.Remove(selector)
+}
+
+// withSafe returns c bound to a clone of its session using the provided
+// safety settings. The clone reuses the session's socket, so operations
+// keep their ordering relative to the session. It must be closed after use.
+func (c *Collection) withSafe(safe *Safe) *Collection {
+	cloned := c.Database.Session.Clone()
+	cloned.SetSafe(safe)
+	return c.With(cloned)
+}
+
+// isDocument returns whether doc may be marshalled as a BSON document.
+func isDocument(doc interface{}) bool {
+	if _, ok := doc.(bson.Getter); ok {
+		return true
+	}
+	v := reflect.ValueOf(doc)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return true
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Invalid, reflect.Map, reflect.Struct:
+		return true
+	case reflect.Slice, reflect.Array:
+		switch v.Type().Elem() {
+		case reflect.TypeOf(bson.DocElem{}), reflect.TypeOf(bson.RawDocElem{}):
+			return true
+		}
+	}
+	return false
+}
+
+// InsertReturningId inserts the provided document in the respective
+// collection and returns the value of its _id field.  If the document has
+// no _id field, a new bson.ObjectId is generated client-side and added to
+// the document sent to the server, so the caller learns the id without
+// having to query for it again.  The provided document itself is not
+// modified.
+//
+// Errors are reported as documented in the Insert method.
+func (c *Collection) InsertReturningId(doc interface{}) (id interface{}, err error) {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var elems bson.RawD
+	if err := bson.Unmarshal(data, &elems); err != nil {
+		return nil, err
+	}
+	for _, elem := range elems {
+		if elem.Name == "_id" {
+			if err := elem.Value.Unmarshal(&id); err != nil {
+				return nil, err
+			}
+			if err := c.Insert(bson.Raw{Kind: 0x03, Data: data}); err != nil {
+				return nil, err
+			}
+			return id, nil
+		}
+	}
+	oid := bson.NewObjectId()
+	elems = append(bson.RawD{{Name: "_id", Value: bson.Raw{Kind: 0x07, Data: []byte(oid)}}}, elems...)
+	if err := c.Insert(elems); err != nil {
+		return nil, err
+	}
+	return oid, nil
+}
+
 // Update finds a single document matching the provided selector document
 // and modifies it according to the update document.
 // If the session is in safe mode (see SetSafe) a ErrNotFound error is
 // returned if a document isn't found, or a value of type *LastError
 // when some other error is detected.
 //
+// The update document is sent as provided, so a bson.D value may be used
+// when the order of its update operators or fields must be preserved.
+//
 // Relevant documentation:
 //
 //     http://www.mongodb.org/display/DOCS/Updating
 //     http://www.mongodb.org/display/DOCS/Atomic+Operations
 //
 func (c *Collection) Update(selector interface{}, update interface{}) error {
+	selector = c.scopedSelector(selector)
 	if selector == nil {
 		selector = bson.D{}
 	}
@@ -2888,6 +4068,10 @@ func (c *Collection) Update(selector interface{}, update interface{}) error {
 //
 // See the Update method for more details.
 func (c *Collection) UpdateId(id interface{}, update interface{}) error {
+	id, err := c.Database.Session.idValue(id)
+	if err != nil {
+		return err
+	}
 	return c.Update(bson.D{{Name: "_id", Value: id}}, update)
 }
 
@@ -2915,6 +4099,7 @@ type ChangeInfo struct {
 //     http://www.mongodb.org/display/DOCS/Atomic+Operations
 //
 func (c *Collection) UpdateAll(selector interface{}, update interface{}) (info *ChangeInfo, err error) {
+	selector = c.scopedSelector(selector)
 	if selector == nil {
 		selector = bson.D{}
 	}
@@ -2940,12 +4125,24 @@ func (c *Collection) UpdateAll(selector interface{}, update interface{}) (info *
 // operation are returned in info, or an error of type *LastError when
 // some problem is detected.
 //
+// The update document is sent as provided, so fields that should only be
+// set when a new document is inserted may be given via $setOnInsert next
+// to other operators, and are left alone when an existing document is
+// updated instead:
+//
+//     info, err := collection.Upsert(
+//         bson.M{"name": "counter"},
+//         bson.M{"$inc": bson.M{"n": 1}, "$setOnInsert": bson.M{"created": time.Now()}},
+//     )
+//
 // Relevant documentation:
 //
 //     http://www.mongodb.org/display/DOCS/Updating
 //     http://www.mongodb.org/display/DOCS/Atomic+Operations
+//     https://docs.mongodb.com/manual/reference/operator/update/setOnInsert/
 //
 func (c *Collection) Upsert(selector interface{}, update interface{}) (info *ChangeInfo, err error) {
+	selector = c.scopedSelector(selector)
 	if selector == nil {
 		selector = bson.D{}
 	}
@@ -2977,12 +4174,79 @@ func (c *Collection) Upsert(selector interface{}, update interface{}) (info *Cha
 	return info, err
 }
 
+// UpdateOptions holds the flags applied by UpdateWith.
+type UpdateOptions struct {
+	Multi  bool // Update all matching documents rather than just one
+	Upsert bool // Insert a document if none matches the selector
+}
+
+// UpdateWith finds documents matching the provided selector document and
+// modifies them according to the update document, as directed by opts.
+// When opts is nil it behaves as Update, modifying a single document.
+// Unlike the more specific helpers, it allows combining the multi and
+// upsert flags, updating every matching document or inserting a new one
+// if none matches.
+//
+// If the session is in safe mode (see SetSafe) details of the executed
+// operation are returned in info, or an error of type *LastError when
+// some problem is detected. Matching no documents without upserting is
+// not an error; info.Matched is zero in that case.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/method/db.collection.update/
+//
+func (c *Collection) UpdateWith(selector interface{}, update interface{}, opts *UpdateOptions) (info *ChangeInfo, err error) {
+	selector = c.scopedSelector(selector)
+	if selector == nil {
+		selector = bson.D{}
+	}
+	if opts == nil {
+		opts = &UpdateOptions{}
+	}
+	op := updateOp{
+		Collection: c.FullName,
+		Selector:   selector,
+		Update:     update,
+		Multi:      opts.Multi,
+		Upsert:     opts.Upsert,
+	}
+	if opts.Upsert {
+		op.Flags |= 1
+	}
+	if opts.Multi {
+		op.Flags |= 2
+	}
+	var lerr *LastError
+	for i := 0; i < maxUpsertRetries; i++ {
+		lerr, err = c.writeOp(&op, true)
+		// Retry duplicate key errors on upserts.
+		if !opts.Upsert || !IsDup(err) {
+			break
+		}
+	}
+	if err == nil && lerr != nil {
+		info = &ChangeInfo{}
+		if !opts.Upsert || lerr.UpdatedExisting {
+			info.Matched = lerr.N
+			info.Updated = lerr.modified
+		} else {
+			info.UpsertedId = lerr.UpsertedId
+		}
+	}
+	return info, err
+}
+
 // UpsertId is a convenience helper equivalent to:
 //
 //     info, err := collection.Upsert(bson.M{"_id": id}, update)
 //
 // See the Upsert method for more details.
 func (c *Collection) UpsertId(id interface{}, update interface{}) (info *ChangeInfo, err error) {
+	id, err = c.Database.Session.idValue(id)
+	if err != nil {
+		return nil, err
+	}
 	return c.Upsert(bson.D{{Name: "_id", Value: id}}, update)
 }
 
@@ -2997,6 +4261,7 @@ func (c *Collection) UpsertId(id interface{}, update interface{}) (info *ChangeI
 //     http://www.mongodb.org/display/DOCS/Removing
 //
 func (c *Collection) Remove(selector interface{}) error {
+	selector = c.scopedSelector(selector)
 	if selector == nil {
 		selector = bson.D{}
 	}
@@ -3013,19 +4278,27 @@ func (c *Collection) Remove(selector interface{}) error {
 //
 // See the Remove method for more details.
 func (c *Collection) RemoveId(id interface{}) error {
+	id, err := c.Database.Session.idValue(id)
+	if err != nil {
+		return err
+	}
 	return c.Remove(bson.D{{Name: "_id", Value: id}})
 }
 
 // RemoveAll finds all documents matching the provided selector document
 // and removes them from the database.  In case the session is in safe mode
 // (see the SetSafe method) and an error happens when attempting the change,
-// the returned error will be of type *LastError.
+// the returned error will be of type *LastError. Otherwise the Removed field
+// of the returned ChangeInfo holds the number of documents deleted, which is
+// zero rather than an ErrNotFound error when nothing matched the selector.
+// In unsafe mode the returned info is nil.
 //
 // Relevant documentation:
 //
 //     http://www.mongodb.org/display/DOCS/Removing
 //
 func (c *Collection) RemoveAll(selector interface{}) (info *ChangeInfo, err error) {
+	selector = c.scopedSelector(selector)
 	if selector == nil {
 		selector = bson.D{}
 	}
@@ -3037,13 +4310,62 @@ func (c *Collection) RemoveAll(selector interface{}) (info *ChangeInfo, err erro
 }
 
 // DropDatabase removes the entire database including all of its collections.
+// Indexes cached by EnsureIndex for those collections are forgotten as well.
 func (db *Database) DropDatabase() error {
-	return db.Run(bson.D{{Name: "dropDatabase", Value: 1}}, nil)
+	err := db.Run(bson.D{{Name: "dropDatabase", Value: 1}}, nil)
+	if err == ErrClosed {
+		return err
+	}
+	// Reset even on errors, as the database may have been dropped anyway.
+	db.Session.cluster().ResetIndexCachePrefix(db.Name + ".")
+	return err
 }
 
 // DropCollection removes the entire collection including all of its documents.
+//
+// Indexes of the collection cached by EnsureIndex are forgotten, so that
+// ensuring them again afterwards recreates them.
 func (c *Collection) DropCollection() error {
-	return c.Database.Run(bson.D{{Name: "drop", Value: c.Name}}, nil)
+	err := c.Database.Run(bson.D{{Name: "drop", Value: c.Name}}, nil)
+	if err == ErrClosed {
+		return err
+	}
+	c.Database.Session.cluster().ResetIndexCachePrefix(c.FullName + "\x00")
+	return err
+}
+
+// maintenanceTimeout is how long Repair and Compact wait for the database
+// to respond, as both may run for a long time on large data sets.
+const maintenanceTimeout = time.Hour
+
+// Repair runs the repairDatabase command, rebuilding the database and its
+// indexes from the data files. It may run for a long time, so the socket
+// timeout set for the session is extended for this command to an hour.
+// The repair is performed on the server selected by the session mode.
+//
+// The repairDatabase command was removed in MongoDB 4.2.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/v4.0/reference/command/repairDatabase/
+//
+func (db *Database) Repair() error {
+	return db.RunWithTimeout(maintenanceTimeout, bson.D{{Name: "repairDatabase", Value: 1}}, nil)
+}
+
+// Compact runs the compact command on the collection, defragmenting its
+// data and indexes. It may run for a long time, so the socket timeout set
+// for the session is extended for this command to an hour. The collection
+// is compacted on the server selected by the session mode, and depending on
+// the storage engine and server version, other operations on the database
+// may be blocked while it runs.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/compact/
+//
+func (c *Collection) Compact() error {
+	return c.Database.RunWithTimeout(maintenanceTimeout, bson.D{{Name: "compact", Value: c.Name}}, nil)
 }
 
 // The CollectionInfo type holds metadata about a collection.
@@ -3144,78 +4466,444 @@ func (c *Collection) Create(info *CollectionInfo) error {
 		cmd = append(cmd, bson.DocElem{Name: "collation", Value: info.Collation})
 	}
 
-	return c.Database.Run(cmd, nil)
+	return c.Database.Run(cmd, nil)
+}
+
+// Batch sets the batch size used when fetching documents from the database.
+// It's possible to change this setting on a per-session basis as well, using
+// the Batch method of Session.
+//
+// The default batch size is defined by the database itself.  As of this
+// writing, MongoDB will use an initial size of min(100 docs, 4MB) on the
+// first batch, and 4MB on remaining ones.
+func (q *Query) Batch(n int) *Query {
+	if n == 1 {
+		// Server interprets 1 as -1 and closes the cursor (!?)
+		n = 2
+	}
+	q = q.clone()
+	q.m.Lock()
+	q.op.limit = int32(n)
+	q.m.Unlock()
+	return q
+}
+
+// BatchBytes asks for batches of approximately n bytes rather than of a
+// fixed number of documents. Once the first batch is received, the number
+// of documents requested in each following batch is derived from the
+// budget and the average size of all documents received so far, which
+// smooths memory use when iterating over documents of varying sizes.
+// The first batch has the size set via Batch, or the server default.
+// Each batch holds at least two documents, even if they exceed the budget.
+//
+// A value of zero, the default, disables the adjustment.
+func (q *Query) BatchBytes(n int) *Query {
+	q = q.clone()
+	q.m.Lock()
+	q.batchBytes = n
+	q.m.Unlock()
+	return q
+}
+
+// bytesBatchSize returns the number of documents fitting in a batch of
+// budget bytes, given that docs documents received so far took bytes.
+func bytesBatchSize(budget, bytes, docs int) int32 {
+	avg := bytes / docs
+	if avg < 1 {
+		avg = 1
+	}
+	n := budget / avg
+	if n > math.MaxInt32 {
+		n = math.MaxInt32
+	}
+	if n < 2 {
+		// Server interprets 1 as -1 and closes the cursor.
+		n = 2
+	}
+	return int32(n)
+}
+
+// Prefetch sets the point at which the next batch of results will be requested.
+// When there are p*batch_size remaining documents cached in an Iter, the next
+// batch will be requested in background. For instance, when using this:
+//
+//     query = query.Batch(200).Prefetch(0.25)
+//
+// and there are only 50 documents cached in the Iter to be processed, the
+// next batch of 200 will be requested. It's possible to change this setting on
+// a per-session basis as well, using the SetPrefetch method of Session, and
+// the value set on the query takes precedence over the session default.
+//
+// The prefetch value must be within the [0, 1] range, and values outside of
+// it are clamped to the nearest bound.
+//
+// The default prefetch value is 0.25.
+func (q *Query) Prefetch(p float64) *Query {
+	q = q.clone()
+	q.m.Lock()
+	q.prefetch = clampPrefetch(p)
+	q.m.Unlock()
+	return q
+}
+
+// Skip skips over the n initial documents from the query results.  Note that
+// this only makes sense with capped collections where documents are naturally
+// ordered by insertion time, or with sorted results.
+func (q *Query) Skip(n int) *Query {
+	return q.SkipN(int64(n))
+}
+
+// SkipN works like Skip, but takes an int64 so that offsets beyond the
+// range of int on 32-bit platforms may be used.  Skipping over more than
+// 2^31-1 documents is only supported by MongoDB 3.2 and later.
+func (q *Query) SkipN(n int64) *Query {
+	q = q.clone()
+	q.m.Lock()
+	q.op.skip = n
+	q.m.Unlock()
+	return q
+}
+
+// Limit restricts the maximum number of documents retrieved to n, and also
+// changes the batch size to the same value.  Once n documents have been
+// returned by Next, the following call will return ErrNotFound.
+func (q *Query) Limit(n int) *Query {
+	q = q.clone()
+	q.m.Lock()
+	switch {
+	case n == 1:
+		q.limit = 1
+		q.op.limit = -1
+	case n == math.MinInt32: // -MinInt32 == -MinInt32
+		q.limit = math.MaxInt32
+		q.op.limit = math.MinInt32 + 1
+	case n < 0:
+		q.limit = int32(-n)
+		q.op.limit = int32(n)
+	default:
+		q.limit = int32(n)
+		q.op.limit = int32(n)
+	}
+	q.m.Unlock()
+	return q
+}
+
+// And adds the conditions in the provided selector document to the query
+// filter. When the selector and the current filter have no fields in common
+// their conditions are merged into a single document, and otherwise both are
+// combined under an $and operator so neither condition is lost. For example,
+// the following query finds documents with n in the [10, 20) range:
+//
+//     query := collection.Find(bson.M{"n": bson.M{"$gte": 10}})
+//     err := query.And(bson.M{"n": bson.M{"$lt": 20}}).All(&result)
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/operator/query/and/
+//
+func (q *Query) And(selector interface{}) *Query {
+	q = q.clone()
+	q.m.Lock()
+	q.op.query = andSelectors(q.op.query, selector)
+	q.m.Unlock()
+	return q
+}
+
+// Or restricts the query to documents matching either the current filter or
+// any of the provided selector documents, combining them under an $or
+// operator. When the query has no filter yet, or an empty one, only the
+// provided selectors are combined. For example, the following query finds
+// documents where n is either 42 or 44:
+//
+//     query := collection.Find(bson.M{"n": 42})
+//     err := query.Or(bson.M{"n": 44}).All(&result)
+//
+// The base selector of the session, if any, applies to all alternatives
+// (see Session.SetBaseSelector).
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/operator/query/or/
+//
+func (q *Query) Or(selectors ...interface{}) *Query {
+	q = q.clone()
+	q.m.Lock()
+	// The alternatives must not escape the base selector of the session.
+	q.op.query = andSelectors(q.scope, orSelectors(q.op.query, selectors))
+	q.m.Unlock()
+	return q
+}
+
+// orSelectors returns a selector document matching documents that satisfy
+// selector or any of the others. A nil or empty selector is left out, as it
+// would match every document.
+func orSelectors(selector interface{}, others []interface{}) interface{} {
+	if len(others) == 0 {
+		return selector
+	}
+	conds := make([]interface{}, 0, len(others)+1)
+	var sd bson.RawD
+	if selector != nil {
+		if data, err := bson.Marshal(selector); err != nil || bson.Unmarshal(data, &sd) != nil || len(sd) > 0 {
+			conds = append(conds, selector)
+		}
+	}
+	return bson.D{{Name: "$or", Value: append(conds, others...)}}
+}
+
+// andSelectors returns a selector document matching documents that
+// satisfy both a and b.
+func andSelectors(a, b interface{}) interface{} {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	var ad, bd bson.RawD
+	if data, err := bson.Marshal(a); err != nil || bson.Unmarshal(data, &ad) != nil {
+		return bson.D{{Name: "$and", Value: []interface{}{a, b}}}
+	}
+	if data, err := bson.Marshal(b); err != nil || bson.Unmarshal(data, &bd) != nil {
+		return bson.D{{Name: "$and", Value: []interface{}{a, b}}}
+	}
+	names := make(map[string]bool, len(ad))
+	for _, elem := range ad {
+		names[elem.Name] = true
+	}
+	for _, elem := range bd {
+		if names[elem.Name] {
+			return bson.D{{Name: "$and", Value: []interface{}{a, b}}}
+		}
+	}
+	return append(ad, bd...)
+}
+
+// Gt restricts the query to documents where field is greater than value.
+// Conditions on the same field set via Gt, Gte, Lt and Lte are accumulated
+// into a single operator document, so that for example the following query
+// finds documents with n in the [42, 45) range:
+//
+//     query := collection.Find(nil).Gte("n", 42).Lt("n", 45)
+//
+// See And for combining arbitrary conditions.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/operator/query-comparison/
+//
+func (q *Query) Gt(field string, value interface{}) *Query {
+	return q.fieldOperator(field, "$gt", value)
+}
+
+// Gte restricts the query to documents where field is greater than or
+// equal to value. See Gt for details.
+func (q *Query) Gte(field string, value interface{}) *Query {
+	return q.fieldOperator(field, "$gte", value)
 }
 
-// Batch sets the batch size used when fetching documents from the database.
-// It's possible to change this setting on a per-session basis as well, using
-// the Batch method of Session.
+// Lt restricts the query to documents where field is less than value.
+// See Gt for details.
+func (q *Query) Lt(field string, value interface{}) *Query {
+	return q.fieldOperator(field, "$lt", value)
+}
+
+// Lte restricts the query to documents where field is less than or equal
+// to value. See Gt for details.
+func (q *Query) Lte(field string, value interface{}) *Query {
+	return q.fieldOperator(field, "$lte", value)
+}
+
+// Exists restricts the query to documents that have field set, when exists
+// is true, or that lack it otherwise.  Fields set to null count as present.
+// See Gt for how conditions on the same field are combined.
 //
-// The default batch size is defined by the database itself.  As of this
-// writing, MongoDB will use an initial size of min(100 docs, 4MB) on the
-// first batch, and 4MB on remaining ones.
-func (q *Query) Batch(n int) *Query {
-	if n == 1 {
-		// Server interprets 1 as -1 and closes the cursor (!?)
-		n = 2
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/operator/query/exists/
+//
+func (q *Query) Exists(field string, exists bool) *Query {
+	return q.fieldOperator(field, "$exists", exists)
+}
+
+// In restricts the query to documents where field holds any of the
+// provided values, which must be a slice or an array of any type:
+//
+//     query := collection.Find(nil).In("n", []int{42, 44})
+//
+// An empty slice matches no documents.  Other kinds of values cause the
+// query to fail with an error once run.  See Gt for how conditions on the
+// same field are combined.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/operator/query/in/
+//
+func (q *Query) In(field string, values interface{}) *Query {
+	v := reflect.ValueOf(values)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		q = q.clone()
+		q.m.Lock()
+		q.err = fmt.Errorf("In requires a slice or array of values, got %T", values)
+		q.m.Unlock()
+		return q
 	}
-	q.m.Lock()
-	q.op.limit = int32(n)
-	q.m.Unlock()
-	return q
+	list := make([]interface{}, v.Len())
+	for i := range list {
+		list[i] = v.Index(i).Interface()
+	}
+	return q.fieldOperator(field, "$in", list)
 }
 
-// Prefetch sets the point at which the next batch of results will be requested.
-// When there are p*batch_size remaining documents cached in an Iter, the next
-// batch will be requested in background. For instance, when using this:
+// Near restricts the query to documents where field holds a location within
+// maxDistance meters of the point at the given longitude and latitude, and
+// sorts them from the nearest to the farthest.  A maxDistance of zero leaves
+// the distance unbounded.  The field must be covered by a "2dsphere" index
+// (see the "$2dsphere:" prefix in Index.Key) and hold GeoJSON data, such as:
 //
-//     query.Batch(200).Prefetch(0.25)
+//     bson.M{"loc": bson.M{"type": "Point", "coordinates": []float64{lng, lat}}}
 //
-// and there are only 50 documents cached in the Iter to be processed, the
-// next batch of 200 will be requested. It's possible to change this setting on
-// a per-session basis as well, using the SetPrefetch method of Session.
+// Queries using Near can't be counted with Count, as the server doesn't
+// accept $near in the count command.
 //
-// The default prefetch value is 0.25.
-func (q *Query) Prefetch(p float64) *Query {
-	q.m.Lock()
-	q.prefetch = p
-	q.m.Unlock()
-	return q
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/operator/query/near/
+//     https://docs.mongodb.com/manual/core/2dsphere/
+//
+func (q *Query) Near(field string, lng, lat float64, maxDistance float64) *Query {
+	near := bson.D{{Name: "$geometry", Value: bson.D{
+		{Name: "type", Value: "Point"},
+		{Name: "coordinates", Value: []float64{lng, lat}},
+	}}}
+	if maxDistance > 0 {
+		near = append(near, bson.DocElem{Name: "$maxDistance", Value: maxDistance})
+	}
+	return q.fieldOperator(field, "$near", near)
 }
 
-// Skip skips over the n initial documents from the query results.  Note that
-// this only makes sense with capped collections where documents are naturally
-// ordered by insertion time, or with sorted results.
-func (q *Query) Skip(n int) *Query {
+func (q *Query) fieldOperator(field, op string, value interface{}) *Query {
+	q = q.clone()
 	q.m.Lock()
-	q.op.skip = int32(n)
+	q.op.query = addFieldOperator(q.op.query, field, op, value)
 	q.m.Unlock()
 	return q
 }
 
-// Limit restricts the maximum number of documents retrieved to n, and also
-// changes the batch size to the same value.  Once n documents have been
-// returned by Next, the following call will return ErrNotFound.
-func (q *Query) Limit(n int) *Query {
+// addFieldOperator returns a selector document matching documents that
+// satisfy selector and have field compared to value by the op operator.
+// The operator is merged into the ones selector already applies to field
+// when possible, and otherwise both conditions are combined as And does.
+func addFieldOperator(selector interface{}, field, op string, value interface{}) interface{} {
+	cond := bson.D{{Name: field, Value: bson.D{{Name: op, Value: value}}}}
+	if selector == nil {
+		return cond
+	}
+	var sd bson.RawD
+	if data, err := bson.Marshal(selector); err != nil || bson.Unmarshal(data, &sd) != nil {
+		return andSelectors(selector, cond)
+	}
+	result := make(bson.D, 0, len(sd)+1)
+	merged := false
+	for _, elem := range sd {
+		if elem.Name != field {
+			result = append(result, bson.DocElem{Name: elem.Name, Value: elem.Value})
+			continue
+		}
+		ops, ok := operatorDocument(elem.Value)
+		if !ok {
+			return andSelectors(selector, cond)
+		}
+		for _, opElem := range ops {
+			if opElem.Name == op {
+				return andSelectors(selector, cond)
+			}
+		}
+		result = append(result, bson.DocElem{Name: field, Value: append(ops, bson.DocElem{Name: op, Value: value})})
+		merged = true
+	}
+	if !merged {
+		result = append(result, cond[0])
+	}
+	return result
+}
+
+// operatorDocument returns raw unmarshalled as a document if it's made only
+// of query operators, such as {"$gte": 1, "$lt": 2}.
+func operatorDocument(raw bson.Raw) (bson.D, bool) {
+	if raw.Kind != 0x03 {
+		return nil, false
+	}
+	var doc bson.D
+	if raw.Unmarshal(&doc) != nil || len(doc) == 0 {
+		return nil, false
+	}
+	for _, elem := range doc {
+		if !strings.HasPrefix(elem.Name, "$") {
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// TextSearch restricts the query to documents matching the provided terms
+// in a text index of the collection, as the $text query operator does.  It
+// also includes the relevance score computed by the server in the "score"
+// field of the returned documents, and unless a sort order was already
+// defined for the query, sorts the results by decreasing score.  For example:
+//
+//     var results []struct {
+//         Title string
+//         Score float64
+//     }
+//     err := collection.Find(nil).TextSearch("coffee shop").All(&results)
+//
+// The collection must have a text index (see the "$text:" prefix in
+// EnsureIndex), otherwise the server reports an error when the query runs.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/operator/query/text/
+//     https://docs.mongodb.com/manual/reference/operator/projection/meta/
+//
+func (q *Query) TextSearch(terms string) *Query {
+	score := bson.D{{Name: "score", Value: bson.M{"$meta": "textScore"}}}
+	q = q.clone()
 	q.m.Lock()
-	switch {
-	case n == 1:
-		q.limit = 1
-		q.op.limit = -1
-	case n == math.MinInt32: // -MinInt32 == -MinInt32
-		q.limit = math.MaxInt32
-		q.op.limit = math.MinInt32 + 1
-	case n < 0:
-		q.limit = int32(-n)
-		q.op.limit = int32(n)
-	default:
-		q.limit = int32(n)
-		q.op.limit = int32(n)
+	q.op.query = andSelectors(q.op.query, bson.D{{Name: "$text", Value: bson.M{"$search": terms}}})
+	if q.op.selector == nil {
+		q.op.selector = score
+	} else if merged, ok := andSelectors(q.op.selector, score).(bson.RawD); ok {
+		q.op.selector = merged
 	}
+	sorted := q.op.options.OrderBy != nil
 	q.m.Unlock()
+	if !sorted {
+		return q.Sort("$textScore:score")
+	}
 	return q
 }
 
+// RegEx returns a regular expression value that may be used directly as a
+// field value in a query document.  The options are given as individual
+// characters (for example "i" for case insensitive matching), and are sorted
+// as required by the BSON format.  For example, the following query finds
+// documents with a name starting with "ab" in any letter case:
+//
+//     query := collection.Find(bson.M{"name": mgo.RegEx("^ab", "i")})
+//
+// See bson.RegEx for the supported options.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/operator/query/regex/
+//
+func RegEx(pattern, options string) bson.RegEx {
+	opts := []byte(options)
+	sort.Slice(opts, func(i, j int) bool { return opts[i] < opts[j] })
+	return bson.RegEx{Pattern: pattern, Options: string(opts)}
+}
+
 // Select enables selecting which fields should be retrieved for the results
 // found. For example, the following query would only retrieve the name field:
 //
@@ -3226,12 +4914,73 @@ func (q *Query) Limit(n int) *Query {
 //     http://www.mongodb.org/display/DOCS/Retrieving+a+Subset+of+Fields
 //
 func (q *Query) Select(selector interface{}) *Query {
+	q = q.clone()
 	q.m.Lock()
 	q.op.selector = selector
 	q.m.Unlock()
 	return q
 }
 
+// Fields works like Select, but builds the selector document from the
+// provided field names.  A field name may be prefixed by - (minus) to
+// exclude it from the results rather than include it. For example, the
+// following query would only retrieve the name and age fields:
+//
+//     err := collection.Find(nil).Fields("name", "age").One(&result)
+//
+// and this one would retrieve all fields but the history one:
+//
+//     err := collection.Find(nil).Fields("-history").One(&result)
+//
+// Note that inclusion and exclusion may not be combined, except for
+// excluding the _id field.
+func (q *Query) Fields(names ...string) *Query {
+	selector := make(bson.D, 0, len(names))
+	for _, name := range names {
+		value := 1
+		if strings.HasPrefix(name, "-") {
+			name = name[1:]
+			value = 0
+		}
+		if name == "" {
+			panic("Fields: empty field name")
+		}
+		selector = append(selector, bson.DocElem{Name: name, Value: value})
+	}
+	return q.Select(selector)
+}
+
+// SelectId selects only the _id field of the documents found, which
+// minimizes the amount of data transferred when only the ids are needed,
+// for example to collect documents to be removed later:
+//
+//     var ids []struct{ Id bson.ObjectId `bson:"_id"` }
+//     err := collection.Find(query).SelectId().All(&ids)
+//
+// It's a shorthand for Select(bson.M{"_id": 1}).
+func (q *Query) SelectId() *Query {
+	return q.Select(bson.M{"_id": 1})
+}
+
+// SelectElemMatch selects only the first element of the array in field that
+// matches cond, using the $elemMatch projection operator. For example, the
+// following query would retrieve only the first grade of each student that
+// is at least 85, besides the document _id:
+//
+//     query := collection.Find(nil).SelectElemMatch("grades", bson.M{"grade": bson.M{"$gte": 85}})
+//
+// As with any inclusive projection, the other fields of the document are
+// left out. Documents with no matching element are still returned, but
+// without field.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/operator/projection/elemMatch/
+//
+func (q *Query) SelectElemMatch(field string, cond bson.M) *Query {
+	return q.Select(bson.M{field: bson.M{"$elemMatch": cond}})
+}
+
 // Sort asks the database to order returned documents according to the
 // provided field names. A field name may be prefixed by - (minus) for
 // it to be sorted in reverse order.
@@ -3241,13 +4990,20 @@ func (q *Query) Select(selector interface{}) *Query {
 //     query1 := collection.Find(nil).Sort("firstname", "lastname")
 //     query2 := collection.Find(nil).Sort("-age")
 //     query3 := collection.Find(nil).Sort("$natural")
-//     query4 := collection.Find(nil).Select(bson.M{"score": bson.M{"$meta": "textScore"}}).Sort("$textScore:score")
+//     query4 := collection.Find(bson.M{"$text": bson.M{"$search": "coffee"}}).Sort("$textScore:score")
+//
+// A field name of the form "$textScore:name" sorts documents by decreasing
+// relevance to the $text search of the query, and projects the relevance
+// score onto the name field of the results unless the query selects it
+// already.  See also TextSearch.
 //
 // Relevant documentation:
 //
 //     http://www.mongodb.org/display/DOCS/Sorting+and+Natural+Order
+//     https://docs.mongodb.com/manual/reference/operator/projection/meta/
 //
 func (q *Query) Sort(fields ...string) *Query {
+	q = q.clone()
 	q.m.Lock()
 	var order bson.D
 	for _, field := range fields {
@@ -3272,7 +5028,13 @@ func (q *Query) Sort(fields ...string) *Query {
 			panic("Sort: empty field name")
 		}
 		if kind == "textScore" {
-			order = append(order, bson.DocElem{Name: field, Value: bson.M{"$meta": kind}})
+			meta := bson.D{{Name: field, Value: bson.M{"$meta": kind}}}
+			order = append(order, meta[0])
+			if q.op.selector == nil {
+				q.op.selector = meta
+			} else if merged, ok := andSelectors(q.op.selector, meta).(bson.RawD); ok {
+				q.op.selector = merged
+			}
 		} else {
 			order = append(order, bson.DocElem{Name: field, Value: n})
 		}
@@ -3283,6 +5045,30 @@ func (q *Query) Sort(fields ...string) *Query {
 	return q
 }
 
+// Natural asks the database to return documents in their natural order,
+// which for capped collections is the insertion order.  The order must be
+// 1 for forward or -1 for reverse natural order.  For example, the following
+// query returns the most recently inserted documents in a capped collection
+// first:
+//
+//     query := collection.Find(nil).Natural(-1)
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/operator/meta/natural/
+//
+func (q *Query) Natural(order int) *Query {
+	if order != 1 && order != -1 {
+		panic("Natural: order must be 1 or -1")
+	}
+	q = q.clone()
+	q.m.Lock()
+	q.op.options.OrderBy = bson.D{{Name: "$natural", Value: order}}
+	q.op.hasOptions = true
+	q.m.Unlock()
+	return q
+}
+
 // Collation allows to specify language-specific rules for string comparison,
 // such as rules for lettercase and accent marks.
 // When specifying collation, the locale field is mandatory; all other collation
@@ -3308,6 +5094,7 @@ func (q *Query) Sort(fields ...string) *Query {
 //      https://docs.mongodb.com/manual/reference/collation/
 //
 func (q *Query) Collation(collation *Collation) *Query {
+	q = q.clone()
 	q.m.Lock()
 	q.op.options.Collation = collation
 	q.op.hasOptions = true
@@ -3336,7 +5123,11 @@ func (q *Query) Collation(collation *Collation) *Query {
 func (q *Query) Explain(result interface{}) error {
 	q.m.Lock()
 	clone := &Query{session: q.session, query: q.query}
+	qerr := q.err
 	q.m.Unlock()
+	if qerr != nil {
+		return qerr
+	}
 	clone.op.options.Explain = true
 	clone.op.hasOptions = true
 	if clone.op.limit > 0 {
@@ -3360,7 +5151,7 @@ func (q *Query) Explain(result interface{}) error {
 // For example:
 //
 //     query := collection.Find(bson.M{"firstname": "Joe", "lastname": "Winter"})
-//     query.Hint("lastname", "firstname")
+//     query = query.Hint("lastname", "firstname")
 //
 // Relevant documentation:
 //
@@ -3368,6 +5159,7 @@ func (q *Query) Explain(result interface{}) error {
 //     http://www.mongodb.org/display/DOCS/Query+Optimizer
 //
 func (q *Query) Hint(indexKey ...string) *Query {
+	q = q.clone()
 	q.m.Lock()
 	keyInfo, err := parseIndexKey(indexKey)
 	q.op.options.Hint = keyInfo.key
@@ -3385,6 +5177,7 @@ func (q *Query) Hint(indexKey ...string) *Query {
 // This modifier is generally used to prevent potentially long running
 // queries from disrupting performance by scanning through too much data.
 func (q *Query) SetMaxScan(n int) *Query {
+	q = q.clone()
 	q.m.Lock()
 	q.op.options.MaxScan = n
 	q.op.hasOptions = true
@@ -3423,6 +5216,7 @@ func (q *Query) SetMaxScan(n int) *Query {
 //   http://blog.mongodb.org/post/83621787773/maxtimems-and-query-optimizer-introspection-in
 //
 func (q *Query) SetMaxTime(d time.Duration) *Query {
+	q = q.clone()
 	q.m.Lock()
 	q.op.options.MaxTimeMS = int(d / time.Millisecond)
 	q.op.hasOptions = true
@@ -3454,6 +5248,7 @@ func (q *Query) SetMaxTime(d time.Duration) *Query {
 //     http://www.mongodb.org/display/DOCS/How+to+do+Snapshotted+Queries+in+the+Mongo+Database
 //
 func (q *Query) Snapshot() *Query {
+	q = q.clone()
 	q.m.Lock()
 	q.op.options.Snapshot = true
 	q.op.hasOptions = true
@@ -3461,7 +5256,45 @@ func (q *Query) Snapshot() *Query {
 	return q
 }
 
+// SetMongosReadPref sets the read preference that mongos is asked to honor
+// when routing the query, overriding the one derived from the session mode.
+// The mode must be one of "primary", "primaryPreferred", "secondary",
+// "secondaryPreferred" or "nearest", and tags optionally lists the tag sets
+// eligible servers must match, in order of preference.  An invalid mode, or
+// tags given with the "primary" mode, cause the query to fail when run.
+//
+// The read preference is only sent to mongos. When connected directly to a
+// replica set, the servers used are still chosen according to the session
+// mode and the tags provided to SelectServers.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/core/read-preference/
+//     https://docs.mongodb.com/manual/core/read-preference-mechanics/#read-preference-for-sharded-clusters
+//
+func (q *Query) SetMongosReadPref(mode string, tags []bson.D) *Query {
+	q = q.clone()
+	q.m.Lock()
+	switch mode {
+	case "primary", "primaryPreferred", "secondary", "secondaryPreferred", "nearest":
+		if mode == "primary" && len(tags) > 0 {
+			q.err = errors.New("tags may not be used with the primary read preference")
+			break
+		}
+		readPref := bson.D{{Name: "mode", Value: mode}}
+		if len(tags) > 0 {
+			readPref = append(readPref, bson.DocElem{Name: "tags", Value: tags})
+		}
+		q.op.readPref = readPref
+	default:
+		q.err = fmt.Errorf("unsupported read preference mode: %q", mode)
+	}
+	q.m.Unlock()
+	return q
+}
+
 // Comment adds a comment to the query to identify it in the database profiler output.
+// It may be combined with any other query modifier, such as SetMaxTime, Sort or Hint.
 //
 // Relevant documentation:
 //
@@ -3470,6 +5303,7 @@ func (q *Query) Snapshot() *Query {
 //     http://docs.mongodb.org/manual/administration/analyzing-mongodb-performance/#database-profiling
 //
 func (q *Query) Comment(comment string) *Query {
+	q = q.clone()
 	q.m.Lock()
 	q.op.options.Comment = comment
 	q.op.hasOptions = true
@@ -3477,11 +5311,34 @@ func (q *Query) Comment(comment string) *Query {
 	return q
 }
 
+// DecodeBy registers the Go types that documents are decoded into by the
+// NextInterface method of iterators obtained from the query.  The type of
+// each document is looked up in types by the string value of its field
+// named field, which allows iterating over collections holding documents
+// of different kinds.  For example:
+//
+//     types := map[string]reflect.Type{
+//         "cat": reflect.TypeOf(Cat{}),
+//         "dog": reflect.TypeOf(Dog{}),
+//     }
+//     iter := collection.Find(nil).DecodeBy("kind", types).Iter()
+//
+// See Iter.NextInterface for details.
+func (q *Query) DecodeBy(field string, types map[string]reflect.Type) *Query {
+	q = q.clone()
+	q.m.Lock()
+	q.decodeField = field
+	q.decodeTypes = types
+	q.m.Unlock()
+	return q
+}
+
 // LogReplay enables an option that optimizes queries that are typically
 // made on the MongoDB oplog for replaying it. This is an internal
 // implementation aspect and most likely uninteresting for other uses.
 // It has seen at least one use case, though, so it's exposed via the API.
 func (q *Query) LogReplay() *Query {
+	q = q.clone()
 	q.m.Lock()
 	q.op.flags |= flagLogReplay
 	q.m.Unlock()
@@ -3516,9 +5373,9 @@ Error:
 		return &QueryError{Code: result.AssertionCode, Message: result.Assertion, Assertion: true}
 	}
 	if result.Err != "" {
-		return &QueryError{Code: result.Code, Message: result.Err}
+		return &QueryError{Code: result.Code, CodeName: result.CodeName, Message: result.Err}
 	}
-	return &QueryError{Code: result.Code, Message: result.ErrMsg}
+	return &QueryError{Code: result.Code, CodeName: result.CodeName, Message: result.ErrMsg}
 }
 
 // One executes the query and unmarshals the first obtained document into the
@@ -3535,25 +5392,56 @@ Error:
 // received document so that any other custom values may be obtained if
 // desired.
 //
+// ErrNotFound is only returned when the server provides no matching
+// document. If a document is found but cannot be unmarshalled into result,
+// the unmarshalling error is returned instead.
+//
+// If result is a *[]byte, the raw BSON document is copied into it instead
+// of being unmarshalled.
+//
 func (q *Query) One(result interface{}) (err error) {
 	q.m.Lock()
 	session := q.session
 	op := q.op // Copy.
+	qerr := q.err
 	q.m.Unlock()
+	if qerr != nil {
+		return qerr
+	}
+
+	trace := session.traceOp("query", op.collection)
+	defer func() { trace.done(err) }()
 
 	socket, err := session.acquireSocket(true)
 	if err != nil {
 		return err
 	}
-	defer socket.Release()
+	defer func() {
+		if socket != nil {
+			socket.Release()
+		}
+	}()
 
 	op.limit = -1
 
 	session.prepareQuery(&op)
 
+	query := op // Copy in case prepareFindOp rewrites it.
 	expectFindReply := prepareFindOp(socket, &op, 1)
 
 	data, err := socket.SimpleQuery(&op)
+	if err != nil && session.canRetryRead(socket) {
+		debugf("Query %p failed on a dead socket, retrying: %v", q, err)
+		failed := socket.Server()
+		socket.Release()
+		socket, err = session.acquireSocketAvoiding(true, failed)
+		if err != nil {
+			return err
+		}
+		op = query
+		expectFindReply = prepareFindOp(socket, &op, 1)
+		data, err = socket.SimpleQuery(&op)
+	}
 	if err != nil {
 		return err
 	}
@@ -3562,17 +5450,18 @@ func (q *Query) One(result interface{}) (err error) {
 	}
 	if expectFindReply {
 		var findReply struct {
-			Ok     bool
-			Code   int
-			Errmsg string
-			Cursor cursorData
+			Ok       bool
+			Code     int
+			CodeName string `bson:"codeName"`
+			Errmsg   string
+			Cursor   cursorData
 		}
 		err = bson.Unmarshal(data, &findReply)
 		if err != nil {
 			return err
 		}
 		if !findReply.Ok && findReply.Errmsg != "" {
-			return &QueryError{Code: findReply.Code, Message: findReply.Errmsg}
+			return &QueryError{Code: findReply.Code, CodeName: findReply.CodeName, Message: findReply.Errmsg}
 		}
 		if len(findReply.Cursor.FirstBatch) == 0 {
 			return ErrNotFound
@@ -3580,7 +5469,7 @@ func (q *Query) One(result interface{}) (err error) {
 		data = findReply.Cursor.FirstBatch[0].Data
 	}
 	if result != nil {
-		err = bson.Unmarshal(data, result)
+		err = unmarshalResult(data, result)
 		if err == nil {
 			debugf("Query %p document unmarshaled: %#v", q, result)
 		} else {
@@ -3707,6 +5596,112 @@ type getMoreCmd struct {
 	MaxTimeMS  int64  `bson:"maxTimeMS,omitempty"`
 }
 
+// slaveOkCommands holds the commands which may run on a secondary, either
+// because they don't modify any data, or because they report on or
+// maintain the server answering them, as fsync with lock or compact do.
+var slaveOkCommands = map[string]bool{
+	"aggregate":              true,
+	"buildInfo":              true,
+	"buildinfo":              true,
+	"collStats":              true,
+	"compact":                true,
+	"connPoolStats":          true,
+	"connectionStatus":       true,
+	"count":                  true,
+	"currentOp":              true,
+	"dataSize":               true,
+	"dbStats":                true,
+	"distinct":               true,
+	"explain":                true,
+	"find":                   true,
+	"fsync":                  true,
+	"fsyncUnlock":            true,
+	"geoNear":                true,
+	"geoSearch":              true,
+	"getCmdLineOpts":         true,
+	"getLastError":           true,
+	"getLog":                 true,
+	"getMore":                true,
+	"getParameter":           true,
+	"getPrevError":           true,
+	"getlasterror":           true,
+	"group":                  true,
+	"hostInfo":               true,
+	"isMaster":               true,
+	"ismaster":               true,
+	"killCursors":            true,
+	"killOp":                 true,
+	"listCollections":        true,
+	"listCommands":           true,
+	"listDatabases":          true,
+	"listIndexes":            true,
+	"logRotate":              true,
+	"mapReduce":              true,
+	"mapreduce":              true,
+	"parallelCollectionScan": true,
+	"ping":                   true,
+	"profile":                true,
+	"repairDatabase":         true,
+	"replSetGetConfig":       true,
+	"replSetGetStatus":       true,
+	"rolesInfo":              true,
+	"serverStatus":           true,
+	"setParameter":           true,
+	"text":                   true,
+	"top":                    true,
+	"usersInfo":              true,
+	"validate":               true,
+	"whatsmyuri":             true,
+}
+
+// slaveOkCommand returns whether cmd, as provided to Database.Run, may run
+// on a secondary.  Aggregations writing their output with $out or $merge
+// and map/reduce jobs with an output other than inline must run on the
+// primary.
+func slaveOkCommand(cmd interface{}) bool {
+	if name, ok := cmd.(string); ok {
+		return slaveOkCommands[name]
+	}
+	var doc bson.RawD
+	if data, err := bson.Marshal(cmd); err != nil || bson.Unmarshal(data, &doc) != nil || len(doc) == 0 {
+		return false
+	}
+	name := doc[0].Name
+	if !slaveOkCommands[name] {
+		return false
+	}
+	for _, elem := range doc[1:] {
+		switch {
+		case (name == "aggregate") && elem.Name == "pipeline":
+			var stages []bson.RawD
+			if elem.Value.Unmarshal(&stages) != nil {
+				return false
+			}
+			for _, stage := range stages {
+				if len(stage) > 0 && (stage[0].Name == "$out" || stage[0].Name == "$merge") {
+					return false
+				}
+			}
+		case (name == "mapReduce" || name == "mapreduce") && elem.Name == "out":
+			var out bson.RawD
+			if elem.Value.Unmarshal(&out) != nil || len(out) == 0 || out[0].Name != "inline" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// unmarshalResult unmarshals the document in data into result, unless
+// result is a *[]byte, in which case the document is copied into it as is.
+func unmarshalResult(data []byte, result interface{}) error {
+	if raw, ok := result.(*[]byte); ok {
+		*raw = append((*raw)[:0], data...)
+		return nil
+	}
+	return bson.Unmarshal(data, result)
+}
+
 // run duplicates the behavior of collection.Find(query).One(&result)
 // as performed by Database.Run, specializing the logic for running
 // database commands on a given socket.
@@ -3736,7 +5731,7 @@ func (db *Database) run(socket *mongoSocket, cmd, result interface{}) (err error
 		return ErrNotFound
 	}
 	if result != nil {
-		err = bson.Unmarshal(data, result)
+		err = unmarshalResult(data, result)
 		if err != nil {
 			debugf("Run command unmarshaling failed: %#v", op, err)
 			return err
@@ -3899,13 +5894,20 @@ func (q *Query) Iter() *Iter {
 	op := q.op
 	prefetch := q.prefetch
 	limit := q.limit
+	batchBytes := q.batchBytes
+	decodeField := q.decodeField
+	decodeTypes := q.decodeTypes
+	qerr := q.err
 	q.m.Unlock()
 
 	iter := &Iter{
-		session:  session,
-		prefetch: prefetch,
-		limit:    limit,
-		timeout:  -1,
+		session:     session,
+		prefetch:    prefetch,
+		limit:       limit,
+		timeout:     -1,
+		batchBytes:  batchBytes,
+		decodeField: decodeField,
+		decodeTypes: decodeTypes,
 	}
 	iter.gotReply.L = &iter.m
 	iter.op.collection = op.collection
@@ -3913,30 +5915,77 @@ func (q *Query) Iter() *Iter {
 	iter.op.replyFunc = iter.replyFunc()
 	iter.docsToReceive++
 
+	if qerr != nil {
+		iter.err = qerr
+		return iter
+	}
+
+	session.m.RLock()
+	resume := session.iterResume
+	session.m.RUnlock()
+	if resume {
+		resumeOp := op
+		iter.resumeOp = &resumeOp
+		iter.resumedAt = -1
+	}
+
+	if err := iter.sendQuery(session, op, limit); err != nil {
+		// Must lock as the query may be out and it may call replyFunc.
+		iter.m.Lock()
+		iter.err = err
+		iter.m.Unlock()
+	}
+	return iter
+}
+
+// sendQuery sends op to the database, delivering its replies to iter.
+func (iter *Iter) sendQuery(session *Session, op queryOp, limit int32) error {
 	socket, err := session.acquireSocket(true)
 	if err != nil {
-		iter.err = err
-		return iter
+		return err
 	}
 	defer socket.Release()
 
 	session.prepareQuery(&op)
-	op.replyFunc = iter.op.replyFunc
-
-	if prepareFindOp(socket, &op, limit) {
-		iter.isFindCmd = true
-	}
+	trace := session.traceOp("query", op.collection)
+	op.replyFunc = trace.replyFunc(iter.op.replyFunc)
 
+	isFindCmd := prepareFindOp(socket, &op, limit)
+	iter.m.Lock()
+	iter.isFindCmd = isFindCmd
 	iter.server = socket.Server()
+	iter.m.Unlock()
+
 	err = socket.Query(&op)
 	if err != nil {
-		// Must lock as the query is already out and it may call replyFunc.
-		iter.m.Lock()
-		iter.err = err
-		iter.m.Unlock()
+		trace.done(err)
 	}
+	return err
+}
 
-	return iter
+// resume sends the query of a resumable iterator whose cursor was lost once
+// again, skipping the documents already delivered. It must be called with
+// iter.m held, and releases it while the query is sent.
+func (iter *Iter) resume() {
+	debugf("Iter %p resuming after %d documents", iter, iter.delivered)
+	op := *iter.resumeOp
+	op.skip += int64(iter.delivered)
+	limit := iter.limit
+	if limit > 0 && (op.limit == 0 || op.limit > limit) {
+		op.limit = limit
+	}
+	iter.resumedAt = iter.delivered
+	iter.err = nil
+	iter.op.cursorId = 0
+	iter.docsToReceive++
+	iter.m.Unlock()
+
+	err := iter.sendQuery(iter.session, op, limit)
+
+	iter.m.Lock()
+	if err != nil {
+		iter.err = err
+	}
 }
 
 // Tail returns a tailable iterator. Unlike a normal iterator, a
@@ -3991,6 +6040,7 @@ func (q *Query) Tail(timeout time.Duration) *Iter {
 	session := q.session
 	op := q.op
 	prefetch := q.prefetch
+	qerr := q.err
 	q.m.Unlock()
 
 	iter := &Iter{session: session, prefetch: prefetch}
@@ -4000,17 +6050,24 @@ func (q *Query) Tail(timeout time.Duration) *Iter {
 	iter.op.limit = op.limit
 	iter.op.replyFunc = iter.replyFunc()
 	iter.docsToReceive++
+	if qerr != nil {
+		iter.err = qerr
+		return iter
+	}
 	session.prepareQuery(&op)
-	op.replyFunc = iter.op.replyFunc
+	trace := session.traceOp("query", op.collection)
+	op.replyFunc = trace.replyFunc(iter.op.replyFunc)
 	op.flags |= flagTailable | flagAwaitData
 
 	socket, err := session.acquireSocket(true)
 	if err != nil {
+		trace.done(err)
 		iter.err = err
 	} else {
 		iter.server = socket.Server()
 		err = socket.Query(&op)
 		if err != nil {
+			trace.done(err)
 			// Must lock as the query is already out and it may call replyFunc.
 			iter.m.Lock()
 			iter.err = err
@@ -4024,7 +6081,7 @@ func (q *Query) Tail(timeout time.Duration) *Iter {
 func (s *Session) prepareQuery(op *queryOp) {
 	s.m.RLock()
 	op.mode = s.consistency
-	if s.slaveOk {
+	if s.slaveOk || op.readPref != nil && op.readPref[0].Value != "primary" {
 		op.flags |= flagSlaveOk
 	}
 	s.m.RUnlock()
@@ -4047,6 +6104,21 @@ func (iter *Iter) Err() error {
 	return err
 }
 
+// Stop interrupts the iteration, unblocking any pending call to Next without
+// affecting the session in use.  Documents that were already received may
+// still be returned by Next, after which it returns false and Err reports
+// ErrStopped.  This is most useful to interrupt a Next call blocked on a
+// tailable cursor without a timeout.  The iterator must still be closed
+// afterwards so the server cursor is killed.
+func (iter *Iter) Stop() {
+	iter.m.Lock()
+	if iter.err == nil {
+		iter.err = ErrStopped
+	}
+	iter.m.Unlock()
+	iter.gotReply.Broadcast()
+}
+
 // Close kills the server cursor used by the iterator, if any, and returns
 // nil if no errors happened during iteration, or the actual error otherwise.
 //
@@ -4129,6 +6201,20 @@ func (iter *Iter) Timeout() bool {
 	return result
 }
 
+// SetNextTimeout sets how long each call to Next may wait for the server
+// to deliver more results. When the timeout elapses Next returns false and
+// the Timeout method returns true, while the socket and the cursor are left
+// untouched, so Next may be called again to keep waiting for the pending
+// batch. This bounds the latency of each step independently of the session
+// socket timeout, which still applies to the request itself.
+//
+// A zero duration, the default, lets Next wait indefinitely.
+func (iter *Iter) SetNextTimeout(d time.Duration) {
+	iter.m.Lock()
+	iter.nextTimeout = d
+	iter.m.Unlock()
+}
+
 // Next retrieves the next document from the result set, blocking if necessary.
 // This method will also automatically retrieve another batch of documents from
 // the server when the current one is exhausted, or before that in background
@@ -4141,6 +6227,9 @@ func (iter *Iter) Timeout() bool {
 // there was an error during iteration, and the Timeout method to verify if the
 // false return value was caused by a timeout (no available results).
 //
+// If result is a *[]byte, the raw BSON document is copied into it instead
+// of being unmarshalled. The slice's backing array is reused across calls.
+//
 // For example:
 //
 //    iter := collection.Find(nil).Iter()
@@ -4155,6 +6244,13 @@ func (iter *Iter) Next(result interface{}) bool {
 	iter.m.Lock()
 	iter.timedout = false
 	timeout := time.Time{}
+	var expired bool
+	var expiry *time.Timer
+	defer func() {
+		if expiry != nil {
+			expiry.Stop()
+		}
+	}()
 	// for a ChangeStream iterator we have to call getMore before the loop otherwise
 	// we'll always return false
 	if iter.isChangeStream {
@@ -4167,10 +6263,11 @@ func (iter *Iter) Next(result interface{}) bool {
 		// If we have yet to receive data, increment the timer until we timeout.
 		if iter.docsToReceive == 0 {
 			if iter.timeout >= 0 {
+				clock := getClock()
 				if timeout.IsZero() {
-					timeout = time.Now().Add(iter.timeout)
+					timeout = clock.Now().Add(iter.timeout)
 				}
-				if time.Now().After(timeout) {
+				if clock.Now().After(timeout) {
 					iter.timedout = true
 					iter.m.Unlock()
 					return false
@@ -4188,11 +6285,37 @@ func (iter *Iter) Next(result interface{}) bool {
 				break
 			}
 		}
+		if expired {
+			iter.timedout = true
+			iter.m.Unlock()
+			return false
+		}
+		if expiry == nil && iter.nextTimeout > 0 {
+			expiry = time.AfterFunc(iter.nextTimeout, func() {
+				iter.m.Lock()
+				expired = true
+				iter.gotReply.Broadcast()
+				iter.m.Unlock()
+			})
+		}
 		iter.gotReply.Wait()
 	}
 	// We have data from the getMore.
 	// Exhaust available data before reporting any errors.
 	if docData, ok := iter.docData.Pop().([]byte); ok {
+		// An error document may show up in any batch, not only the first.
+		// The server discards the cursor with it, so don't look for more.
+		if err := checkQueryError(iter.op.collection, docData); err != nil {
+			debugf("Iter %p received a query error: %s", iter, err.Error())
+			if iter.err == nil {
+				iter.err = err
+			}
+			iter.op.cursorId = 0
+			iter.docData = queue{}
+			iter.m.Unlock()
+			return false
+		}
+		iter.delivered++
 		close := false
 		if iter.limit > 0 {
 			iter.limit--
@@ -4217,7 +6340,7 @@ func (iter *Iter) Next(result interface{}) bool {
 		if close {
 			iter.Close()
 		}
-		err := bson.Unmarshal(docData, result)
+		err := unmarshalResult(docData, result)
 		if err != nil {
 			debugf("Iter %p document unmarshaling failed: %#v", iter, err)
 			iter.m.Lock()
@@ -4228,17 +6351,11 @@ func (iter *Iter) Next(result interface{}) bool {
 			return false
 		}
 		debugf("Iter %p document unmarshaled: %#v", iter, result)
-		// XXX Only have to check first document for a query error?
-		err = checkQueryError(iter.op.collection, docData)
-		if err != nil {
-			iter.m.Lock()
-			if iter.err == nil {
-				iter.err = err
-			}
-			iter.m.Unlock()
-			return false
-		}
 		return true
+	} else if iter.err == ErrCursor && iter.resumeOp != nil && iter.resumedAt != iter.delivered {
+		iter.resume()
+		iter.m.Unlock()
+		return iter.Next(result)
 	} else if iter.err != nil {
 		debugf("Iter %p returning false: %s", iter, iter.err)
 		iter.m.Unlock()
@@ -4253,6 +6370,85 @@ func (iter *Iter) Next(result interface{}) bool {
 	panic("unreachable")
 }
 
+// NextInterface retrieves the next document from the result set like Next,
+// and returns it unmarshalled into a new value of the type registered for
+// it via Query.DecodeBy.  If the registered type is a pointer type, a
+// pointer to a new value is returned.  For example:
+//
+//     for {
+//         doc, err := iter.NextInterface()
+//         if err != nil {
+//             return err
+//         }
+//         if doc == nil {
+//             break
+//         }
+//         switch doc := doc.(type) {
+//         case Cat:
+//             ...
+//         case Dog:
+//             ...
+//         }
+//     }
+//
+// Once the result set is exhausted or the iteration fails, NextInterface
+// returns a nil value along with the iteration error, if any.  A document
+// with no type registered for it yields an error, after which the
+// iteration may proceed with the following documents.
+func (iter *Iter) NextInterface() (interface{}, error) {
+	iter.m.Lock()
+	field := iter.decodeField
+	types := iter.decodeTypes
+	iter.m.Unlock()
+	if types == nil {
+		return nil, errors.New("no document types registered via Query.DecodeBy")
+	}
+	var data []byte
+	if !iter.Next(&data) {
+		return nil, iter.Err()
+	}
+	return decodeByType(data, field, types)
+}
+
+// decodeByType unmarshals the document in data into a new value of the
+// type registered in types for the value of its field.
+func decodeByType(data []byte, field string, types map[string]reflect.Type) (interface{}, error) {
+	var doc bson.RawD
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	var kind string
+	found := false
+	for _, elem := range doc {
+		if elem.Name == field {
+			if elem.Value.Kind != 0x02 || elem.Value.Unmarshal(&kind) != nil {
+				return nil, fmt.Errorf("document field %q doesn't hold a string", field)
+			}
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("document has no %q field", field)
+	}
+	t, ok := types[kind]
+	if !ok {
+		return nil, fmt.Errorf("no type registered for document with %s %q", field, kind)
+	}
+	if t.Kind() == reflect.Ptr {
+		v := reflect.New(t.Elem())
+		if err := bson.Unmarshal(data, v.Interface()); err != nil {
+			return nil, err
+		}
+		return v.Interface(), nil
+	}
+	v := reflect.New(t)
+	if err := bson.Unmarshal(data, v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
+
 // All retrieves all documents from the result set into the provided slice
 // and closes the iterator.
 //
@@ -4274,6 +6470,14 @@ func (iter *Iter) Next(result interface{}) bool {
 //    }
 //
 func (iter *Iter) All(result interface{}) error {
+	iter.all(result, -1)
+	return iter.Close()
+}
+
+// all retrieves documents from the result set into the slice result points
+// to, up to max of them unless max is negative, and returns how many were
+// retrieved.
+func (iter *Iter) all(result interface{}, max int) int {
 	resultv := reflect.ValueOf(result)
 	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
 		panic("result argument must be a slice address")
@@ -4282,7 +6486,7 @@ func (iter *Iter) All(result interface{}) error {
 	slicev = slicev.Slice(0, slicev.Cap())
 	elemt := slicev.Type().Elem()
 	i := 0
-	for {
+	for i != max {
 		if slicev.Len() == i {
 			elemp := reflect.New(elemt)
 			if !iter.Next(elemp.Interface()) {
@@ -4298,7 +6502,7 @@ func (iter *Iter) All(result interface{}) error {
 		i++
 	}
 	resultv.Elem().Set(slicev.Slice(0, i))
-	return iter.Close()
+	return i
 }
 
 // All works like Iter.All.
@@ -4306,12 +6510,208 @@ func (q *Query) All(result interface{}) error {
 	return q.Iter().All(result)
 }
 
+// AllLimited works like All, but retrieves at most max documents into the
+// provided slice, protecting the application from unexpectedly large result
+// sets.  If the result set holds more documents than that, truncated is
+// true and the cursor is closed without retrieving the remaining ones.
+//
+// For instance:
+//
+//    var result []struct{ Value int }
+//    truncated, err := collection.Find(nil).Iter().AllLimited(1000, &result)
+//    if err != nil {
+//        return err
+//    }
+//    if truncated {
+//        log.Printf("only the first %d documents were loaded", len(result))
+//    }
+//
+func (iter *Iter) AllLimited(max int, result interface{}) (truncated bool, err error) {
+	if max < 0 {
+		panic("AllLimited: max must not be negative")
+	}
+	if iter.all(result, max) == max {
+		var extra bson.Raw
+		truncated = iter.Next(&extra)
+	}
+	return truncated, iter.Close()
+}
+
+// AllLimited works like Iter.AllLimited, but also limits the query so that
+// the server doesn't send more than max+1 documents.
+func (q *Query) AllLimited(max int, result interface{}) (truncated bool, err error) {
+	if max < 0 {
+		panic("AllLimited: max must not be negative")
+	}
+	q.m.Lock()
+	limit := q.limit
+	q.m.Unlock()
+	if max < math.MaxInt32 && (limit == 0 || int(limit) > max+1) {
+		q = q.Limit(max + 1)
+	}
+	return q.Iter().AllLimited(max, result)
+}
+
+// ConcurrentIter is an iterator that retrieves documents in a background
+// goroutine while the caller is processing the ones already received.  See
+// the IterConcurrent method of Query.
+type ConcurrentIter struct {
+	iter    *Iter
+	docs    chan bson.Raw
+	stop    chan struct{}
+	stopped sync.Once
+	m       sync.Mutex
+	err     error
+}
+
+// defaultConcurrentBuffer is the number of documents a ConcurrentIter keeps
+// ready for processing when the query has no batch size set.
+const defaultConcurrentBuffer = 100
+
+// IterConcurrent executes the query and returns an iterator that reads the
+// results in a background goroutine, so that the caller doesn't block on the
+// network between batches while processing documents.  At most one batch of
+// documents (see the Batch method) is kept ready for processing at any time.
+//
+// The iterator must be closed once it's no longer needed, so that the
+// background goroutine and the server cursor are released.
+func (q *Query) IterConcurrent() *ConcurrentIter {
+	q.m.Lock()
+	size := int(q.op.limit)
+	q.m.Unlock()
+	if size < 0 {
+		size = -size
+	}
+	if size == 0 {
+		size = defaultConcurrentBuffer
+	}
+	it := &ConcurrentIter{
+		iter: q.Iter(),
+		docs: make(chan bson.Raw, size),
+		stop: make(chan struct{}),
+	}
+	go it.loop()
+	return it
+}
+
+func (it *ConcurrentIter) loop() {
+	defer close(it.docs)
+	for {
+		var raw bson.Raw
+		if !it.iter.Next(&raw) {
+			return
+		}
+		select {
+		case it.docs <- raw:
+		case <-it.stop:
+			return
+		}
+	}
+}
+
+// Next retrieves the next document from the result set, blocking only if
+// the background goroutine hasn't received it yet.  It returns false at the
+// end of the result set or if an error happened, in which case Err or Close
+// report the error.
+func (it *ConcurrentIter) Next(result interface{}) bool {
+	raw, ok := <-it.docs
+	if !ok {
+		return false
+	}
+	if err := raw.Unmarshal(result); err != nil {
+		it.m.Lock()
+		if it.err == nil {
+			it.err = err
+		}
+		it.m.Unlock()
+		it.halt()
+		return false
+	}
+	return true
+}
+
+// Err returns nil if no errors happened during iteration, or the actual
+// error otherwise.
+func (it *ConcurrentIter) Err() error {
+	it.m.Lock()
+	err := it.err
+	it.m.Unlock()
+	if err != nil {
+		return err
+	}
+	err = it.iter.Err()
+	if err == ErrStopped {
+		return nil
+	}
+	return err
+}
+
+// Close stops the background goroutine, kills the server cursor used by the
+// iterator, if any, and returns nil if no errors happened during iteration,
+// or the actual error otherwise.
+func (it *ConcurrentIter) Close() error {
+	it.halt()
+	for range it.docs {
+	}
+	err := it.iter.Close()
+	if err == ErrStopped {
+		err = nil
+	}
+	it.m.Lock()
+	if it.err != nil {
+		err = it.err
+	}
+	it.m.Unlock()
+	return err
+}
+
+// halt interrupts the background goroutine.
+func (it *ConcurrentIter) halt() {
+	it.stopped.Do(func() {
+		close(it.stop)
+		it.iter.Stop()
+	})
+}
+
 // For method is obsolete and will be removed in a future release.
 // See Iter as an elegant replacement.
 func (q *Query) For(result interface{}, f func() error) error {
 	return q.Iter().For(result, f)
 }
 
+// ForEach retrieves each document from the result set into the provided
+// result value and calls f after each one.  If f returns an error the
+// iteration stops and that error is returned.  In all cases the iterator is
+// closed before ForEach returns, so the server cursor is not left open when
+// the iteration stops early.  If f never fails, the error from Close is
+// returned, as with the All method.
+//
+// For example:
+//
+//     var result struct{ Value int }
+//     err := iter.ForEach(&result, func() error {
+//         if result.Value < 0 {
+//             return errors.New("negative value")
+//         }
+//         fmt.Println(result.Value)
+//         return nil
+//     })
+//
+func (iter *Iter) ForEach(result interface{}, f func() error) error {
+	for iter.Next(result) {
+		if err := f(); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	return iter.Close()
+}
+
+// ForEach works like Iter.ForEach.
+func (q *Query) ForEach(result interface{}, f func() error) error {
+	return q.Iter().ForEach(result, f)
+}
+
 // For method is obsolete and will be removed in a future release.
 // See Iter as an elegant replacement.
 func (iter *Iter) For(result interface{}, f func() error) (err error) {
@@ -4379,15 +6779,20 @@ func (iter *Iter) getMore() {
 	// different goroutine to get here as well.
 	iter.docsToReceive++
 	iter.m.Unlock()
+	trace := iter.session.traceOp("getMore", iter.op.collection)
 	socket, err := iter.acquireSocket()
 	iter.m.Lock()
 	if err != nil {
+		trace.done(err)
 		iter.err = err
 		return
 	}
 	defer socket.Release()
 
 	debugf("Iter %p requesting more documents", iter)
+	if iter.batchBytes > 0 && iter.docsSeen > 0 {
+		iter.op.limit = bytesBatchSize(iter.batchBytes, iter.bytesSeen, iter.docsSeen)
+	}
 	if iter.limit > 0 {
 		// The -1 below accounts for the fact docsToReceive was incremented above.
 		limit := iter.limit - int32(iter.docsToReceive-1) - int32(iter.docData.Len())
@@ -4397,11 +6802,18 @@ func (iter *Iter) getMore() {
 	}
 	var op interface{}
 	if iter.isFindCmd || iter.isChangeStream {
-		op = iter.getMoreCmd()
+		cmd := iter.getMoreCmd()
+		cmd.replyFunc = trace.replyFunc(cmd.replyFunc)
+		op = cmd
+	} else if trace != nil {
+		getMore := iter.op // Copy.
+		getMore.replyFunc = trace.replyFunc(getMore.replyFunc)
+		op = &getMore
 	} else {
 		op = &iter.op
 	}
 	if err := socket.Query(op); err != nil {
+		trace.done(err)
 		iter.docsToReceive--
 		iter.err = err
 	}
@@ -4435,18 +6847,25 @@ type countCmd struct {
 	Count     string
 	Query     interface{}
 	Limit     int32  `bson:",omitempty"`
-	Skip      int32  `bson:",omitempty"`
+	Skip      int64  `bson:",omitempty"`
 	Hint      bson.D `bson:"hint,omitempty"`
 	MaxTimeMS int    `bson:"maxTimeMS,omitempty"`
 }
 
-// Count returns the total number of documents in the result set.
+// Count returns the total number of documents in the result set.  The
+// count is exact, as the matching documents are counted by the server.
+// See Collection.EstimatedCount for a cheaper alternative when an
+// estimate of the collection size is enough.
 func (q *Query) Count() (n int, err error) {
 	q.m.Lock()
 	session := q.session
 	op := q.op
 	limit := q.limit
+	qerr := q.err
 	q.m.Unlock()
+	if qerr != nil {
+		return 0, qerr
+	}
 
 	c := strings.Index(op.collection, ".")
 	if c < 0 {
@@ -4468,11 +6887,126 @@ func (q *Query) Count() (n int, err error) {
 	return result.N, err
 }
 
+// AccurateCount returns the total number of documents in the result set,
+// counted by running an aggregation pipeline that groups the matching
+// documents rather than with the count command.  On sharded clusters the
+// count command may include orphaned documents and documents from chunks
+// being migrated, while the aggregation only counts documents owned by
+// each shard.  The skip and limit set on the query are respected, but its
+// sort and hint are not.  Servers older than 2.6 fall back to Count.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/count/#accuracy-and-sharded-clusters
+//     https://docs.mongodb.com/manual/reference/operator/aggregation/group/
+//
+func (q *Query) AccurateCount() (n int, err error) {
+	q.m.Lock()
+	session := q.session
+	op := q.op
+	limit := q.limit
+	qerr := q.err
+	q.m.Unlock()
+	if qerr != nil {
+		return 0, qerr
+	}
+
+	c := strings.Index(op.collection, ".")
+	if c < 0 {
+		return 0, errors.New("Bad collection name: " + op.collection)
+	}
+
+	socket, err := session.acquireSocket(true)
+	if err != nil {
+		return 0, err
+	}
+	wireVersion := socket.ServerInfo().MaxWireVersion
+	socket.Release()
+	if wireVersion < 2 {
+		return q.Count()
+	}
+
+	query := op.query
+	if query == nil {
+		query = bson.D{}
+	}
+	pipeline := []bson.M{{"$match": query}}
+	if op.skip > 0 {
+		pipeline = append(pipeline, bson.M{"$skip": op.skip})
+	}
+	if limit < 0 {
+		limit = -limit
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": limit})
+	}
+	pipeline = append(pipeline, bson.M{"$group": bson.M{"_id": nil, "n": bson.M{"$sum": 1}}})
+
+	pipe := session.DB(op.collection[:c]).C(op.collection[c+1:]).Pipe(pipeline)
+	if op.options.MaxTimeMS > 0 {
+		pipe.SetMaxTime(time.Duration(op.options.MaxTimeMS) * time.Millisecond)
+	}
+	result := struct{ N int }{}
+	err = pipe.One(&result)
+	if err == ErrNotFound {
+		// No documents matched, so there was nothing to group.
+		return 0, nil
+	}
+	return result.N, err
+}
+
 // Count returns the total number of documents in the collection.
 func (c *Collection) Count() (n int, err error) {
 	return c.Find(nil).Count()
 }
 
+// Page returns an iterator over the given page of results, counting pages
+// from one and with perPage documents each, along with the total number of
+// documents matching the query.  The total is counted once, ignoring any
+// skip or limit set on the query.  Sort the query so that consecutive pages
+// neither repeat nor miss documents.  For example:
+//
+//     iter, total, err := collection.Find(nil).Sort("name").Page(2, 20)
+//
+func (q *Query) Page(page, perPage int) (iter *Iter, total int, err error) {
+	if page < 1 || perPage < 1 {
+		return nil, 0, fmt.Errorf("invalid page %d with %d documents per page", page, perPage)
+	}
+	all := q.clone()
+	all.op.skip = 0
+	all.op.limit = 0
+	all.limit = 0
+	total, err = all.Count()
+	if err != nil {
+		return nil, 0, err
+	}
+	iter = q.SkipN(int64(page-1) * int64(perPage)).Limit(perPage).Iter()
+	return iter, total, nil
+}
+
+// AccurateCount returns the total number of documents in the collection,
+// counted with an aggregation pipeline. See Query.AccurateCount.
+func (c *Collection) AccurateCount() (n int, err error) {
+	return c.Find(nil).AccurateCount()
+}
+
+// EstimatedCount returns an estimate of the total number of documents in
+// the collection, using the collection metadata rather than counting the
+// documents themselves.  It's cheap even on very large collections, but the
+// result may be inaccurate, for example after an unclean shutdown or while
+// chunks are migrated in a sharded cluster.  Use Find(filter).Count() for
+// an exact count.
+//
+// Relevant documentation:
+//
+//     https://docs.mongodb.com/manual/reference/command/count/#accuracy-and-sharded-clusters
+//
+func (c *Collection) EstimatedCount() (n int, err error) {
+	result := struct{ N int }{}
+	err = c.Database.Run(bson.D{{Name: "count", Value: c.Name}}, &result)
+	return result.N, err
+}
+
 type distinctCmd struct {
 	Collection string `bson:"distinct"`
 	Key        string
@@ -4494,7 +7028,11 @@ func (q *Query) Distinct(key string, result interface{}) error {
 	q.m.Lock()
 	session := q.session
 	op := q.op // Copy.
+	qerr := q.err
 	q.m.Unlock()
+	if qerr != nil {
+		return qerr
+	}
 
 	c := strings.Index(op.collection, ".")
 	if c < 0 {
@@ -4512,6 +7050,71 @@ func (q *Query) Distinct(key string, result interface{}) error {
 	return doc.Values.Unmarshal(result)
 }
 
+// SortedDistinct works like Distinct, but sorts the resulting values, as
+// the order of values returned by the server is undefined.  Numbers are
+// sorted by value and precede strings, which are sorted lexically.  Values
+// of other types are kept after those, in the order returned by the server.
+//
+// The result argument must necessarily be the address for a slice.
+func (q *Query) SortedDistinct(key string, result interface{}) error {
+	resultv := reflect.ValueOf(result)
+	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
+		panic("result argument must be a slice address")
+	}
+	if err := q.Distinct(key, result); err != nil {
+		return err
+	}
+	slicev := resultv.Elem()
+	sort.SliceStable(slicev.Interface(), func(i, j int) bool {
+		return lessValue(slicev.Index(i), slicev.Index(j))
+	})
+	return nil
+}
+
+// lessValue reports whether a sorts before b, ordering numbers before
+// strings and both before any other values.
+func lessValue(a, b reflect.Value) bool {
+	for a.Kind() == reflect.Interface && !a.IsNil() {
+		a = a.Elem()
+	}
+	for b.Kind() == reflect.Interface && !b.IsNil() {
+		b = b.Elem()
+	}
+	ka, kb := valueClass(a), valueClass(b)
+	if ka != kb {
+		return ka < kb
+	}
+	switch ka {
+	case 0:
+		return numberValue(a) < numberValue(b)
+	case 1:
+		return a.String() < b.String()
+	}
+	return false
+}
+
+func valueClass(v reflect.Value) int {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return 0
+	case reflect.String:
+		return 1
+	}
+	return 2
+}
+
+func numberValue(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	}
+	return v.Float()
+}
+
 type mapReduceCmd struct {
 	Collection string `bson:"mapreduce"`
 	Map        string `bson:",omitempty"`
@@ -4632,7 +7235,11 @@ func (q *Query) MapReduce(job *MapReduce, result interface{}) (info *MapReduceIn
 	session := q.session
 	op := q.op // Copy.
 	limit := q.limit
+	qerr := q.err
 	q.m.Unlock()
+	if qerr != nil {
+		return nil, qerr
+	}
 
 	c := strings.Index(op.collection, ".")
 	if c < 0 {
@@ -4744,6 +7351,7 @@ type findModifyCmd struct {
 	Collection                  string      `bson:"findAndModify"`
 	Query, Update, Sort, Fields interface{} `bson:",omitempty"`
 	Upsert, Remove, New         bool        `bson:",omitempty"`
+	BypassDocumentValidation    bool        `bson:"bypassDocumentValidation,omitempty"`
 }
 
 type valueResult struct {
@@ -4782,7 +7390,11 @@ func (q *Query) Apply(change Change, result interface{}) (info *ChangeInfo, err
 	q.m.Lock()
 	session := q.session
 	op := q.op // Copy.
+	qerr := q.err
 	q.m.Unlock()
+	if qerr != nil {
+		return nil, qerr
+	}
 
 	c := strings.Index(op.collection, ".")
 	if c < 0 {
@@ -4803,6 +7415,10 @@ func (q *Query) Apply(change Change, result interface{}) (info *ChangeInfo, err
 		Fields:     op.selector,
 	}
 
+	session.m.RLock()
+	cmd.BypassDocumentValidation = session.bypassValidation && !change.Remove
+	session.m.RUnlock()
+
 	session = session.Clone()
 	defer session.Close()
 	session.SetMode(Strong, false)
@@ -4904,10 +7520,56 @@ func (s *Session) BuildInfo() (info BuildInfo, err error) {
 	return
 }
 
+// WireVersion returns the range of wire protocol versions supported by the
+// server the session is talking to, as reported by it when the connection
+// was established.  Each MongoDB release increments the maximum wire
+// version when it introduces new features to the protocol, so it offers a
+// simpler way than BuildInfo to decide whether a feature may be used.
+// Servers older than MongoDB 2.6 report no wire version and yield zero
+// for both values.
+//
+// Relevant documentation:
+//
+//     https://github.com/mongodb/specifications/blob/master/source/wireversion-featurelist.rst
+//
+func (s *Session) WireVersion() (min, max int, err error) {
+	socket, err := s.acquireSocket(true)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer socket.Release()
+	info := socket.ServerInfo()
+	return info.MinWireVersion, info.MaxWireVersion, nil
+}
+
 // ---------------------------------------------------------------------------
 // Internal session handling helpers.
 
 func (s *Session) acquireSocket(slaveOk bool) (*mongoSocket, error) {
+	return s.acquireSocketAvoiding(slaveOk, nil)
+}
+
+// acquireSocketAvoiding works like acquireSocket, but if a new socket must
+// be obtained any suitable server other than avoid is preferred.
+func (s *Session) acquireSocketAvoiding(slaveOk bool, avoid *mongoServer) (acquired *mongoSocket, err error) {
+	s.m.RLock()
+	throttle := s.throttle
+	deadline := s.deadline
+	sockTimeout := s.sockTimeout
+	s.m.RUnlock()
+	if !deadline.IsZero() {
+		remaining := deadline.Sub(getClock().Now())
+		if remaining <= 0 {
+			return nil, ErrDeadline
+		}
+		// Operations in progress must not outlive the deadline either.
+		defer func() {
+			if acquired != nil && (sockTimeout == 0 || remaining < sockTimeout) {
+				acquired.SetTimeout(remaining)
+			}
+		}()
+	}
+	throttle.wait()
 
 	// Read-only lock to check for previously reserved socket.
 	s.m.RLock()
@@ -4932,6 +7594,10 @@ func (s *Session) acquireSocket(slaveOk bool) (*mongoSocket, error) {
 	s.m.Lock()
 	defer s.m.Unlock()
 
+	if s.mgoCluster == nil {
+		return nil, ErrClosed
+	}
+
 	if s.slaveSocket != nil && s.slaveOk && slaveOk && (s.masterSocket == nil || s.consistency != PrimaryPreferred && s.consistency != Monotonic) {
 		if s.slaveSocket.dead == nil {
 			s.slaveSocket.Acquire()
@@ -4949,10 +7615,27 @@ func (s *Session) acquireSocket(slaveOk bool) (*mongoSocket, error) {
 		}
 	}
 
+	// Writes may wait for a primary for a shorter time than other operations.
+	syncTimeout := s.syncTimeout
+	if !slaveOk && s.selectTimeout > 0 {
+		syncTimeout = s.selectTimeout
+	}
+
 	// Still not good.  We need a new socket.
+	readSlave := slaveOk && s.slaveOk
+	serverTags := s.queryConfig.op.serverTags
+	if readSlave && s.slaveFallback && !s.cluster().HasSlave(serverTags) {
+		readSlave = false
+	}
 	sock, err := s.cluster().AcquireSocketWithPoolTimeout(
-		s.consistency, slaveOk && s.slaveOk, s.syncTimeout, s.sockTimeout, s.queryConfig.op.serverTags, s.poolLimit, s.poolTimeout,
+		s.consistency, readSlave, syncTimeout, s.sockTimeout, serverTags, s.poolLimit, s.poolTimeout, s.localThreshold, avoid,
 	)
+	if err != nil && readSlave && s.slaveFallback {
+		logf("No slave available (%v). Falling back to the primary.", err)
+		sock, err = s.cluster().AcquireSocketWithPoolTimeout(
+			s.consistency, false, syncTimeout, s.sockTimeout, serverTags, s.poolLimit, s.poolTimeout, s.localThreshold, avoid,
+		)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -5014,6 +7697,7 @@ func (iter *Iter) replyFunc() replyFunc {
 	return func(err error, op *replyOp, docNum int, docData []byte) {
 		iter.m.Lock()
 		iter.docsToReceive--
+		stopped := iter.err == ErrStopped
 		if err != nil {
 			iter.err = err
 			debugf("Iter %p received an error: %s", iter, err.Error())
@@ -5031,15 +7715,22 @@ func (iter *Iter) replyFunc() replyFunc {
 		} else if iter.isFindCmd {
 			debugf("Iter %p received reply document %d/%d (cursor=%d)", iter, docNum+1, int(op.replyDocs), op.cursorId)
 			var findReply struct {
-				Ok     bool
-				Code   int
-				Errmsg string
-				Cursor cursorData
+				Ok       bool
+				Code     int
+				CodeName string `bson:"codeName"`
+				Errmsg   string
+				Cursor   cursorData
 			}
 			if err := bson.Unmarshal(docData, &findReply); err != nil {
 				iter.err = err
 			} else if !findReply.Ok && findReply.Errmsg != "" {
-				iter.err = &QueryError{Code: findReply.Code, Message: findReply.Errmsg}
+				if findReply.Code == 43 { // CursorNotFound
+					iter.err = ErrCursor
+				} else {
+					iter.err = &QueryError{Code: findReply.Code, CodeName: findReply.CodeName, Message: findReply.Errmsg}
+				}
+				// The cursor is gone along with the failed batch.
+				iter.op.cursorId = 0
 			} else if !iter.isChangeStream && len(findReply.Cursor.FirstBatch) == 0 && len(findReply.Cursor.NextBatch) == 0 {
 				iter.err = ErrNotFound
 			} else {
@@ -5050,6 +7741,8 @@ func (iter *Iter) replyFunc() replyFunc {
 				rdocs := len(batch)
 				for _, raw := range batch {
 					iter.docData.Push(raw.Data)
+					iter.docsSeen++
+					iter.bytesSeen += len(raw.Data)
 				}
 				iter.docsToReceive = 0
 				docsToProcess := iter.docData.Len()
@@ -5074,6 +7767,12 @@ func (iter *Iter) replyFunc() replyFunc {
 			}
 			debugf("Iter %p received reply document %d/%d (cursor=%d)", iter, docNum+1, rdocs, op.cursorId)
 			iter.docData.Push(docData)
+			iter.docsSeen++
+			iter.bytesSeen += len(docData)
+		}
+		if stopped {
+			// Replies to requests made before Stop must not hide it.
+			iter.err = ErrStopped
 		}
 		iter.gotReply.Broadcast()
 		iter.m.Unlock()
@@ -5117,6 +7816,9 @@ func (r *writeCmdResult) BulkErrorCases() []BulkErrorCase {
 // will also be returned as err.
 func (c *Collection) writeOp(op interface{}, ordered bool) (lerr *LastError, err error) {
 	s := c.Database.Session
+	trace := s.traceOp(writeOpName(op), c.FullName)
+	defer func() { trace.done(err) }()
+
 	socket, err := s.acquireSocket(c.Database.Name == "local")
 	if err != nil {
 		return nil, err
@@ -5126,20 +7828,34 @@ func (c *Collection) writeOp(op interface{}, ordered bool) (lerr *LastError, err
 	s.m.RLock()
 	safeOp := s.safeOp
 	bypassValidation := s.bypassValidation
+	checkSafe := s.checkSafe
+	buffered := s.writeBuffering && safeOp == nil
+	cluster := s.cluster()
 	s.m.RUnlock()
 
-	if socket.ServerInfo().MaxWireVersion >= 2 {
+	if checkSafe && safeOp != nil {
+		if err := cluster.checkWriteConcern(safeOp.query.(*getLastError).W); err != nil {
+			return nil, err
+		}
+	}
+
+	if iop, ok := op.(*insertOp); ok {
+		// Catch oversized documents before they're sent.
+		if op, err = checkDocSizes(iop, socket.ServerInfo().MaxBsonSize); err != nil {
+			return nil, err
+		}
+	}
+
+	if serverInfo := socket.ServerInfo(); serverInfo.MaxWireVersion >= 2 && !buffered {
 		// Servers with a more recent write protocol benefit from write commands.
-		if op, ok := op.(*insertOp); ok && len(op.documents) > 1000 {
+		batchSize, batchBytes := writeBatchLimits(serverInfo)
+		if op, ok := op.(*insertOp); ok && insertBatchEnd(op.documents, 0, batchSize, batchBytes) < len(op.documents) {
 			var lerr LastError
 
-			// Maximum batch size is 1000. Must split out in separate operations for compatibility.
+			// Must split out in separate operations the server accepts.
 			all := op.documents
-			for i := 0; i < len(all); i += 1000 {
-				l := i + 1000
-				if l > len(all) {
-					l = len(all)
-				}
+			for i, l := 0, 0; i < len(all); i = l {
+				l = insertBatchEnd(all, i, batchSize, batchBytes)
 				op.documents = all[i:l]
 				oplerr, err := c.writeOpCommand(socket, safeOp, op, ordered, bypassValidation)
 				lerr.N += oplerr.N
@@ -5159,12 +7875,12 @@ func (c *Collection) writeOp(op interface{}, ordered bool) (lerr *LastError, err
 			}
 			return &lerr, nil
 		}
-		if updateOp, ok := op.(bulkUpdateOp); ok && len(updateOp) > 1000 {
+		if updateOp, ok := op.(bulkUpdateOp); ok && len(updateOp) > batchSize {
 			var lerr LastError
 
-			// Maximum batch size is 1000. Must split out in separate operations for compatibility.
-			for i := 0; i < len(updateOp); i += 1000 {
-				l := i + 1000
+			// Must split out in separate operations the server accepts.
+			for i := 0; i < len(updateOp); i += batchSize {
+				l := i + batchSize
 				if l > len(updateOp) {
 					l = len(updateOp)
 				}
@@ -5185,12 +7901,12 @@ func (c *Collection) writeOp(op interface{}, ordered bool) (lerr *LastError, err
 			}
 			return &lerr, nil
 		}
-		if deleteOps, ok := op.(bulkDeleteOp); ok && len(deleteOps) > 1000 {
+		if deleteOps, ok := op.(bulkDeleteOp); ok && len(deleteOps) > batchSize {
 			var lerr LastError
 
-			// Maximum batch size is 1000. Must split out in separate operations for compatibility.
-			for i := 0; i < len(deleteOps); i += 1000 {
-				l := i + 1000
+			// Must split out in separate operations the server accepts.
+			for i := 0; i < len(deleteOps); i += batchSize {
+				l := i + batchSize
 				if l > len(deleteOps) {
 					l = len(deleteOps)
 				}
@@ -5215,7 +7931,7 @@ func (c *Collection) writeOp(op interface{}, ordered bool) (lerr *LastError, err
 	} else if updateOps, ok := op.(bulkUpdateOp); ok {
 		var lerr LastError
 		for i, updateOp := range updateOps {
-			oplerr, err := c.writeOpQuery(socket, safeOp, updateOp, ordered)
+			oplerr, err := c.writeOpQuery(socket, safeOp, updateOp, ordered, buffered)
 			lerr.N += oplerr.N
 			lerr.modified += oplerr.modified
 			if err != nil {
@@ -5232,7 +7948,7 @@ func (c *Collection) writeOp(op interface{}, ordered bool) (lerr *LastError, err
 	} else if deleteOps, ok := op.(bulkDeleteOp); ok {
 		var lerr LastError
 		for i, deleteOp := range deleteOps {
-			oplerr, err := c.writeOpQuery(socket, safeOp, deleteOp, ordered)
+			oplerr, err := c.writeOpQuery(socket, safeOp, deleteOp, ordered, buffered)
 			lerr.N += oplerr.N
 			lerr.modified += oplerr.modified
 			if err != nil {
@@ -5247,12 +7963,33 @@ func (c *Collection) writeOp(op interface{}, ordered bool) (lerr *LastError, err
 		}
 		return &lerr, nil
 	}
-	return c.writeOpQuery(socket, safeOp, op, ordered)
+	return c.writeOpQuery(socket, safeOp, op, ordered, buffered)
 }
 
-func (c *Collection) writeOpQuery(socket *mongoSocket, safeOp *queryOp, op interface{}, ordered bool) (lerr *LastError, err error) {
+// writeOpQuery sends op with the legacy wire protocol, followed by safeOp
+// if provided.  With buffered set, as done by writeOp for sessions with
+// write buffering enabled and safeOp nil, op is queued on the socket
+// rather than written right away, and inserts are split in batches that
+// respect the limits of the server as write commands would be.
+func (c *Collection) writeOpQuery(socket *mongoSocket, safeOp *queryOp, op interface{}, ordered, buffered bool) (lerr *LastError, err error) {
 	if safeOp == nil {
-		return nil, socket.Query(op)
+		if !buffered {
+			return nil, socket.Query(op)
+		}
+		iop, ok := op.(*insertOp)
+		if !ok {
+			return nil, socket.QueryBuffered(op)
+		}
+		batchSize, batchBytes := writeBatchLimits(socket.ServerInfo())
+		for i, l := 0, 0; i < len(iop.documents); i = l {
+			l = insertBatchEnd(iop.documents, i, batchSize, batchBytes)
+			batch := *iop
+			batch.documents = iop.documents[i:l]
+			if err := socket.QueryBuffered(&batch); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
 	}
 
 	var mutex sync.Mutex
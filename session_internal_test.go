@@ -3,9 +3,18 @@ package mgo
 import (
 	"crypto/x509/pkix"
 	"encoding/asn1"
+	"errors"
 	"github.com/globalsign/mgo/bson"
 	. "gopkg.in/check.v1"
+	"io"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 type S struct{}
@@ -62,3 +71,1206 @@ func (s *S) TestGetRFC2253NameStringMultiValued(c *C) {
 
 	c.Assert(getRFC2253NameString(&RDNElements), Equals, "OU=Sales+CN=J. Smith,O=Widget Inc.,C=US")
 }
+
+func (s *S) TestSyncBackoffDefault(c *C) {
+	cluster := &mongoCluster{}
+	for failures := uint(0); failures < 5; failures++ {
+		c.Assert(cluster.syncBackoff(failures), Equals, syncShortDelay)
+	}
+}
+
+func (s *S) TestServerSyncTimeout(c *C) {
+	cluster := &mongoCluster{}
+	c.Assert(cluster.serverSyncTimeout(), Equals, syncSocketTimeout)
+
+	cluster.SetSeedTimeout(200 * time.Millisecond)
+	c.Assert(cluster.serverSyncTimeout(), Equals, 200*time.Millisecond)
+
+	cluster.SetSeedTimeout(0)
+	c.Assert(cluster.serverSyncTimeout(), Equals, syncSocketTimeout)
+}
+
+func (s *S) TestSyncBackoffGrows(c *C) {
+	cluster := &mongoCluster{}
+	cluster.SetSyncBackoff(100*time.Millisecond, 2*time.Second)
+
+	c.Assert(cluster.syncBackoff(0), Equals, 100*time.Millisecond)
+
+	var last time.Duration
+	for failures := uint(1); failures < 5; failures++ {
+		limit := 100 * time.Millisecond << failures
+		delay := cluster.syncBackoff(failures)
+		c.Assert(delay >= limit/2, Equals, true)
+		c.Assert(delay <= limit, Equals, true)
+		c.Assert(delay >= last, Equals, true)
+		last = limit
+	}
+
+	for _, failures := range []uint{5, 10, 64} {
+		delay := cluster.syncBackoff(failures)
+		c.Assert(delay >= time.Second, Equals, true)
+		c.Assert(delay <= 2*time.Second, Equals, true)
+	}
+
+	// Shifting large delays must not overflow into short ones.
+	cluster.SetSyncBackoff(time.Hour, 24*time.Hour)
+	for _, failures := range []uint{5, 22, 30, 40, 62, 63} {
+		delay := cluster.syncBackoff(failures)
+		c.Assert(delay >= 12*time.Hour, Equals, true)
+		c.Assert(delay <= 24*time.Hour, Equals, true)
+	}
+}
+
+func (s *S) TestClampPrefetch(c *C) {
+	c.Assert(clampPrefetch(0), Equals, 0.0)
+	c.Assert(clampPrefetch(0.25), Equals, 0.25)
+	c.Assert(clampPrefetch(1), Equals, 1.0)
+	c.Assert(clampPrefetch(-0.5), Equals, 0.0)
+	c.Assert(clampPrefetch(1.5), Equals, 1.0)
+}
+
+func (s *S) TestQueryPrefetchClamped(c *C) {
+	q := &Query{}
+	c.Assert(q.Prefetch(0).prefetch, Equals, 0.0)
+	c.Assert(q.Prefetch(1).prefetch, Equals, 1.0)
+	c.Assert(q.Prefetch(2).prefetch, Equals, 1.0)
+	c.Assert(q.Prefetch(-1).prefetch, Equals, 0.0)
+}
+
+func (s *S) TestAndSelectors(c *C) {
+	c.Assert(andSelectors(nil, bson.M{"a": 1}), DeepEquals, bson.M{"a": 1})
+	c.Assert(andSelectors(bson.M{"a": 1}, nil), DeepEquals, bson.M{"a": 1})
+
+	var merged bson.M
+	data, err := bson.Marshal(andSelectors(bson.M{"a": 1}, bson.D{{Name: "b", Value: 2}}))
+	c.Assert(err, IsNil)
+	c.Assert(bson.Unmarshal(data, &merged), IsNil)
+	c.Assert(merged, DeepEquals, bson.M{"a": 1, "b": 2})
+
+	gte := bson.M{"n": bson.M{"$gte": 10}}
+	lt := bson.M{"n": bson.M{"$lt": 20}}
+	c.Assert(andSelectors(gte, lt), DeepEquals, bson.D{{Name: "$and", Value: []interface{}{gte, lt}}})
+}
+
+func (s *S) TestNearSelector(c *C) {
+	q := (&Query{}).Near("loc", -73.97, 40.77, 500)
+	c.Assert(q.op.query, DeepEquals, bson.D{{Name: "loc", Value: bson.D{{Name: "$near", Value: bson.D{
+		{Name: "$geometry", Value: bson.D{{Name: "type", Value: "Point"}, {Name: "coordinates", Value: []float64{-73.97, 40.77}}}},
+		{Name: "$maxDistance", Value: 500.0},
+	}}}}})
+
+	q = (&Query{}).Near("loc", 1, 2, 0)
+	near := q.op.query.(bson.D)[0].Value.(bson.D)[0].Value.(bson.D)
+	c.Assert(near, HasLen, 1)
+}
+
+func (s *S) TestOrSelectors(c *C) {
+	a := bson.M{"n": 42}
+	b := bson.M{"n": 44}
+	c.Assert(orSelectors(a, nil), DeepEquals, a)
+	c.Assert(orSelectors(nil, []interface{}{a, b}), DeepEquals, bson.D{{Name: "$or", Value: []interface{}{a, b}}})
+	c.Assert(orSelectors(bson.M{}, []interface{}{b}), DeepEquals, bson.D{{Name: "$or", Value: []interface{}{b}}})
+	c.Assert(orSelectors(a, []interface{}{b}), DeepEquals, bson.D{{Name: "$or", Value: []interface{}{a, b}}})
+
+	q := (&Query{}).Or(a)
+	c.Assert(q.op.query, DeepEquals, bson.D{{Name: "$or", Value: []interface{}{a}}})
+}
+
+func (s *S) TestScopedSelector(c *C) {
+	session := &Session{baseSelector: bson.M{"tenant": "a"}}
+	coll := &Collection{Database: &Database{Session: session, Name: "db"}, Name: "c", FullName: "db.c"}
+
+	q := coll.Find(nil)
+	c.Assert(q.op.query, DeepEquals, bson.M{"tenant": "a"})
+
+	q = coll.Find(bson.M{"n": 1})
+	c.Assert(q.op.query, DeepEquals, bson.RawD{
+		{Name: "tenant", Value: bson.Raw{Kind: 0x02, Data: []byte("\x02\x00\x00\x00a\x00")}},
+		{Name: "n", Value: bson.Raw{Kind: 0x10, Data: []byte("\x01\x00\x00\x00")}},
+	})
+
+	// The base selector can't be overridden.
+	other := bson.M{"tenant": "b"}
+	q = coll.Find(other)
+	c.Assert(q.op.query, DeepEquals, bson.D{{Name: "$and", Value: []interface{}{bson.M{"tenant": "a"}, other}}})
+
+	// Alternatives added with Or stay within the scope.
+	var m bson.M
+	data, err := bson.Marshal(coll.Find(nil).Or(other).op.query)
+	c.Assert(err, IsNil)
+	c.Assert(bson.Unmarshal(data, &m), IsNil)
+	c.Assert(m, DeepEquals, bson.M{
+		"tenant": "a",
+		"$or":    []interface{}{bson.M{"tenant": "a"}, bson.M{"tenant": "b"}},
+	})
+
+	// Commands and system collections are left alone.
+	cmd := &Collection{Database: coll.Database, Name: "$cmd", FullName: "db.$cmd"}
+	c.Assert(cmd.Find(bson.M{"ping": 1}).op.query, DeepEquals, bson.M{"ping": 1})
+	sys := &Collection{Database: coll.Database, Name: "system.indexes", FullName: "db.system.indexes"}
+	c.Assert(sys.Find(nil).op.query, IsNil)
+
+	session.baseSelector = nil
+	c.Assert(coll.Find(nil).op.query, IsNil)
+}
+
+func (s *S) TestDropClosedSession(c *C) {
+	db := &Database{Session: &Session{}, Name: "db"}
+	c.Assert(db.DropDatabase(), Equals, ErrClosed)
+	c.Assert(db.C("c").DropCollection(), Equals, ErrClosed)
+}
+
+func (s *S) TestExistsOperator(c *C) {
+	q := (&Query{}).Exists("a", true)
+	c.Assert(q.op.query, DeepEquals, bson.D{{Name: "a", Value: bson.D{{Name: "$exists", Value: true}}}})
+
+	var m bson.M
+	data, err := bson.Marshal(q.Exists("b", false).op.query)
+	c.Assert(err, IsNil)
+	c.Assert(bson.Unmarshal(data, &m), IsNil)
+	c.Assert(m, DeepEquals, bson.M{"a": bson.M{"$exists": true}, "b": bson.M{"$exists": false}})
+}
+
+func (s *S) TestInOperator(c *C) {
+	q := (&Query{}).In("n", []int{42, 44})
+	c.Assert(q.err, IsNil)
+	c.Assert(q.op.query, DeepEquals, bson.D{{Name: "n", Value: bson.D{{Name: "$in", Value: []interface{}{42, 44}}}}})
+
+	q = (&Query{}).In("n", [2]string{"a", "b"})
+	c.Assert(q.op.query, DeepEquals, bson.D{{Name: "n", Value: bson.D{{Name: "$in", Value: []interface{}{"a", "b"}}}}})
+
+	q = (&Query{}).In("n", 42)
+	c.Assert(q.err, ErrorMatches, "In requires a slice or array of values, got int")
+	c.Assert(q.op.query, IsNil)
+}
+
+func (s *S) TestAddFieldOperator(c *C) {
+	asMap := func(selector interface{}) bson.M {
+		var m bson.M
+		data, err := bson.Marshal(selector)
+		c.Assert(err, IsNil)
+		c.Assert(bson.Unmarshal(data, &m), IsNil)
+		return m
+	}
+
+	q := (&Query{}).Gte("n", 42).Lt("n", 45)
+	c.Assert(asMap(q.op.query), DeepEquals, bson.M{"n": bson.M{"$gte": 42, "$lt": 45}})
+
+	sel := addFieldOperator(bson.M{"a": 1, "n": bson.M{"$ne": 43}}, "n", "$lte", 50)
+	c.Assert(asMap(sel), DeepEquals, bson.M{"a": 1, "n": bson.M{"$ne": 43, "$lte": 50}})
+
+	sel = addFieldOperator(bson.M{"a": 1}, "n", "$gt", 1)
+	c.Assert(asMap(sel), DeepEquals, bson.M{"a": 1, "n": bson.M{"$gt": 1}})
+
+	// Equality matches and repeated operators can't be merged.
+	eq := bson.M{"n": 42}
+	c.Assert(addFieldOperator(eq, "n", "$gt", 1), DeepEquals, andSelectors(eq, bson.D{{Name: "n", Value: bson.D{{Name: "$gt", Value: 1}}}}))
+	gt := bson.M{"n": bson.M{"$gt": 1}}
+	c.Assert(addFieldOperator(gt, "n", "$gt", 2), DeepEquals, andSelectors(gt, bson.D{{Name: "n", Value: bson.D{{Name: "$gt", Value: 2}}}}))
+}
+
+func (s *S) TestRegExSortsOptions(c *C) {
+	c.Assert(RegEx("^ab", ""), Equals, bson.RegEx{Pattern: "^ab"})
+	c.Assert(RegEx("^ab", "xmi"), Equals, bson.RegEx{Pattern: "^ab", Options: "imx"})
+}
+
+func (s *S) TestSessionBatchAndPrefetch(c *C) {
+	session := &Session{}
+	session.SetBatch(100)
+	session.SetPrefetch(0.5)
+	c.Assert(session.Batch(), Equals, 100)
+	c.Assert(session.Prefetch(), Equals, 0.5)
+
+	// The server interprets 1 as -1, so SetBatch adjusts it.
+	session.SetBatch(1)
+	c.Assert(session.Batch(), Equals, 2)
+}
+
+func (s *S) TestTextSearchQuery(c *C) {
+	q := (&Query{}).TextSearch("foo")
+	c.Assert(q.op.query, DeepEquals, bson.D{{Name: "$text", Value: bson.M{"$search": "foo"}}})
+	c.Assert(q.op.selector, DeepEquals, bson.D{{Name: "score", Value: bson.M{"$meta": "textScore"}}})
+	c.Assert(q.op.options.OrderBy, DeepEquals, bson.D{{Name: "score", Value: bson.M{"$meta": "textScore"}}})
+	c.Assert(q.op.hasOptions, Equals, true)
+
+	q = (&Query{}).Sort("n").TextSearch("foo")
+	c.Assert(q.op.options.OrderBy, DeepEquals, bson.D{{Name: "n", Value: 1}})
+}
+
+func (s *S) TestSortTextScoreSelect(c *C) {
+	meta := bson.D{{Name: "score", Value: bson.M{"$meta": "textScore"}}}
+
+	q := (&Query{}).Sort("$textScore:score", "-n")
+	c.Assert(q.op.options.OrderBy, DeepEquals, bson.D{meta[0], {Name: "n", Value: -1}})
+	c.Assert(q.op.selector, DeepEquals, meta)
+
+	// The score is added to other selected fields.
+	var fields bson.M
+	q = (&Query{}).Select(bson.M{"a": 1}).Sort("$textScore:score")
+	data, err := bson.Marshal(q.op.selector)
+	c.Assert(err, IsNil)
+	c.Assert(bson.Unmarshal(data, &fields), IsNil)
+	c.Assert(fields, DeepEquals, bson.M{"a": 1, "score": bson.M{"$meta": "textScore"}})
+
+	// An explicit selection of the score is left alone.
+	sel := bson.M{"score": bson.M{"$meta": "textScore"}}
+	q = (&Query{}).Select(sel).Sort("$textScore:score")
+	c.Assert(q.op.selector, DeepEquals, sel)
+}
+
+func (s *S) TestQueryFields(c *C) {
+	q := (&Query{}).Fields("a", "b.c")
+	c.Assert(q.op.selector, DeepEquals, bson.D{{Name: "a", Value: 1}, {Name: "b.c", Value: 1}})
+
+	q = (&Query{}).Fields("-a", "-_id")
+	c.Assert(q.op.selector, DeepEquals, bson.D{{Name: "a", Value: 0}, {Name: "_id", Value: 0}})
+
+	c.Assert(func() { (&Query{}).Fields("-") }, PanicMatches, "Fields: empty field name")
+}
+
+func (s *S) TestQueryNatural(c *C) {
+	q := (&Query{}).Natural(-1)
+	c.Assert(q.op.options.OrderBy, DeepEquals, bson.D{{Name: "$natural", Value: -1}})
+	c.Assert(q.op.hasOptions, Equals, true)
+
+	c.Assert(func() { (&Query{}).Natural(0) }, PanicMatches, "Natural: order must be 1 or -1")
+	c.Assert(func() { (&Query{}).Natural(2) }, PanicMatches, "Natural: order must be 1 or -1")
+}
+
+func (s *S) TestQueryBuildersCopy(c *C) {
+	base := (&Query{}).Sort("a").Limit(5)
+
+	derived := base.Skip(3).Limit(10).Sort("-b").Select(bson.M{"a": 1}).Batch(7).Prefetch(0.5).Comment("x")
+	c.Assert(derived, Not(Equals), base)
+	c.Assert(derived.op.skip, Equals, int64(3))
+	c.Assert(derived.limit, Equals, int32(10))
+	c.Assert(derived.op.options.OrderBy, DeepEquals, bson.D{{Name: "b", Value: -1}})
+
+	c.Assert(base.op.skip, Equals, int64(0))
+	c.Assert(base.limit, Equals, int32(5))
+	c.Assert(base.op.options.OrderBy, DeepEquals, bson.D{{Name: "a", Value: 1}})
+	c.Assert(base.op.selector, IsNil)
+	c.Assert(base.op.limit, Equals, int32(5))
+	c.Assert(base.prefetch, Equals, float64(0))
+	c.Assert(base.op.options.Comment, Equals, "")
+
+	done := make(chan *Query)
+	for i := 1; i <= 10; i++ {
+		go func(n int) { done <- base.Limit(n) }(i)
+	}
+	seen := make(map[int32]bool)
+	for i := 1; i <= 10; i++ {
+		seen[(<-done).limit] = true
+	}
+	c.Assert(seen, HasLen, 10)
+	c.Assert(base.limit, Equals, int32(5))
+}
+
+func (s *S) TestBestFitLocalThreshold(c *C) {
+	near := &mongoServer{
+		Addr:        "near",
+		pingValue:   10 * time.Millisecond,
+		info:        &mongoServerInfo{},
+		liveSockets: make([]*mongoSocket, 2),
+	}
+	far := &mongoServer{
+		Addr:      "far",
+		pingValue: 30 * time.Millisecond,
+		info:      &mongoServerInfo{},
+	}
+	servers := &mongoServers{slice: mongoServerSlice{near, far}}
+
+	// Outside of the window the nearest server wins despite its load.
+	c.Assert(servers.BestFit(Secondary, nil, 0), Equals, near)
+	c.Assert(servers.BestFit(Secondary, nil, 15*time.Millisecond), Equals, near)
+
+	// Within the window the least loaded server wins.
+	c.Assert(servers.BestFit(Secondary, nil, 50*time.Millisecond), Equals, far)
+}
+
+func (s *S) TestBestFitWithout(c *C) {
+	near := &mongoServer{
+		Addr:      "near",
+		pingValue: 10 * time.Millisecond,
+		info:      &mongoServerInfo{},
+	}
+	far := &mongoServer{
+		Addr:      "far",
+		pingValue: 30 * time.Millisecond,
+		info:      &mongoServerInfo{},
+	}
+	servers := &mongoServers{slice: mongoServerSlice{near, far}}
+
+	c.Assert(servers.Without(near).BestFit(Secondary, nil, 0), Equals, far)
+	c.Assert(servers.Without(far).BestFit(Secondary, nil, 0), Equals, near)
+	c.Assert(servers.Without(near).Without(far).BestFit(Secondary, nil, 0), IsNil)
+
+	// The original set is left untouched.
+	c.Assert(servers.Len(), Equals, 2)
+}
+
+func (s *S) TestHasSlave(c *C) {
+	master := &mongoServer{Addr: "master", info: &mongoServerInfo{Master: true}}
+	tagged := &mongoServer{Addr: "tagged", info: &mongoServerInfo{Tags: bson.D{{Name: "dc", Value: "ny"}}}}
+	cluster := &mongoCluster{}
+	cluster.servers.Add(master)
+	c.Assert(cluster.HasSlave(nil), Equals, false)
+
+	cluster.servers.Add(tagged)
+	c.Assert(cluster.HasSlave(nil), Equals, true)
+	c.Assert(cluster.HasSlave([]bson.D{{{Name: "dc", Value: "ny"}}}), Equals, true)
+	c.Assert(cluster.HasSlave([]bson.D{{{Name: "dc", Value: "sf"}}}), Equals, false)
+}
+
+func (s *S) TestIsDocument(c *C) {
+	var nilMap *bson.M
+	for _, doc := range []interface{}{nil, bson.M{}, &bson.M{}, nilMap, bson.D{}, bson.RawD{}, bson.Raw{}, struct{ A int }{}, &struct{ A int }{}} {
+		c.Check(isDocument(doc), Equals, true, Commentf("%#v", doc))
+	}
+	for _, doc := range []interface{}{1, "foo", []byte("foo"), []interface{}{bson.M{}}, []int{1}} {
+		c.Check(isDocument(doc), Equals, false, Commentf("%#v", doc))
+	}
+}
+
+func (s *S) TestLessValue(c *C) {
+	values := []interface{}{"b", 3, nil, 1.5, "a", int64(-2), true}
+	sort.SliceStable(values, func(i, j int) bool {
+		return lessValue(reflect.ValueOf(values).Index(i), reflect.ValueOf(values).Index(j))
+	})
+	c.Assert(values, DeepEquals, []interface{}{int64(-2), 1.5, 3, "a", "b", nil, true})
+}
+
+func (s *S) TestPipeOutTarget(c *C) {
+	info, err := pipeOutTarget([]bson.M{{"$match": bson.M{}}, {"$out": "coll"}})
+	c.Assert(err, IsNil)
+	c.Assert(*info, Equals, PipeOutInfo{Collection: "coll"})
+
+	info, err = pipeOutTarget([]bson.D{{{Name: "$out", Value: bson.D{{Name: "db", Value: "other"}, {Name: "coll", Value: "coll"}}}}})
+	c.Assert(err, IsNil)
+	c.Assert(*info, Equals, PipeOutInfo{Database: "other", Collection: "coll"})
+
+	_, err = pipeOutTarget([]bson.M{{"$out": "coll"}, {"$match": bson.M{}}})
+	c.Assert(err, ErrorMatches, "pipeline does not end with a \\$out stage")
+
+	_, err = pipeOutTarget(nil)
+	c.Assert(err, ErrorMatches, "pipeline does not end with a \\$out stage")
+}
+
+func (s *S) TestBackoffSleepFakeClock(c *C) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	defer HackClock(clock)()
+
+	cluster := &mongoCluster{}
+	wallStart := time.Now()
+	cluster.backoffSleep(2*syncShortDelay + syncShortDelay/2)
+
+	c.Assert(clock.Now(), Equals, start.Add(2*syncShortDelay+syncShortDelay/2))
+	c.Assert(clock.Slept(), DeepEquals, []time.Duration{syncShortDelay, syncShortDelay, syncShortDelay / 2})
+	c.Assert(time.Since(wallStart) < syncShortDelay, Equals, true)
+}
+
+func (s *S) TestCheckWriteConcern(c *C) {
+	newCluster := func(infos ...*mongoServerInfo) *mongoCluster {
+		cluster := &mongoCluster{}
+		for i, info := range infos {
+			cluster.servers.Add(&mongoServer{ResolvedAddr: "server" + strconv.Itoa(i), info: info})
+		}
+		return cluster
+	}
+
+	rs := &mongoServerInfo{SetName: "rs1", SetSize: 3}
+	full := newCluster(rs, rs, rs)
+	c.Assert(full.checkWriteConcern(nil), IsNil)
+	c.Assert(full.checkWriteConcern(3), IsNil)
+	c.Assert(full.checkWriteConcern("majority"), IsNil)
+	c.Assert(full.checkWriteConcern("dc1"), IsNil)
+	c.Assert(full.checkWriteConcern(9), ErrorMatches, "write concern w=9 cannot be satisfied by 3 data-bearing members")
+
+	degraded := newCluster(rs)
+	c.Assert(degraded.checkWriteConcern(1), IsNil)
+	c.Assert(degraded.checkWriteConcern(3), IsNil)
+	c.Assert(degraded.checkWriteConcern("majority"), ErrorMatches, "write concern w=majority cannot be satisfied with 1 of 3 data-bearing members reachable")
+
+	standalone := newCluster(&mongoServerInfo{})
+	c.Assert(standalone.checkWriteConcern(1), IsNil)
+	c.Assert(standalone.checkWriteConcern("majority"), IsNil)
+	c.Assert(standalone.checkWriteConcern(2), NotNil)
+
+	mongos := newCluster(&mongoServerInfo{Mongos: true})
+	c.Assert(mongos.checkWriteConcern(9), IsNil)
+}
+
+func (s *S) TestCheckQueryErrorCommand(c *C) {
+	data, err := bson.Marshal(bson.D{
+		{Name: "ok", Value: 0},
+		{Name: "errmsg", Value: "no such command: 'bogus'"},
+		{Name: "code", Value: 59},
+		{Name: "codeName", Value: "CommandNotFound"},
+	})
+	c.Assert(err, IsNil)
+
+	err = checkQueryError("admin.$cmd", data)
+	c.Assert(err, DeepEquals, &QueryError{Code: 59, CodeName: "CommandNotFound", Message: "no such command: 'bogus'"})
+
+	// Only command replies are inspected for errmsg.
+	c.Assert(checkQueryError("mydb.mycoll", data), IsNil)
+}
+
+func (s *S) TestBytesBatchSize(c *C) {
+	c.Assert(bytesBatchSize(1000, 1000, 10), Equals, int32(10))
+	c.Assert(bytesBatchSize(1000, 100, 10), Equals, int32(100))
+	// Large documents still make progress.
+	c.Assert(bytesBatchSize(1000, 10000, 1), Equals, int32(2))
+	// A mix of sizes is accounted for by the average.
+	c.Assert(bytesBatchSize(4000, 100+100+100+3700, 4), Equals, int32(4))
+	c.Assert(bytesBatchSize(1<<30, 0, 1), Equals, int32(1<<30))
+}
+
+func (s *S) TestQueryBatchBytes(c *C) {
+	base := &Query{}
+	q := base.BatchBytes(1 << 20)
+	c.Assert(q.batchBytes, Equals, 1<<20)
+	c.Assert(base.batchBytes, Equals, 0)
+}
+
+func (s *S) TestOpTrace(c *C) {
+	var infos []OpInfo
+	session := &Session{opHook: func(info OpInfo) { infos = append(infos, info) }}
+
+	trace := session.traceOp("query", "mydb.mycoll")
+	replied := 0
+	f := trace.replyFunc(func(err error, op *replyOp, docNum int, docData []byte) { replied++ })
+	f(nil, nil, 0, nil)
+	f(nil, nil, 1, nil)
+	trace.done(errors.New("late"))
+
+	c.Assert(replied, Equals, 2)
+	c.Assert(infos, HasLen, 1)
+	c.Assert(infos[0].Op, Equals, "query")
+	c.Assert(infos[0].Namespace, Equals, "mydb.mycoll")
+	c.Assert(infos[0].Err, IsNil)
+	c.Assert(infos[0].Started.IsZero(), Equals, false)
+
+	session.traceOp("command", "admin.$cmd").done(ErrNotFound)
+	c.Assert(infos, HasLen, 2)
+	c.Assert(infos[1].Err, Equals, ErrNotFound)
+
+	// Without a hook there's nothing to trace.
+	session.opHook = nil
+	trace = session.traceOp("query", "mydb.mycoll")
+	c.Assert(trace, IsNil)
+	trace.done(nil)
+	c.Assert(trace.replyFunc(nil), IsNil)
+}
+
+func (s *S) TestWriteOpName(c *C) {
+	c.Assert(writeOpName(&insertOp{}), Equals, "insert")
+	c.Assert(writeOpName(&updateOp{}), Equals, "update")
+	c.Assert(writeOpName(bulkUpdateOp{}), Equals, "update")
+	c.Assert(writeOpName(&deleteOp{}), Equals, "delete")
+	c.Assert(writeOpName(bulkDeleteOp{}), Equals, "delete")
+}
+
+func (s *S) TestIdValue(c *C) {
+	session := &Session{}
+	hex := "4d88e15b60f486e428412dc9"
+
+	id, err := session.idValue(hex)
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, hex)
+
+	session.coerceIds = true
+	id, err = session.idValue(hex)
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, bson.ObjectIdHex(hex))
+
+	id, err = session.idValue(42)
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, 42)
+
+	_, err = session.idValue(hex[:23])
+	c.Assert(err, ErrorMatches, `invalid ObjectId "4d88e15b60f486e428412dc": must be 24 hex characters`)
+	_, err = session.idValue("zd88e15b60f486e428412dc9")
+	c.Assert(err, ErrorMatches, `invalid ObjectId .*`)
+}
+
+func (s *S) TestStalePrimary(c *C) {
+	cluster := &mongoCluster{}
+	older := &isMasterResult{IsMaster: true, SetVersion: 1, ElectionId: bson.ObjectIdHex("7fffffff0000000000000001")}
+	newer := &isMasterResult{IsMaster: true, SetVersion: 1, ElectionId: bson.ObjectIdHex("7fffffff0000000000000002")}
+
+	c.Assert(cluster.stalePrimary(older), Equals, false)
+	c.Assert(cluster.stalePrimary(newer), Equals, false)
+	c.Assert(cluster.stalePrimary(newer), Equals, false)
+
+	// The deposed primary still answering as such must be rejected.
+	c.Assert(cluster.stalePrimary(older), Equals, true)
+
+	// A higher setVersion wins regardless of the electionId.
+	reconfigured := &isMasterResult{IsMaster: true, SetVersion: 2, ElectionId: bson.ObjectIdHex("7fffffff0000000000000001")}
+	c.Assert(cluster.stalePrimary(reconfigured), Equals, false)
+	c.Assert(cluster.stalePrimary(newer), Equals, true)
+
+	// Servers not reporting elections, such as mongos, are never stale.
+	c.Assert(cluster.stalePrimary(&isMasterResult{IsMaster: true}), Equals, false)
+}
+
+func (s *S) TestResetIndexCachePrefix(c *C) {
+	cluster := &mongoCluster{}
+	keys := []string{"db.a\x00a_1", "db.a\x00b_1", "db.ab\x00a_1", "other.a\x00a_1"}
+	for _, key := range keys {
+		cluster.CacheIndex(key, true)
+	}
+
+	cluster.ResetIndexCachePrefix("db.a\x00")
+	c.Assert(cluster.HasCachedIndex("db.a\x00a_1"), Equals, false)
+	c.Assert(cluster.HasCachedIndex("db.a\x00b_1"), Equals, false)
+	c.Assert(cluster.HasCachedIndex("db.ab\x00a_1"), Equals, true)
+
+	cluster.ResetIndexCachePrefix("db.")
+	c.Assert(cluster.HasCachedIndex("db.ab\x00a_1"), Equals, false)
+	c.Assert(cluster.HasCachedIndex("other.a\x00a_1"), Equals, true)
+}
+
+func (s *S) TestClientMetadataAppName(c *C) {
+	cluster := &mongoCluster{}
+	meta := cluster.clientMetadata()
+	c.Assert(meta["driver"], DeepEquals, bson.M{"name": "mgo", "version": "globalsign"})
+	_, ok := meta["application"]
+	c.Assert(ok, Equals, false)
+
+	cluster.SetAppName("myApp")
+	meta = cluster.clientMetadata()
+	c.Assert(meta["application"], DeepEquals, bson.M{"name": "myApp"})
+
+	session := &Session{mgoCluster: cluster}
+	err := session.SetAppName("otherApp")
+	c.Assert(err, IsNil)
+	c.Assert(cluster.clientMetadata()["application"], DeepEquals, bson.M{"name": "otherApp"})
+
+	long := strings.Repeat("a", 129)
+	err = session.SetAppName(long)
+	c.Assert(err, ErrorMatches, "appName too long, must be < 128 bytes: a+")
+	c.Assert(cluster.clientMetadata()["application"], DeepEquals, bson.M{"name": "otherApp"})
+}
+
+type fakeKeepAliveConn struct {
+	enabled bool
+	period  time.Duration
+}
+
+func (conn *fakeKeepAliveConn) SetKeepAlive(keepalive bool) error {
+	conn.enabled = keepalive
+	return nil
+}
+
+func (conn *fakeKeepAliveConn) SetKeepAlivePeriod(d time.Duration) error {
+	conn.period = d
+	return nil
+}
+
+func (s *S) TestSetKeepAlive(c *C) {
+	conn := &fakeKeepAliveConn{}
+	err := setKeepAlive(conn, 0)
+	c.Assert(err, IsNil)
+	c.Assert(conn.enabled, Equals, true)
+	c.Assert(conn.period, Equals, time.Duration(0))
+
+	conn = &fakeKeepAliveConn{}
+	err = setKeepAlive(conn, 30*time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(conn.enabled, Equals, true)
+	c.Assert(conn.period, Equals, 30*time.Second)
+}
+
+func (s *S) TestOpDocDecoding(c *C) {
+	data, err := bson.Marshal(bson.M{"inprog": []bson.M{{
+		"opid":              1234,
+		"active":            true,
+		"op":                "command",
+		"ns":                "mydb.mycoll",
+		"client":            "127.0.0.1:50000",
+		"appName":           "myApp",
+		"secs_running":      3,
+		"microsecs_running": int64(3000123),
+		"command":           bson.M{"find": "mycoll"},
+	}, {
+		"opid": "shard01:42",
+		"op":   "query",
+	}}})
+	c.Assert(err, IsNil)
+
+	var result struct{ Inprog []OpDoc }
+	err = bson.Unmarshal(data, &result)
+	c.Assert(err, IsNil)
+	c.Assert(result.Inprog, DeepEquals, []OpDoc{{
+		OpId:             1234,
+		Active:           true,
+		Op:               "command",
+		Ns:               "mydb.mycoll",
+		Client:           "127.0.0.1:50000",
+		AppName:          "myApp",
+		SecsRunning:      3,
+		MicrosecsRunning: 3000123,
+		Command:          bson.M{"find": "mycoll"},
+	}, {
+		OpId: "shard01:42",
+		Op:   "query",
+	}})
+}
+
+func (s *S) TestUnmarshalResultRaw(c *C) {
+	data, err := bson.Marshal(bson.M{"a": 1})
+	c.Assert(err, IsNil)
+
+	raw := make([]byte, 0, 64)
+	err = unmarshalResult(data, &raw)
+	c.Assert(err, IsNil)
+	c.Assert(raw, DeepEquals, data)
+
+	// The result must not alias the buffer it was copied from.
+	data[len(data)-5] = 2
+	c.Assert(raw[len(raw)-5], Equals, byte(1))
+
+	var m bson.M
+	err = unmarshalResult(raw, &m)
+	c.Assert(err, IsNil)
+	c.Assert(m, DeepEquals, bson.M{"a": 1})
+}
+
+func (s *S) TestIterAllLimited(c *C) {
+	newIter := func(n int) *Iter {
+		iter := &Iter{docsBeforeMore: -1}
+		iter.gotReply.L = &iter.m
+		iter.op.collection = "mydb.mycoll"
+		for i := 0; i < n; i++ {
+			data, err := bson.Marshal(bson.M{"n": i})
+			c.Assert(err, IsNil)
+			iter.docData.Push(data)
+		}
+		return iter
+	}
+
+	var result []struct{ N int }
+	truncated, err := newIter(5).AllLimited(3, &result)
+	c.Assert(err, IsNil)
+	c.Assert(truncated, Equals, true)
+	c.Assert(result, HasLen, 3)
+	c.Assert(result[2].N, Equals, 2)
+
+	truncated, err = newIter(3).AllLimited(3, &result)
+	c.Assert(err, IsNil)
+	c.Assert(truncated, Equals, false)
+	c.Assert(result, HasLen, 3)
+
+	truncated, err = newIter(2).AllLimited(3, &result)
+	c.Assert(err, IsNil)
+	c.Assert(truncated, Equals, false)
+	c.Assert(result, HasLen, 2)
+
+	truncated, err = newIter(2).AllLimited(0, &result)
+	c.Assert(err, IsNil)
+	c.Assert(truncated, Equals, true)
+	c.Assert(result, HasLen, 0)
+}
+
+func (s *S) TestIterNextQueryErrorMidBatch(c *C) {
+	doc := func(v interface{}) []byte {
+		data, err := bson.Marshal(v)
+		c.Assert(err, IsNil)
+		return data
+	}
+	iter := &Iter{docsBeforeMore: 10}
+	iter.gotReply.L = &iter.m
+	iter.op.collection = "mydb.mycoll"
+	iter.op.cursorId = 42
+	iter.docData.Push(doc(bson.M{"n": 1}))
+	iter.docData.Push(doc(bson.D{{Name: "$err", Value: "boom"}, {Name: "code", Value: 123}}))
+	iter.docData.Push(doc(bson.M{"n": 2}))
+
+	var result struct{ N int }
+	c.Assert(iter.Next(&result), Equals, true)
+	c.Assert(result.N, Equals, 1)
+
+	result.N = 0
+	c.Assert(iter.Next(&result), Equals, false)
+	c.Assert(result.N, Equals, 0)
+	c.Assert(iter.Err(), DeepEquals, &QueryError{Code: 123, Message: "boom"})
+	c.Assert(iter.op.cursorId, Equals, int64(0))
+
+	// Nothing after the error document is delivered.
+	c.Assert(iter.Next(&result), Equals, false)
+	c.Assert(result.N, Equals, 0)
+}
+
+func (s *S) TestIterNextTimeout(c *C) {
+	iter := &Iter{docsBeforeMore: 10, timeout: -1}
+	iter.gotReply.L = &iter.m
+	iter.op.collection = "mydb.mycoll"
+	iter.op.cursorId = 42
+	// Pretend a getMore is in flight.
+	iter.docsToReceive = 1
+	iter.SetNextTimeout(50 * time.Millisecond)
+
+	var result struct{ N int }
+	started := time.Now()
+	c.Assert(iter.Next(&result), Equals, false)
+	c.Assert(time.Since(started) >= 50*time.Millisecond, Equals, true)
+	c.Assert(iter.Timeout(), Equals, true)
+	c.Assert(iter.Err(), IsNil)
+
+	// The pending batch may still be received by a later call.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		data, _ := bson.Marshal(bson.M{"n": 1})
+		iter.m.Lock()
+		iter.docsToReceive = 0
+		iter.op.cursorId = 0
+		iter.docData.Push(data)
+		iter.gotReply.Broadcast()
+		iter.m.Unlock()
+	}()
+	c.Assert(iter.Next(&result), Equals, true)
+	c.Assert(result.N, Equals, 1)
+	c.Assert(iter.Timeout(), Equals, false)
+}
+
+func (s *S) TestSetMongosReadPref(c *C) {
+	base := &Query{}
+	tags := []bson.D{{{Name: "dc", Value: "ny"}}}
+
+	q := base.SetMongosReadPref("secondaryPreferred", tags)
+	c.Assert(q.err, IsNil)
+	c.Assert(q.op.readPref, DeepEquals, bson.D{{Name: "mode", Value: "secondaryPreferred"}, {Name: "tags", Value: tags}})
+	c.Assert(base.op.readPref, IsNil)
+
+	mongos := &mongoSocket{serverInfo: &mongoServerInfo{Mongos: true}}
+	mongod := &mongoSocket{serverInfo: &mongoServerInfo{}}
+
+	// Only mongos is told about the read preference.
+	op := q.op
+	op.query = bson.M{"a": 1}
+	c.Assert(op.finalQuery(mongod), DeepEquals, bson.M{"a": 1})
+	final, ok := op.finalQuery(mongos).(*queryWrapper)
+	c.Assert(ok, Equals, true)
+	c.Assert(final.ReadPreference, DeepEquals, q.op.readPref)
+
+	// The explicit preference wins over the session mode.
+	op = q.op
+	op.flags |= flagSlaveOk
+	op.mode = Secondary
+	final = op.finalQuery(mongos).(*queryWrapper)
+	c.Assert(final.ReadPreference, DeepEquals, q.op.readPref)
+
+	q = base.SetMongosReadPref("primary", nil)
+	c.Assert(q.err, IsNil)
+	c.Assert(q.op.readPref, DeepEquals, bson.D{{Name: "mode", Value: "primary"}})
+
+	q = base.SetMongosReadPref("primary", tags)
+	c.Assert(q.err, ErrorMatches, "tags may not be used with the primary read preference")
+	q = base.SetMongosReadPref("secondaryish", nil)
+	c.Assert(q.err, ErrorMatches, `unsupported read preference mode: "secondaryish"`)
+}
+
+func (s *S) TestIterReplyCursorNotFound(c *C) {
+	iter := &Iter{isFindCmd: true, docsToReceive: 1}
+	iter.gotReply.L = &iter.m
+	iter.op.cursorId = 42
+
+	data, err := bson.Marshal(bson.M{"ok": 0, "code": 43, "errmsg": "cursor id 42 not found"})
+	c.Assert(err, IsNil)
+	iter.replyFunc()(nil, &replyOp{replyDocs: 1}, 0, data)
+
+	c.Assert(iter.err, Equals, ErrCursor)
+	c.Assert(iter.op.cursorId, Equals, int64(0))
+}
+
+func (s *S) TestQueryModifiersCombined(c *C) {
+	q := (&Query{}).Sort("a").Hint("a").Comment("job42").SetMaxTime(5 * time.Second)
+	op := q.op
+	op.query = bson.M{"a": 1}
+	op.options.Explain = true
+
+	data, err := bson.Marshal(op.finalQuery(&mongoSocket{serverInfo: &mongoServerInfo{}}))
+	c.Assert(err, IsNil)
+	var final bson.D
+	err = bson.Unmarshal(data, &final)
+	c.Assert(err, IsNil)
+
+	var names []string
+	for _, elem := range final {
+		names = append(names, elem.Name)
+	}
+	c.Assert(names, DeepEquals, []string{"$query", "$orderby", "$hint", "$explain", "$maxTimeMS", "$comment"})
+	c.Assert(final.Map()["$comment"], Equals, "job42")
+	c.Assert(final.Map()["$maxTimeMS"], Equals, 5000)
+
+	// The find command carries them all as well.
+	op = q.op
+	op.collection = "mydb.mycoll"
+	c.Assert(prepareFindOp(&mongoSocket{serverInfo: &mongoServerInfo{MaxWireVersion: 4}}, &op, 0), Equals, true)
+	find := op.query.(*findCmd)
+	c.Assert(find.Comment, Equals, "job42")
+	c.Assert(find.MaxTimeMS, Equals, 5000)
+	c.Assert(find.Hint, NotNil)
+	c.Assert(find.Sort, NotNil)
+}
+
+func (s *S) TestAcquireSocketDeadline(c *C) {
+	clock := NewFakeClock(time.Unix(1e9, 0))
+	defer setClock(clock)()
+
+	session := &Session{deadline: clock.Now().Add(time.Second)}
+	clock.Advance(time.Second)
+	_, err := session.acquireSocket(true)
+	c.Assert(err, Equals, ErrDeadline)
+}
+
+func (s *S) TestCheckDocSizes(c *C) {
+	op := &insertOp{collection: "mydb.mycoll", documents: []interface{}{bson.M{"a": 1}, bson.M{"b": "xxxxxxxxxx"}}}
+
+	checked, err := checkDocSizes(op, 0)
+	c.Assert(err, IsNil)
+	c.Assert(checked.collection, Equals, "mydb.mycoll")
+	c.Assert(checked.documents, HasLen, 2)
+	var doc bson.M
+	c.Assert(checked.documents[1].(bson.Raw).Unmarshal(&doc), IsNil)
+	c.Assert(doc, DeepEquals, bson.M{"b": "xxxxxxxxxx"})
+
+	// The original operation is left untouched.
+	c.Assert(op.documents[0], DeepEquals, bson.M{"a": 1})
+
+	_, err = checkDocSizes(op, 20)
+	c.Assert(err, DeepEquals, &DocTooLargeError{Index: 1, Size: 23, MaxSize: 20})
+	c.Assert(err, ErrorMatches, "document 1 is 23 bytes, larger than the maximum of 20 bytes accepted by the server")
+}
+
+func (s *S) TestWriteBatchLimits(c *C) {
+	count, bytes := writeBatchLimits(&mongoServerInfo{})
+	c.Assert(count, Equals, 1000)
+	c.Assert(bytes, Equals, defaultMaxBsonSize)
+
+	count, bytes = writeBatchLimits(&mongoServerInfo{MaxWriteBatch: 100000, MaxBsonSize: 1024, MaxMessageSize: 48000000})
+	c.Assert(count, Equals, 100000)
+	c.Assert(bytes, Equals, 1024)
+
+	_, bytes = writeBatchLimits(&mongoServerInfo{MaxBsonSize: 1024, MaxMessageSize: 512})
+	c.Assert(bytes, Equals, 512)
+}
+
+func (s *S) TestInsertBatchEnd(c *C) {
+	docs := make([]interface{}, 10)
+	for i := range docs {
+		docs[i] = bson.Raw{Kind: 0x03, Data: make([]byte, 97)} // 100 bytes with type and index.
+	}
+	c.Assert(insertBatchEnd(docs, 0, 1000, 1e6), Equals, 10)
+	c.Assert(insertBatchEnd(docs, 0, 4, 1e6), Equals, 4)
+	c.Assert(insertBatchEnd(docs, 8, 4, 1e6), Equals, 10)
+	c.Assert(insertBatchEnd(docs, 0, 1000, 300), Equals, 3)
+	c.Assert(insertBatchEnd(docs, 3, 1000, 300), Equals, 6)
+
+	// A batch always makes progress.
+	c.Assert(insertBatchEnd(docs, 0, 1000, 10), Equals, 1)
+
+	// Documents of unknown size only count towards the count limit.
+	c.Assert(insertBatchEnd([]interface{}{bson.M{}, bson.M{}}, 0, 1000, 10), Equals, 2)
+}
+
+func (s *S) TestUpdateOpPreservesOrder(c *C) {
+	update := bson.D{
+		{Name: "$set", Value: bson.D{{Name: "b", Value: 1}, {Name: "a", Value: 2}}},
+		{Name: "$inc", Value: bson.M{"n": 1}},
+		{Name: "$push", Value: bson.M{"l": 3}},
+	}
+	data, err := bson.Marshal(&updateOp{Selector: bson.M{"_id": 1}, Update: update})
+	c.Assert(err, IsNil)
+
+	var op struct {
+		U bson.RawD
+	}
+	c.Assert(bson.Unmarshal(data, &op), IsNil)
+	var names []string
+	for _, elem := range op.U {
+		names = append(names, elem.Name)
+	}
+	c.Assert(names, DeepEquals, []string{"$set", "$inc", "$push"})
+
+	var set bson.D
+	c.Assert(op.U[0].Value.Unmarshal(&set), IsNil)
+	c.Assert(set, DeepEquals, bson.D{{Name: "b", Value: 1}, {Name: "a", Value: 2}})
+}
+
+func (s *S) TestSlaveOkCommand(c *C) {
+	c.Assert(slaveOkCommand("serverStatus"), Equals, true)
+	c.Assert(slaveOkCommand("dropDatabase"), Equals, false)
+	c.Assert(slaveOkCommand(bson.D{{Name: "fsync", Value: 1}, {Name: "lock", Value: true}}), Equals, true)
+	c.Assert(slaveOkCommand(bson.D{{Name: "collStats", Value: "c"}}), Equals, true)
+	c.Assert(slaveOkCommand(bson.M{"count": "c"}), Equals, true)
+	c.Assert(slaveOkCommand(bson.D{{Name: "create", Value: "c"}}), Equals, false)
+	c.Assert(slaveOkCommand(bson.D{}), Equals, false)
+	c.Assert(slaveOkCommand(42), Equals, false)
+
+	match := bson.M{"$match": bson.M{"n": 1}}
+	c.Assert(slaveOkCommand(bson.D{
+		{Name: "aggregate", Value: "c"},
+		{Name: "pipeline", Value: []bson.M{match}},
+	}), Equals, true)
+	c.Assert(slaveOkCommand(bson.D{
+		{Name: "aggregate", Value: "c"},
+		{Name: "pipeline", Value: []bson.M{match, {"$out": "other"}}},
+	}), Equals, false)
+
+	c.Assert(slaveOkCommand(bson.D{
+		{Name: "mapReduce", Value: "c"},
+		{Name: "out", Value: bson.M{"inline": 1}},
+	}), Equals, true)
+	c.Assert(slaveOkCommand(bson.D{
+		{Name: "mapReduce", Value: "c"},
+		{Name: "out", Value: "other"},
+	}), Equals, false)
+}
+
+func (s *S) TestUpdateOpSetOnInsert(c *C) {
+	op := updateOp{
+		Selector: bson.M{"k": 42},
+		Update:   bson.D{{Name: "$inc", Value: bson.M{"n": 1}}, {Name: "$setOnInsert", Value: bson.M{"created": "new"}}},
+		Upsert:   true,
+	}
+	data, err := bson.Marshal(&op)
+	c.Assert(err, IsNil)
+
+	var doc struct {
+		Q      bson.M
+		U      bson.D
+		Upsert bool
+	}
+	c.Assert(bson.Unmarshal(data, &doc), IsNil)
+	c.Assert(doc.Q, DeepEquals, bson.M{"k": 42})
+	c.Assert(doc.U, DeepEquals, bson.D{
+		{Name: "$inc", Value: bson.D{{Name: "n", Value: 1}}},
+		{Name: "$setOnInsert", Value: bson.D{{Name: "created", Value: "new"}}},
+	})
+	c.Assert(doc.Upsert, Equals, true)
+}
+
+// writeCountingConn records the writes made to it.
+type writeCountingConn struct {
+	net.Conn
+	writes [][]byte
+}
+
+func (conn *writeCountingConn) Write(b []byte) (int, error) {
+	conn.writes = append(conn.writes, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (conn *writeCountingConn) SetReadDeadline(t time.Time) error  { return nil }
+func (conn *writeCountingConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (s *S) TestQueryBuffered(c *C) {
+	conn := &writeCountingConn{}
+	socket := &mongoSocket{conn: conn, replyFuncs: make(map[uint32]replyFunc)}
+
+	insert := &insertOp{collection: "db.c", documents: []interface{}{bson.M{"n": 1}}}
+	for i := 0; i < 10; i++ {
+		c.Assert(socket.QueryBuffered(insert), IsNil)
+	}
+	c.Assert(conn.writes, HasLen, 0)
+
+	c.Assert(socket.Flush(), IsNil)
+	c.Assert(conn.writes, HasLen, 1)
+	size := len(conn.writes[0]) / 10
+	c.Assert(len(conn.writes[0]), Equals, size*10)
+	c.Assert(socket.Flush(), IsNil)
+	c.Assert(conn.writes, HasLen, 1)
+
+	// Queued operations go out ahead of the next one expecting a reply.
+	c.Assert(socket.QueryBuffered(insert), IsNil)
+	query := &queryOp{collection: "db.c", query: bson.M{}, replyFunc: func(error, *replyOp, int, []byte) {}}
+	c.Assert(socket.Query(query), IsNil)
+	c.Assert(conn.writes, HasLen, 2)
+	c.Assert(getInt32(conn.writes[1], 0), Equals, int32(size))
+	c.Assert(getInt32(conn.writes[1], 12), Equals, int32(2002))
+	c.Assert(getInt32(conn.writes[1], size+12), Equals, int32(2004))
+
+	// Operations are written once enough of them are queued.
+	for i := 0; i*size < writeBufferSize; i++ {
+		c.Assert(conn.writes, HasLen, 2)
+		c.Assert(socket.QueryBuffered(insert), IsNil)
+	}
+	c.Assert(conn.writes, HasLen, 3)
+	c.Assert(len(conn.writes[2]) >= writeBufferSize, Equals, true)
+}
+
+func (s *S) TestWriteOpQueryBufferedSplitsInserts(c *C) {
+	conn := &writeCountingConn{}
+	socket := &mongoSocket{
+		conn:       conn,
+		replyFuncs: make(map[uint32]replyFunc),
+		serverInfo: &mongoServerInfo{MaxWriteBatch: 2},
+	}
+	coll := &Collection{Database: &Database{Name: "db"}, Name: "c", FullName: "db.c"}
+
+	docs := make([]interface{}, 5)
+	for i := range docs {
+		docs[i] = bson.M{"n": i}
+	}
+	_, err := coll.writeOpQuery(socket, nil, &insertOp{collection: "db.c", documents: docs}, true, true)
+	c.Assert(err, IsNil)
+	c.Assert(conn.writes, HasLen, 0)
+	c.Assert(socket.Flush(), IsNil)
+	c.Assert(conn.writes, HasLen, 1)
+
+	// Three OP_INSERT messages holding 2, 2 and 1 documents.
+	var ops []int32
+	for buf := conn.writes[0]; len(buf) > 0; buf = buf[getInt32(buf, 0):] {
+		ops = append(ops, getInt32(buf, 12))
+	}
+	c.Assert(ops, DeepEquals, []int32{2002, 2002, 2002})
+}
+
+type decodeCat struct {
+	Kind  string
+	Lives int
+}
+
+type decodeDog struct {
+	Kind string
+	Good bool
+}
+
+func (s *S) TestDecodeByType(c *C) {
+	types := map[string]reflect.Type{
+		"cat": reflect.TypeOf(decodeCat{}),
+		"dog": reflect.TypeOf(&decodeDog{}),
+	}
+	marshal := func(doc interface{}) []byte {
+		data, err := bson.Marshal(doc)
+		c.Assert(err, IsNil)
+		return data
+	}
+
+	v, err := decodeByType(marshal(bson.M{"kind": "cat", "lives": 9}), "kind", types)
+	c.Assert(err, IsNil)
+	c.Assert(v, DeepEquals, decodeCat{Kind: "cat", Lives: 9})
+
+	v, err = decodeByType(marshal(bson.M{"kind": "dog", "good": true}), "kind", types)
+	c.Assert(err, IsNil)
+	c.Assert(v, DeepEquals, &decodeDog{Kind: "dog", Good: true})
+
+	_, err = decodeByType(marshal(bson.M{"kind": "fish"}), "kind", types)
+	c.Assert(err, ErrorMatches, `no type registered for document with kind "fish"`)
+	_, err = decodeByType(marshal(bson.M{"name": "rex"}), "kind", types)
+	c.Assert(err, ErrorMatches, `document has no "kind" field`)
+	_, err = decodeByType(marshal(bson.M{"kind": 1}), "kind", types)
+	c.Assert(err, ErrorMatches, `document field "kind" doesn't hold a string`)
+}
+
+func (s *S) TestSafeSurvivesRefresh(c *C) {
+	session := &Session{}
+	session.SetSafe(&Safe{WMode: "majority", WTimeout: 1000, J: true})
+	safe := session.Safe()
+
+	op := session.safeOp
+	session.Refresh()
+	c.Assert(session.Safe(), DeepEquals, safe)
+	c.Assert(session.safeOp, Equals, op)
+}
+
+func (s *S) TestUpdateOpArrayOperators(c *C) {
+	update := bson.M{
+		"$addToSet": bson.M{"l": bson.M{"$each": []int{1, 2}}},
+		"$pullAll":  bson.M{"m": []string{"a", "b"}},
+		"$pull":     bson.M{"d": bson.M{"n": bson.M{"$gt": 3}}},
+		"$pop":      bson.M{"p": -1},
+		"$rename":   bson.M{"old": "new"},
+	}
+	data, err := bson.Marshal(&updateOp{Selector: bson.M{}, Update: update, Multi: true})
+	c.Assert(err, IsNil)
+
+	var doc struct{ U bson.M }
+	c.Assert(bson.Unmarshal(data, &doc), IsNil)
+	c.Assert(doc.U, DeepEquals, bson.M{
+		"$addToSet": bson.M{"l": bson.M{"$each": []interface{}{1, 2}}},
+		"$pullAll":  bson.M{"m": []interface{}{"a", "b"}},
+		"$pull":     bson.M{"d": bson.M{"n": bson.M{"$gt": 3}}},
+		"$pop":      bson.M{"p": -1},
+		"$rename":   bson.M{"old": "new"},
+	})
+}
+
+func (s *S) TestFillTimeout(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	peer, err := l.Accept()
+	c.Assert(err, IsNil)
+
+	b := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	err = fill(conn, b)
+	c.Assert(IsTimeout(err), Equals, true)
+	terr, ok := err.(*TimeoutError)
+	c.Assert(ok, Equals, true)
+	c.Assert(terr.Is(ErrTimeout), Equals, true)
+	c.Assert(terr.Is(io.EOF), Equals, false)
+	c.Assert(terr.Unwrap(), Not(IsNil))
+	c.Assert(err, ErrorMatches, ".*"+regexp.QuoteMeta(l.Addr().String())+": i/o timeout")
+	nerr, ok := err.(net.Error)
+	c.Assert(ok, Equals, true)
+	c.Assert(nerr.Timeout(), Equals, true)
+
+	conn.SetReadDeadline(time.Time{})
+	peer.Close()
+	err = fill(conn, b)
+	c.Assert(err, Equals, io.EOF)
+
+	c.Assert(netError(nil), IsNil)
+	c.Assert(netError(io.EOF), Equals, io.EOF)
+	c.Assert(IsTimeout(io.EOF), Equals, false)
+	c.Assert(IsTimeout(ErrTimeout), Equals, true)
+}
+
+func (s *S) TestDocAllocator(c *C) {
+	var docs docAllocator
+
+	a := docs.alloc(16)
+	b := docs.alloc(16)
+	c.Assert(a, HasLen, 16)
+	c.Assert(cap(a), Equals, 16)
+
+	// Appending to a buffer must not overwrite the following one.
+	b[0] = 1
+	a = append(a, 2)
+	c.Assert(b[0], Equals, byte(1))
+
+	large := docs.alloc(docChunkSize/4 + 1)
+	c.Assert(large, HasLen, docChunkSize/4+1)
+
+	// Chunks are replaced once exhausted.
+	for i := 0; i < 10; i++ {
+		c.Assert(docs.alloc(docChunkSize/4), HasLen, docChunkSize/4)
+	}
+}
+
+var docSink []byte
+
+func BenchmarkReadDocsMake(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		docSink = make([]byte, 200)
+	}
+}
+
+func BenchmarkReadDocsAllocator(b *testing.B) {
+	var docs docAllocator
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		docSink = docs.alloc(200)
+	}
+}
@@ -27,11 +27,13 @@
 package mgo_test
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"math"
 	"math/rand"
 	"os"
+	"reflect"
 	"runtime"
 	"sort"
 	"strconv"
@@ -56,6 +58,111 @@ func (s *S) TestRunString(c *C) {
 	c.Assert(result.Ok, Equals, 1)
 }
 
+func (s *S) TestRunRawResult(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	var raw bson.Raw
+	err = session.Run("isMaster", &raw)
+	c.Assert(err, IsNil)
+	c.Assert(raw.Kind, Equals, byte(0x03))
+
+	var result struct{ IsMaster bool }
+	err = raw.Unmarshal(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.IsMaster, Equals, true)
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"n": 1})
+	c.Assert(err, IsNil)
+
+	raw = bson.Raw{}
+	err = coll.Find(nil).Select(M{"_id": 0}).One(&raw)
+	c.Assert(err, IsNil)
+	data, err := bson.Marshal(M{"n": 1})
+	c.Assert(err, IsNil)
+	c.Assert(raw.Data, DeepEquals, data)
+}
+
+func (s *S) TestRunAdminWithDefaultDatabase(c *C) {
+	session, err := mgo.Dial("localhost:40001/mydb")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	err = session.DB("").C("mycoll").Insert(M{"n": 1})
+	c.Assert(err, IsNil)
+
+	// listDatabases is only accepted on admin; the default database
+	// must not affect where Session.Run sends it.
+	var result struct {
+		Databases []struct{ Name string }
+	}
+	err = session.Run("listDatabases", &result)
+	c.Assert(err, IsNil)
+
+	found := false
+	for _, db := range result.Databases {
+		found = found || db.Name == "mydb"
+	}
+	c.Assert(found, Equals, true)
+
+	err = session.DB("").Run("listDatabases", &result)
+	c.Assert(err, ErrorMatches, ".*admin.*")
+}
+
+func (s *S) TestRunIter(c *C) {
+	if !s.versionAtLeast(3, 2) {
+		c.Skip("getMore depends on MongoDB 3.2+")
+	}
+
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+	coll := db.C("mycoll")
+	for i := 0; i < 10; i++ {
+		coll.Insert(M{"n": i})
+	}
+
+	iter, err := db.RunIter(bson.D{
+		{Name: "aggregate", Value: "mycoll"},
+		{Name: "pipeline", Value: []M{{"$match": M{"n": M{"$gte": 3}}}, {"$sort": M{"n": 1}}}},
+		{Name: "cursor", Value: M{"batchSize": 2}},
+	})
+	c.Assert(err, IsNil)
+
+	var result struct{ N int }
+	var ns []int
+	for iter.Next(&result) {
+		ns = append(ns, result.N)
+	}
+	c.Assert(iter.Close(), IsNil)
+	c.Assert(ns, DeepEquals, []int{3, 4, 5, 6, 7, 8, 9})
+
+	_, err = db.RunIter("ping")
+	c.Assert(err, ErrorMatches, "command reply holds no cursor")
+
+	_, err = db.RunIter(bson.D{{Name: "aggregate", Value: "mycoll"}, {Name: "pipeline", Value: []M{{"$bogus": 1}}}, {Name: "cursor", Value: M{}}})
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestRunCommandError(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	err = session.Run("bogusCommand", nil)
+	c.Assert(err, ErrorMatches, "no such (cmd|command).*bogusCommand.*")
+	qerr, ok := err.(*mgo.QueryError)
+	c.Assert(ok, Equals, true)
+	c.Assert(qerr.Code, Equals, 59)
+	if s.versionAtLeast(3, 4) {
+		c.Assert(qerr.CodeName, Equals, "CommandNotFound")
+	}
+}
+
 func (s *S) TestRunValue(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -87,6 +194,198 @@ func (s *S) TestPing(c *C) {
 	c.Assert(stats.ReceivedOps, Equals, 1)
 }
 
+func (s *S) TestSetOpsPerSecond(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	session.SetOpsPerSecond(20)
+
+	// The limit is shared with copies of the session.
+	copied := session.Copy()
+	defer copied.Close()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		c.Assert(session.Ping(), IsNil)
+		c.Assert(copied.Ping(), IsNil)
+	}
+	c.Assert(time.Since(start) >= 250*time.Millisecond, Equals, true)
+
+	session.SetOpsPerSecond(0)
+	start = time.Now()
+	for i := 0; i < 6; i++ {
+		c.Assert(session.Ping(), IsNil)
+	}
+	c.Assert(time.Since(start) < 250*time.Millisecond, Equals, true)
+}
+
+func (s *S) TestSetOpHook(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	var m sync.Mutex
+	var ops []string
+	session.SetOpHook(func(info mgo.OpInfo) {
+		m.Lock()
+		ops = append(ops, info.Op+" "+info.Namespace)
+		m.Unlock()
+		if info.Duration < 0 || info.Started.IsZero() {
+			panic("bad timing")
+		}
+	})
+
+	// Derived sessions inherit the hook.
+	scopy := session.Copy()
+	defer scopy.Close()
+
+	coll := scopy.DB("mydb").C("mycoll")
+	c.Assert(coll.Insert(M{"n": 1}, M{"n": 2}, M{"n": 3}), IsNil)
+	c.Assert(coll.Update(M{"n": 1}, M{"$set": M{"n": 0}}), IsNil)
+	c.Assert(coll.Find(M{"n": 0}).One(nil), IsNil)
+	c.Assert(coll.Remove(M{"n": 4}), Equals, mgo.ErrNotFound)
+	c.Assert(scopy.Ping(), IsNil)
+
+	var result []M
+	c.Assert(coll.Find(nil).Batch(2).All(&result), IsNil)
+	c.Assert(result, HasLen, 3)
+
+	m.Lock()
+	c.Assert(ops, DeepEquals, []string{
+		"insert mydb.mycoll",
+		"update mydb.mycoll",
+		"query mydb.mycoll",
+		"delete mydb.mycoll",
+		"command admin.$cmd",
+		"query mydb.mycoll",
+		"getMore mydb.mycoll",
+	})
+	m.Unlock()
+
+	session.SetOpHook(nil)
+	c.Assert(session.Ping(), IsNil)
+	m.Lock()
+	c.Assert(ops, HasLen, 7)
+	m.Unlock()
+}
+
+func (s *S) TestRunWriteCommandSafeOpCount(c *C) {
+	if !s.versionAtLeast(2, 6) {
+		c.Skip("write commands depend on 2.6+")
+	}
+
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	// Just ensure the nonce has been received.
+	c.Assert(session.Ping(), IsNil)
+
+	session.SetSafe(&mgo.Safe{W: 1})
+	mgo.ResetStats()
+
+	// Commands report their own outcome, so no getLastError is sent.
+	var result struct{ N int }
+	cmd := bson.D{{Name: "insert", Value: "mycoll"}, {Name: "documents", Value: []M{{"n": 1}}}}
+	err = session.DB("mydb").Run(cmd, &result)
+	c.Assert(err, IsNil)
+	c.Assert(result.N, Equals, 1)
+
+	stats := mgo.GetStats()
+	c.Assert(stats.SentOps, Equals, 1)
+	c.Assert(stats.ReceivedOps, Equals, 1)
+
+	mgo.ResetStats()
+
+	err = session.DB("mydb").C("mycoll").EnsureIndexKey("n")
+	c.Assert(err, IsNil)
+
+	stats = mgo.GetStats()
+	c.Assert(stats.SentOps, Equals, 1)
+	c.Assert(stats.ReceivedOps, Equals, 1)
+}
+
+func (s *S) TestClosedSessionErrClosed(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"n": 1})
+	c.Assert(err, IsNil)
+
+	session.Close()
+
+	err = coll.Insert(M{"n": 2})
+	c.Assert(err, Equals, mgo.ErrClosed)
+
+	err = coll.Find(nil).One(nil)
+	c.Assert(err, Equals, mgo.ErrClosed)
+
+	err = session.Ping()
+	c.Assert(err, Equals, mgo.ErrClosed)
+
+	err = coll.DropCollection()
+	c.Assert(err, Equals, mgo.ErrClosed)
+
+	err = session.DB("mydb").DropDatabase()
+	c.Assert(err, Equals, mgo.ErrClosed)
+}
+
+func (s *S) TestCloseTwice(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+
+	copied := session.Copy()
+	err = copied.Ping()
+	c.Assert(err, IsNil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			copied.Close()
+		}()
+	}
+	wg.Wait()
+	copied.Close()
+
+	// The original session is unaffected.
+	err = session.Ping()
+	c.Assert(err, IsNil)
+
+	session.Close()
+	session.Close()
+
+	err = session.Ping()
+	c.Assert(err, Equals, mgo.ErrClosed)
+}
+
+func (s *S) TestCloseUnblocksReads(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"n": 1})
+	c.Assert(err, IsNil)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- coll.Find(M{"$where": "sleep(10000) || true"}).One(nil)
+	}()
+	time.Sleep(500 * time.Millisecond)
+
+	session.Close()
+
+	select {
+	case err := <-done:
+		c.Assert(err, Equals, mgo.ErrClosed)
+	case <-time.After(5 * time.Second):
+		c.Fatal("Closing the session did not unblock the read")
+	}
+}
+
 func (s *S) TestDialIPAddress(c *C) {
 	session, err := mgo.Dial("127.0.0.1:40001")
 	c.Assert(err, IsNil)
@@ -157,6 +456,30 @@ func (s *S) TestURLReadPreference(c *C) {
 	}
 }
 
+func (s *S) TestURLDatabase(c *C) {
+	tests := []struct{ url, db string }{
+		{"localhost:40001", ""},
+		{"localhost:40001/", ""},
+		{"localhost:40001/mydb", "mydb"},
+		{"mongodb://localhost:40001,localhost:40002/mydb?connect=direct", "mydb"},
+	}
+	for _, test := range tests {
+		info, err := mgo.ParseURL(test.url)
+		c.Assert(err, IsNil)
+		c.Assert(info.Database, Equals, test.db)
+	}
+
+	session, err := mgo.Dial("mongodb://localhost:40001/mydb")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	err = session.DB("").C("mycoll").Insert(M{"_id": 1})
+	c.Assert(err, IsNil)
+
+	err = session.DB("mydb").C("mycoll").FindId(1).One(nil)
+	c.Assert(err, IsNil)
+}
+
 func (s *S) TestURLInvalidReadPreference(c *C) {
 	urls := []string{
 		"localhost:40001?readPreference=foo",
@@ -320,6 +643,49 @@ func (s *S) TestURLWithAppName(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *S) TestSetAppName(c *C) {
+	if !s.versionAtLeast(3, 4) {
+		c.Skip("appName depends on MongoDB 3.4+")
+	}
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	appName := "mySetAppName"
+	err = session.SetAppName(appName)
+	c.Assert(err, IsNil)
+
+	db := session.DB("mydb")
+	err = db.Run(bson.D{{Name: "profile", Value: 2}}, nil)
+	c.Assert(err, IsNil)
+	defer db.Run(bson.D{{Name: "profile", Value: 0}}, nil)
+
+	// The name is only sent when connecting, so hold on to the sockets
+	// opened before it was set to make sure a new one gets used.
+	for i := 0; i < 3; i++ {
+		held := session.Copy()
+		defer held.Close()
+		err = held.Ping()
+		c.Assert(err, IsNil)
+	}
+
+	copied := session.Copy()
+	defer copied.Close()
+	err = copied.DB("mydb").C("mycoll").Insert(M{"a": 1})
+	c.Assert(err, IsNil)
+
+	profileResult := struct {
+		AppName string `bson:"appName"`
+	}{}
+	err = db.C("system.profile").Find(M{"op": "insert"}).Sort("-ts").One(&profileResult)
+	c.Assert(err, IsNil)
+	c.Assert(profileResult.AppName, Equals, appName)
+
+	long := strings.Repeat("a", 129)
+	err = session.SetAppName(long)
+	c.Assert(err, ErrorMatches, "appName too long, must be < 128 bytes: "+long)
+}
+
 func (s *S) TestURLWithAppNameTooLong(c *C) {
 	if !s.versionAtLeast(3, 4) {
 		c.Skip("appName depends on MongoDB 3.4+")
@@ -354,41 +720,444 @@ func (s *S) TestInsertFindOne(c *C) {
 	c.Assert(result.B, Equals, 3)
 }
 
-func (s *S) TestInsertFindOneNil(c *C) {
-	session, err := mgo.Dial("localhost:40002")
+func (s *S) TestInsertReturningId(c *C) {
+	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
 	defer session.Close()
 
 	coll := session.DB("mydb").C("mycoll")
-	err = coll.Find(nil).One(nil)
-	c.Assert(err, ErrorMatches, "unauthorized.*|not authorized.*")
+
+	id, err := coll.InsertReturningId(M{"a": 1})
+	c.Assert(err, IsNil)
+	oid, ok := id.(bson.ObjectId)
+	c.Assert(ok, Equals, true)
+	c.Assert(oid.Valid(), Equals, true)
+
+	result := M{}
+	err = coll.FindId(oid).One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result["a"], Equals, 1)
+
+	id, err = coll.InsertReturningId(M{"_id": "custom", "a": 2})
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, "custom")
+
+	err = coll.FindId("custom").One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result["a"], Equals, 2)
+
+	_, err = coll.InsertReturningId(M{"_id": "custom"})
+	c.Assert(mgo.IsDup(err), Equals, true)
 }
 
-func (s *S) TestInsertFindOneMap(c *C) {
+func (s *S) TestFindAnd(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
 	defer session.Close()
 
 	coll := session.DB("mydb").C("mycoll")
-	err = coll.Insert(M{"a": 1, "b": 2})
+	for _, n := range []int{5, 10, 15, 20, 25} {
+		err = coll.Insert(M{"n": n, "odd": n%2 == 1})
+		c.Assert(err, IsNil)
+	}
+
+	var result []struct{ N int }
+	query := coll.Find(M{"n": M{"$gte": 10}})
+	err = query.And(M{"n": M{"$lt": 20}}).Sort("n").All(&result)
 	c.Assert(err, IsNil)
-	result := make(M)
-	err = coll.Find(M{"a": 1}).One(result)
+	c.Assert(result, HasLen, 2)
+	c.Assert(result[0].N, Equals, 10)
+	c.Assert(result[1].N, Equals, 15)
+
+	n, err := coll.Find(M{"n": M{"$gte": 10}}).And(M{"odd": true}).Count()
 	c.Assert(err, IsNil)
-	c.Assert(result["a"], Equals, 1)
-	c.Assert(result["b"], Equals, 2)
+	c.Assert(n, Equals, 2)
 }
 
-func (s *S) TestInsertFindAll(c *C) {
+func (s *S) TestFindOr(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
 	defer session.Close()
 
 	coll := session.DB("mydb").C("mycoll")
-	err = coll.Insert(M{"a": 1, "b": 2})
-	c.Assert(err, IsNil)
-	err = coll.Insert(M{"a": 3, "b": 4})
-	c.Assert(err, IsNil)
+	for n := 40; n < 47; n++ {
+		err = coll.Insert(M{"n": n})
+		c.Assert(err, IsNil)
+	}
+
+	var result []struct{ N int }
+	err = coll.Find(M{"n": 42}).Or(M{"n": 44}).Sort("n").All(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 2)
+	c.Assert(result[0].N, Equals, 42)
+	c.Assert(result[1].N, Equals, 44)
+
+	n, err := coll.Find(nil).Or(M{"n": 40}, M{"n": M{"$gt": 45}}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+
+	// Or composes with And.
+	n, err = coll.Find(M{"n": 42}).Or(M{"n": 44}, M{"n": 46}).And(M{"n": M{"$lt": 45}}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+}
+
+func (s *S) TestBaseSelector(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for n := 0; n < 4; n++ {
+		err = coll.Insert(M{"tenant": "a", "n": n}, M{"tenant": "b", "n": n})
+		c.Assert(err, IsNil)
+	}
+
+	scoped := session.Copy()
+	defer scoped.Close()
+	scoped.SetBaseSelector(M{"tenant": "a"})
+	tcoll := scoped.DB("mydb").C("mycoll")
+
+	n, err := tcoll.Find(nil).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 4)
+
+	// Selectors can't escape the scope.
+	n, err = tcoll.Find(M{"tenant": "b"}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+
+	n, err = tcoll.Find(nil).Or(M{"tenant": "b"}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 4)
+
+	info, err := tcoll.UpdateAll(M{"n": M{"$lt": 2}}, M{"$set": M{"seen": true}})
+	c.Assert(err, IsNil)
+	c.Assert(info.Updated, Equals, 2)
+
+	info, err = tcoll.RemoveAll(M{"n": 3})
+	c.Assert(err, IsNil)
+	c.Assert(info.Removed, Equals, 1)
+
+	_, err = tcoll.Upsert(M{"n": 10}, M{"$set": M{"new": true}})
+	c.Assert(err, IsNil)
+
+	// The other tenant is untouched.
+	var result []struct {
+		Tenant string
+		N      int
+		Seen   bool
+	}
+	err = coll.Find(M{"tenant": "b"}).Sort("n").All(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 4)
+	for i, r := range result {
+		c.Assert(r.N, Equals, i)
+		c.Assert(r.Seen, Equals, false)
+	}
+
+	n, err = coll.Find(M{"tenant": "a", "n": 10}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	n, err = coll.Find(M{"tenant": "a"}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 4)
+}
+
+func (s *S) TestFindNear(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.EnsureIndex(mgo.Index{Key: []string{"$2dsphere:loc"}})
+	c.Assert(err, IsNil)
+
+	indexes, err := coll.Indexes()
+	c.Assert(err, IsNil)
+	c.Assert(indexes, HasLen, 2)
+	c.Assert(indexes[1].Key, DeepEquals, []string{"$2dsphere:loc"})
+
+	point := func(lng, lat float64) M {
+		return M{"type": "Point", "coordinates": []float64{lng, lat}}
+	}
+	places := []struct {
+		Name     string
+		Lng, Lat float64
+	}{
+		{"far", -73.90, 40.80},
+		{"near", -73.9701, 40.7701},
+		{"nearer", -73.97, 40.77},
+	}
+	for _, p := range places {
+		err = coll.Insert(M{"name": p.Name, "loc": point(p.Lng, p.Lat)})
+		c.Assert(err, IsNil)
+	}
+
+	var result []struct{ Name string }
+	err = coll.Find(nil).Near("loc", -73.97, 40.77, 1000).All(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 2)
+	c.Assert(result[0].Name, Equals, "nearer")
+	c.Assert(result[1].Name, Equals, "near")
+
+	err = coll.Find(M{"name": M{"$ne": "nearer"}}).Near("loc", -73.97, 40.77, 0).All(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 2)
+	c.Assert(result[0].Name, Equals, "near")
+	c.Assert(result[1].Name, Equals, "far")
+}
+
+func (s *S) TestFindRange(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for n := 40; n < 47; n++ {
+		err = coll.Insert(M{"n": n})
+		c.Assert(err, IsNil)
+	}
+
+	var result []struct{ N int }
+	err = coll.Find(nil).Gte("n", 42).Lt("n", 45).Sort("n").All(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 3)
+	c.Assert(result[0].N, Equals, 42)
+	c.Assert(result[2].N, Equals, 44)
+
+	n, err := coll.Find(M{"n": M{"$ne": 43}}).Gt("n", 41).Lte("n", 45).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 3)
+}
+
+func (s *S) TestFindExists(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for n := 40; n < 47; n++ {
+		doc := M{"n": n}
+		if n%2 == 0 {
+			doc["even"] = true
+		}
+		err = coll.Insert(doc)
+		c.Assert(err, IsNil)
+	}
+
+	var result []struct{ N int }
+	err = coll.Find(nil).Exists("even", true).Sort("n").All(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 4)
+	c.Assert(result[0].N, Equals, 40)
+	c.Assert(result[3].N, Equals, 46)
+
+	err = coll.Find(nil).Exists("even", false).Sort("n").All(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 3)
+	c.Assert(result[0].N, Equals, 41)
+	c.Assert(result[2].N, Equals, 45)
+
+	// Exists composes with other conditions.
+	n, err := coll.Find(nil).Exists("even", false).Gt("n", 42).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+}
+
+type decodeCat struct {
+	Kind  string
+	Lives int
+}
+
+type decodeDog struct {
+	Kind string
+	Good bool
+}
+
+func (s *S) TestFindDecodeBy(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(
+		M{"_id": 1, "kind": "cat", "lives": 9},
+		M{"_id": 2, "kind": "dog", "good": true},
+		M{"_id": 3, "kind": "fish"},
+		M{"_id": 4, "kind": "cat", "lives": 7},
+	)
+	c.Assert(err, IsNil)
+
+	types := map[string]reflect.Type{
+		"cat": reflect.TypeOf(decodeCat{}),
+		"dog": reflect.TypeOf(&decodeDog{}),
+	}
+	iter := coll.Find(nil).Sort("_id").DecodeBy("kind", types).Iter()
+
+	doc, err := iter.NextInterface()
+	c.Assert(err, IsNil)
+	c.Assert(doc, DeepEquals, decodeCat{Kind: "cat", Lives: 9})
+
+	doc, err = iter.NextInterface()
+	c.Assert(err, IsNil)
+	c.Assert(doc, DeepEquals, &decodeDog{Kind: "dog", Good: true})
+
+	// Unknown kinds fail without interrupting the iteration.
+	_, err = iter.NextInterface()
+	c.Assert(err, ErrorMatches, `no type registered for document with kind "fish"`)
+
+	doc, err = iter.NextInterface()
+	c.Assert(err, IsNil)
+	c.Assert(doc, DeepEquals, decodeCat{Kind: "cat", Lives: 7})
+
+	doc, err = iter.NextInterface()
+	c.Assert(err, IsNil)
+	c.Assert(doc, IsNil)
+	c.Assert(iter.Close(), IsNil)
+
+	_, err = coll.Find(nil).Iter().NextInterface()
+	c.Assert(err, ErrorMatches, "no document types registered via Query.DecodeBy")
+}
+
+func (s *S) TestFindAllLimited(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for n := 0; n < 10; n++ {
+		err = coll.Insert(M{"n": n})
+		c.Assert(err, IsNil)
+	}
+
+	var result []struct{ N int }
+	truncated, err := coll.Find(nil).Sort("n").AllLimited(4, &result)
+	c.Assert(err, IsNil)
+	c.Assert(truncated, Equals, true)
+	c.Assert(result, HasLen, 4)
+	c.Assert(result[3].N, Equals, 3)
+
+	truncated, err = coll.Find(nil).AllLimited(10, &result)
+	c.Assert(err, IsNil)
+	c.Assert(truncated, Equals, false)
+	c.Assert(result, HasLen, 10)
+
+	// The cursor is closed without reading the remaining documents.
+	iter := coll.Find(nil).Batch(2).Iter()
+	truncated, err = iter.AllLimited(3, &result)
+	c.Assert(err, IsNil)
+	c.Assert(truncated, Equals, true)
+	c.Assert(result, HasLen, 3)
+	c.Assert(iter.Next(&struct{}{}), Equals, false)
+	c.Assert(iter.Err(), IsNil)
+}
+
+func (s *S) TestFindIn(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for n := 40; n < 47; n++ {
+		err = coll.Insert(M{"n": n})
+		c.Assert(err, IsNil)
+	}
+
+	var result []struct{ N int }
+	err = coll.Find(nil).In("n", []int{42, 44}).Sort("n").All(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 2)
+	c.Assert(result[0].N, Equals, 42)
+	c.Assert(result[1].N, Equals, 44)
+
+	n, err := coll.Find(nil).In("n", []int{}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+
+	err = coll.Find(nil).In("n", 42).One(nil)
+	c.Assert(err, ErrorMatches, "In requires a slice or array of values, got int")
+}
+
+func (s *S) TestFindRegEx(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for _, name := range []string{"abc", "ABD", "xab", "Abe"} {
+		err = coll.Insert(M{"name": name})
+		c.Assert(err, IsNil)
+	}
+
+	var result []struct{ Name string }
+	err = coll.Find(M{"name": mgo.RegEx("^ab", "i")}).Sort("name").All(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 3)
+	c.Assert(result[0].Name, Equals, "ABD")
+	c.Assert(result[1].Name, Equals, "Abe")
+	c.Assert(result[2].Name, Equals, "abc")
+}
+
+func (s *S) TestInsertNonDocument(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	mgo.ResetStats()
+
+	err = coll.Insert(M{"n": 1}, 42)
+	c.Assert(err, ErrorMatches, "Collection.Insert: cannot insert value of type int as a document")
+
+	err = coll.Insert([]interface{}{M{"n": 1}})
+	c.Assert(err, ErrorMatches, `Collection.Insert: cannot insert value of type \[\]interface {} as a document`)
+
+	// Nothing was sent.
+	stats := mgo.GetStats()
+	c.Assert(stats.SentOps, Equals, 0)
+
+	n, err := coll.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *S) TestInsertFindOneNil(c *C) {
+	session, err := mgo.Dial("localhost:40002")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Find(nil).One(nil)
+	c.Assert(err, ErrorMatches, "unauthorized.*|not authorized.*")
+}
+
+func (s *S) TestInsertFindOneMap(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"a": 1, "b": 2})
+	c.Assert(err, IsNil)
+	result := make(M)
+	err = coll.Find(M{"a": 1}).One(result)
+	c.Assert(err, IsNil)
+	c.Assert(result["a"], Equals, 1)
+	c.Assert(result["b"], Equals, 2)
+}
+
+func (s *S) TestInsertFindAll(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"a": 1, "b": 2})
+	c.Assert(err, IsNil)
+	err = coll.Insert(M{"a": 3, "b": 4})
+	c.Assert(err, IsNil)
 
 	type R struct{ A, B int }
 	var result []R
@@ -497,120 +1266,557 @@ func (s *S) TestDatabaseAndCollectionNames(c *C) {
 	// Try to exercise cursor logic. 2.8.0-rc3 still ignores this.
 	session.SetBatch(2)
 
-	names, err = db1.CollectionNames()
+	names, err = db1.CollectionNames()
+	c.Assert(err, IsNil)
+	c.Assert(filterDBs(names), DeepEquals, []string{"col1", "col2"})
+
+	names, err = db2.CollectionNames()
+	c.Assert(err, IsNil)
+	c.Assert(filterDBs(names), DeepEquals, []string{"col3"})
+}
+
+func (s *S) TestSelect(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	coll.Insert(M{"a": 1, "b": 2})
+
+	result := struct{ A, B int }{}
+
+	err = coll.Find(M{"a": 1}).Select(M{"b": 1}).One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.A, Equals, 0)
+	c.Assert(result.B, Equals, 2)
+}
+
+func (s *S) TestSelectFields(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	coll.Insert(M{"a": 1, "b": 2, "c": 3})
+
+	result := struct{ A, B, C int }{}
+
+	err = coll.Find(M{"a": 1}).Fields("b").One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.A, Equals, 0)
+	c.Assert(result.B, Equals, 2)
+	c.Assert(result.C, Equals, 0)
+
+	result.B = 0
+	err = coll.Find(M{"a": 1}).Fields("-b").One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.A, Equals, 1)
+	c.Assert(result.B, Equals, 0)
+	c.Assert(result.C, Equals, 3)
+}
+
+func (s *S) TestSelectId(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for i := 0; i < 3; i++ {
+		err = coll.Insert(M{"_id": i, "a": 1, "b": "text"})
+		c.Assert(err, IsNil)
+	}
+
+	var result []struct {
+		Id int `bson:"_id"`
+		A  int
+		B  string
+	}
+	err = coll.Find(nil).SelectId().Sort("_id").All(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 3)
+	for i, doc := range result {
+		c.Assert(doc.Id, Equals, i)
+		c.Assert(doc.A, Equals, 0)
+		c.Assert(doc.B, Equals, "")
+	}
+}
+
+func (s *S) TestRawResultPassthrough(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for i := 0; i < 3; i++ {
+		err = coll.Insert(M{"_id": i, "n": i * 10})
+		c.Assert(err, IsNil)
+	}
+
+	var raw []byte
+	err = coll.FindId(1).One(&raw)
+	c.Assert(err, IsNil)
+	var doc struct {
+		Id int `bson:"_id"`
+		N  int
+	}
+	err = bson.Unmarshal(raw, &doc)
+	c.Assert(err, IsNil)
+	c.Assert(doc.Id, Equals, 1)
+	c.Assert(doc.N, Equals, 10)
+
+	iter := coll.Find(nil).Sort("_id").Batch(2).Iter()
+	i := 0
+	for iter.Next(&raw) {
+		err = bson.Unmarshal(raw, &doc)
+		c.Assert(err, IsNil)
+		c.Assert(doc.Id, Equals, i)
+		c.Assert(doc.N, Equals, i*10)
+		i++
+	}
+	c.Assert(iter.Close(), IsNil)
+	c.Assert(i, Equals, 3)
+
+	err = session.DB("mydb").Run(M{"count": "mycoll"}, &raw)
+	c.Assert(err, IsNil)
+	var result struct{ N int }
+	err = bson.Unmarshal(raw, &result)
+	c.Assert(err, IsNil)
+	c.Assert(result.N, Equals, 3)
+}
+
+func (s *S) TestFindOneInlineRemainder(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(bson.D{{Name: "_id", Value: 1}, {Name: "n", Value: 42}, {Name: "extra", Value: "x"}, {Name: "more", Value: M{"a": 1}}})
+	c.Assert(err, IsNil)
+
+	type core struct {
+		Id   int       `bson:"_id"`
+		N    int       `bson:"n"`
+		Rest bson.RawD `bson:",inline"`
+	}
+	var doc core
+	err = coll.FindId(1).One(&doc)
+	c.Assert(err, IsNil)
+	c.Assert(doc.N, Equals, 42)
+	c.Assert(doc.Rest, HasLen, 2)
+	c.Assert(doc.Rest[0].Name, Equals, "extra")
+	c.Assert(doc.Rest[1].Name, Equals, "more")
+
+	// Writing the document back preserves the unknown fields.
+	doc.N++
+	err = coll.UpdateId(1, &doc)
+	c.Assert(err, IsNil)
+
+	var result bson.M
+	err = coll.FindId(1).One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, bson.M{"_id": 1, "n": 43, "extra": "x", "more": bson.M{"a": 1}})
+
+	// Remainders may also be collected in a map.
+	var withMap struct {
+		N    int    `bson:"n"`
+		Rest bson.M `bson:",inline"`
+	}
+	iter := coll.Find(nil).Iter()
+	c.Assert(iter.Next(&withMap), Equals, true)
+	c.Assert(iter.Close(), IsNil)
+	c.Assert(withMap.Rest, DeepEquals, bson.M{"_id": 1, "extra": "x", "more": bson.M{"a": 1}})
+}
+
+func (s *S) TestSelectElemMatch(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"_id": 1, "name": "a", "grades": []M{
+		{"grade": 80, "mean": 75},
+		{"grade": 85, "mean": 90},
+		{"grade": 90, "mean": 85},
+	}})
+	c.Assert(err, IsNil)
+	err = coll.Insert(M{"_id": 2, "name": "b", "grades": []M{
+		{"grade": 70, "mean": 75},
+	}})
+	c.Assert(err, IsNil)
+
+	type grade struct{ Grade, Mean int }
+	var result struct {
+		Id     int `bson:"_id"`
+		Name   string
+		Grades []grade
+	}
+
+	query := coll.Find(nil).Sort("_id").SelectElemMatch("grades", bson.M{"grade": M{"$gte": 85}})
+	err = query.One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.Id, Equals, 1)
+	c.Assert(result.Name, Equals, "")
+	c.Assert(result.Grades, DeepEquals, []grade{{85, 90}})
+
+	result.Grades = nil
+	err = query.Skip(1).One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.Id, Equals, 2)
+	c.Assert(result.Grades, IsNil)
+}
+
+func (s *S) TestInlineMap(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	var v, result1 struct {
+		A int
+		M map[string]int `bson:",inline"`
+	}
+
+	v.A = 1
+	v.M = map[string]int{"b": 2}
+	err = coll.Insert(v)
+	c.Assert(err, IsNil)
+
+	noId := M{"_id": 0}
+
+	err = coll.Find(nil).Select(noId).One(&result1)
+	c.Assert(err, IsNil)
+	c.Assert(result1.A, Equals, 1)
+	c.Assert(result1.M, DeepEquals, map[string]int{"b": 2})
+
+	var result2 M
+	err = coll.Find(nil).Select(noId).One(&result2)
+	c.Assert(err, IsNil)
+	c.Assert(result2, DeepEquals, M{"a": 1, "b": 2})
+
+}
+
+func (s *S) TestUpdate(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	ns := []int{40, 41, 42, 43, 44, 45, 46}
+	for _, n := range ns {
+		err := coll.Insert(M{"k": n, "n": n})
+		c.Assert(err, IsNil)
+	}
+
+	// No changes is a no-op and shouldn't return an error.
+	err = coll.Update(M{"k": 42}, M{"$set": M{"n": 42}})
+	c.Assert(err, IsNil)
+
+	err = coll.Update(M{"k": 42}, M{"$inc": M{"n": 1}})
+	c.Assert(err, IsNil)
+
+	result := make(M)
+	err = coll.Find(M{"k": 42}).One(result)
+	c.Assert(err, IsNil)
+	c.Assert(result["n"], Equals, 43)
+
+	err = coll.Update(M{"k": 47}, M{"k": 47, "n": 47})
+	c.Assert(err, Equals, mgo.ErrNotFound)
+
+	err = coll.Find(M{"k": 47}).One(result)
+	c.Assert(err, Equals, mgo.ErrNotFound)
+}
+
+func (s *S) TestUpdateLastError(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for _, n := range []int{40, 41, 42} {
+		err := coll.Insert(M{"k": n, "n": n})
+		c.Assert(err, IsNil)
+	}
+
+	// In the Strong mode getLastError reports on the preceding safe
+	// update, as both run on the same connection.
+	err = coll.Update(M{"k": 42}, M{"$inc": M{"n": 1}})
+	c.Assert(err, IsNil)
+
+	var lerr mgo.LastError
+	err = session.DB("mydb").Run("getLastError", &lerr)
+	c.Assert(err, IsNil)
+	c.Assert(lerr.N, Equals, 1)
+	c.Assert(lerr.UpdatedExisting, Equals, true)
+
+	err = coll.Update(M{"k": 47}, M{"$inc": M{"n": 1}})
+	c.Assert(err, Equals, mgo.ErrNotFound)
+
+	lerr = mgo.LastError{}
+	err = session.DB("mydb").Run("getLastError", &lerr)
+	c.Assert(err, IsNil)
+	c.Assert(lerr.N, Equals, 0)
+	c.Assert(lerr.UpdatedExisting, Equals, false)
+}
+
+func (s *S) TestUpdateOrderedOperators(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"_id": 1, "n": 1, "l": []int{1}})
+	c.Assert(err, IsNil)
+	err = coll.Insert(M{"_id": 2, "n": 2, "l": []int{2}})
+	c.Assert(err, IsNil)
+
+	update := bson.D{
+		{Name: "$set", Value: bson.D{{Name: "s.b", Value: 1}, {Name: "s.a", Value: 2}}},
+		{Name: "$inc", Value: M{"n": 10}},
+		{Name: "$push", Value: M{"l": 3}},
+	}
+	err = coll.Update(M{"_id": 1}, update)
+	c.Assert(err, IsNil)
+
+	var result bson.D
+	err = coll.FindId(1).One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.Map()["n"], Equals, 11)
+	c.Assert(result.Map()["l"], DeepEquals, []interface{}{1, 3})
+	// Fields created by $set keep the order they were given in.
+	c.Assert(result.Map()["s"], DeepEquals, bson.D{{Name: "b", Value: 1}, {Name: "a", Value: 2}})
+
+	info, err := coll.UpdateAll(nil, bson.D{{Name: "$inc", Value: M{"n": 1}}, {Name: "$push", Value: M{"l": 4}}})
+	c.Assert(err, IsNil)
+	c.Assert(info.Updated, Equals, 2)
+
+	var doc struct {
+		N int
+		L []int
+	}
+	err = coll.FindId(2).One(&doc)
+	c.Assert(err, IsNil)
+	c.Assert(doc.N, Equals, 3)
+	c.Assert(doc.L, DeepEquals, []int{2, 4})
+}
+
+func (s *S) TestUpdateId(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	ns := []int{40, 41, 42, 43, 44, 45, 46}
+	for _, n := range ns {
+		err := coll.Insert(M{"_id": n, "n": n})
+		c.Assert(err, IsNil)
+	}
+
+	err = coll.UpdateId(42, M{"$inc": M{"n": 1}})
+	c.Assert(err, IsNil)
+
+	result := make(M)
+	err = coll.FindId(42).One(result)
+	c.Assert(err, IsNil)
+	c.Assert(result["n"], Equals, 43)
+
+	err = coll.UpdateId(47, M{"k": 47, "n": 47})
+	c.Assert(err, Equals, mgo.ErrNotFound)
+
+	err = coll.FindId(47).One(result)
+	c.Assert(err, Equals, mgo.ErrNotFound)
+}
+
+func (s *S) TestUpdatePositional(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	err = coll.Insert(M{"_id": 1, "arr": []M{{"id": 1, "v": "a"}, {"id": 2, "v": "b"}}})
+	c.Assert(err, IsNil)
+
+	err = coll.Update(M{"_id": 1, "arr.id": 2}, M{"$set": M{"arr.$.v": "c"}})
+	c.Assert(err, IsNil)
+
+	var result struct {
+		Arr []struct {
+			Id int
+			V  string
+		}
+	}
+	err = coll.FindId(1).One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.Arr, HasLen, 2)
+	c.Assert(result.Arr[0].V, Equals, "a")
+	c.Assert(result.Arr[1].V, Equals, "c")
+
+	err = coll.Update(M{"arr.id": 3}, M{"$set": M{"arr.$.v": "d"}})
+	c.Assert(err, Equals, mgo.ErrNotFound)
+}
+
+func (s *S) TestUpdateRename(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"_id": 1, "old": 1, "sub": M{"a": 2}}, M{"_id": 2, "old": 3})
+	c.Assert(err, IsNil)
+
+	err = coll.UpdateId(1, M{"$rename": M{"old": "new", "sub.a": "sub.b"}})
+	c.Assert(err, IsNil)
+
+	var result M
+	err = coll.FindId(1).One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, M{"_id": 1, "new": 1, "sub": bson.M{"b": 2}})
+
+	info, err := coll.UpdateAll(nil, M{"$rename": M{"old": "new"}})
+	c.Assert(err, IsNil)
+	c.Assert(info.Matched, Equals, 2)
+
+	err = coll.FindId(2).One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, M{"_id": 2, "new": 3})
+}
+
+func (s *S) TestUpdatePull(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"_id": 1, "l": []int{1, 2, 3, 2, 4}, "docs": []M{{"n": 1}, {"n": 5}}})
+	c.Assert(err, IsNil)
+
+	err = coll.UpdateId(1, M{"$pull": M{"l": 2, "docs": M{"n": M{"$gt": 3}}}})
+	c.Assert(err, IsNil)
+
+	var result struct {
+		L    []int
+		Docs []struct{ N int }
+	}
+	err = coll.FindId(1).One(&result)
 	c.Assert(err, IsNil)
-	c.Assert(filterDBs(names), DeepEquals, []string{"col1", "col2"})
+	c.Assert(result.L, DeepEquals, []int{1, 3, 4})
+	c.Assert(result.Docs, HasLen, 1)
+	c.Assert(result.Docs[0].N, Equals, 1)
 
-	names, err = db2.CollectionNames()
+	err = coll.UpdateId(1, M{"$pull": M{"l": M{"$in": []int{1, 4}}}})
 	c.Assert(err, IsNil)
-	c.Assert(filterDBs(names), DeepEquals, []string{"col3"})
+	err = coll.FindId(1).One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.L, DeepEquals, []int{3})
 }
 
-func (s *S) TestSelect(c *C) {
+func (s *S) TestUpdatePullAll(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
 	defer session.Close()
 
 	coll := session.DB("mydb").C("mycoll")
-	coll.Insert(M{"a": 1, "b": 2})
+	err = coll.Insert(M{"_id": 1, "l": []string{"a", "b", "c", "a", "d"}})
+	c.Assert(err, IsNil)
 
-	result := struct{ A, B int }{}
+	err = coll.UpdateId(1, M{"$pullAll": M{"l": []string{"a", "d", "x"}}})
+	c.Assert(err, IsNil)
 
-	err = coll.Find(M{"a": 1}).Select(M{"b": 1}).One(&result)
+	var result struct{ L []string }
+	err = coll.FindId(1).One(&result)
 	c.Assert(err, IsNil)
-	c.Assert(result.A, Equals, 0)
-	c.Assert(result.B, Equals, 2)
+	c.Assert(result.L, DeepEquals, []string{"b", "c"})
 }
 
-func (s *S) TestInlineMap(c *C) {
+func (s *S) TestUpdateAddToSetEach(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
 	defer session.Close()
 
 	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"_id": 1, "l": []int{1, 2}}, M{"_id": 2, "l": []int{3}})
+	c.Assert(err, IsNil)
 
-	var v, result1 struct {
-		A int
-		M map[string]int `bson:",inline"`
-	}
-
-	v.A = 1
-	v.M = map[string]int{"b": 2}
-	err = coll.Insert(v)
+	// A plain value is added once.
+	err = coll.UpdateId(1, M{"$addToSet": M{"l": 2}})
+	c.Assert(err, IsNil)
+	err = coll.UpdateId(1, M{"$addToSet": M{"l": 3}})
 	c.Assert(err, IsNil)
 
-	noId := M{"_id": 0}
+	var result struct{ L []int }
+	err = coll.FindId(1).One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.L, DeepEquals, []int{1, 2, 3})
 
-	err = coll.Find(nil).Select(noId).One(&result1)
+	// A typed slice under $each adds its elements rather than the slice itself.
+	info, err := coll.UpdateAll(nil, M{"$addToSet": M{"l": M{"$each": []int{3, 4, 4, 5}}}})
 	c.Assert(err, IsNil)
-	c.Assert(result1.A, Equals, 1)
-	c.Assert(result1.M, DeepEquals, map[string]int{"b": 2})
+	c.Assert(info.Updated, Equals, 2)
 
-	var result2 M
-	err = coll.Find(nil).Select(noId).One(&result2)
+	err = coll.FindId(1).One(&result)
 	c.Assert(err, IsNil)
-	c.Assert(result2, DeepEquals, M{"a": 1, "b": 2})
+	c.Assert(result.L, DeepEquals, []int{1, 2, 3, 4, 5})
+	err = coll.FindId(2).One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.L, DeepEquals, []int{3, 4, 5})
 
+	// Without $each the slice is added as a single element.
+	err = coll.UpdateId(2, M{"$addToSet": M{"l": []int{6, 7}}})
+	c.Assert(err, IsNil)
+	var raw M
+	err = coll.FindId(2).One(&raw)
+	c.Assert(err, IsNil)
+	c.Assert(raw["l"], DeepEquals, []interface{}{3, 4, 5, []interface{}{6, 7}})
 }
 
-func (s *S) TestUpdate(c *C) {
+func (s *S) TestUpdatePop(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
 	defer session.Close()
 
 	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"_id": 1, "l": []int{1, 2, 3, 4}})
+	c.Assert(err, IsNil)
 
-	ns := []int{40, 41, 42, 43, 44, 45, 46}
-	for _, n := range ns {
-		err := coll.Insert(M{"k": n, "n": n})
-		c.Assert(err, IsNil)
-	}
+	var result struct{ L []int }
 
-	// No changes is a no-op and shouldn't return an error.
-	err = coll.Update(M{"k": 42}, M{"$set": M{"n": 42}})
+	err = coll.UpdateId(1, M{"$pop": M{"l": 1}})
 	c.Assert(err, IsNil)
-
-	err = coll.Update(M{"k": 42}, M{"$inc": M{"n": 1}})
+	err = coll.FindId(1).One(&result)
 	c.Assert(err, IsNil)
+	c.Assert(result.L, DeepEquals, []int{1, 2, 3})
 
-	result := make(M)
-	err = coll.Find(M{"k": 42}).One(result)
+	err = coll.UpdateId(1, M{"$pop": M{"l": -1}})
 	c.Assert(err, IsNil)
-	c.Assert(result["n"], Equals, 43)
-
-	err = coll.Update(M{"k": 47}, M{"k": 47, "n": 47})
-	c.Assert(err, Equals, mgo.ErrNotFound)
-
-	err = coll.Find(M{"k": 47}).One(result)
-	c.Assert(err, Equals, mgo.ErrNotFound)
+	err = coll.FindId(1).One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.L, DeepEquals, []int{2, 3})
 }
 
-func (s *S) TestUpdateId(c *C) {
+func (s *S) TestFindSelectPositional(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
 	defer session.Close()
 
 	coll := session.DB("mydb").C("mycoll")
 
-	ns := []int{40, 41, 42, 43, 44, 45, 46}
-	for _, n := range ns {
-		err := coll.Insert(M{"_id": n, "n": n})
-		c.Assert(err, IsNil)
-	}
-
-	err = coll.UpdateId(42, M{"$inc": M{"n": 1}})
+	err = coll.Insert(M{"_id": 1, "arr": []M{{"id": 1, "v": "a"}, {"id": 2, "v": "b"}}})
 	c.Assert(err, IsNil)
 
-	result := make(M)
-	err = coll.FindId(42).One(result)
+	var result struct {
+		Arr []struct {
+			Id int
+			V  string
+		}
+	}
+	err = coll.Find(M{"arr.id": 2}).Select(M{"arr.$": 1}).One(&result)
 	c.Assert(err, IsNil)
-	c.Assert(result["n"], Equals, 43)
-
-	err = coll.UpdateId(47, M{"k": 47, "n": 47})
-	c.Assert(err, Equals, mgo.ErrNotFound)
-
-	err = coll.FindId(47).One(result)
-	c.Assert(err, Equals, mgo.ErrNotFound)
+	c.Assert(result.Arr, HasLen, 1)
+	c.Assert(result.Arr[0].Id, Equals, 2)
+	c.Assert(result.Arr[0].V, Equals, "b")
 }
 
 func (s *S) TestUpdateNil(c *C) {
@@ -643,6 +1849,56 @@ func (s *S) TestUpdateNil(c *C) {
 	c.Assert(result["n"], Equals, 46)
 }
 
+func (s *S) TestUpdateWithMultiUpsert(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	ns := []int{40, 41, 42, 43, 44, 45, 46}
+	for _, n := range ns {
+		err := coll.Insert(M{"k": n % 2, "n": n})
+		c.Assert(err, IsNil)
+	}
+
+	opts := &mgo.UpdateOptions{Multi: true, Upsert: true}
+
+	// Matches several documents, updating all of them.
+	info, err := coll.UpdateWith(M{"k": 0}, M{"$inc": M{"n": 1}}, opts)
+	c.Assert(err, IsNil)
+	c.Assert(info.Updated, Equals, 4)
+	c.Assert(info.Matched, Equals, 4)
+	c.Assert(info.UpsertedId, IsNil)
+
+	n, err := coll.Find(M{"n": M{"$in": []int{41, 43, 45, 47}}, "k": 0}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 4)
+
+	// Matches nothing, inserting a new document.
+	info, err = coll.UpdateWith(M{"k": 2}, M{"$set": M{"n": 50}}, opts)
+	c.Assert(err, IsNil)
+	c.Assert(info.Updated, Equals, 0)
+	c.Assert(info.Matched, Equals, 0)
+	c.Assert(info.UpsertedId, NotNil)
+
+	result := M{}
+	err = coll.Find(M{"_id": info.UpsertedId}).One(result)
+	c.Assert(err, IsNil)
+	c.Assert(result["k"], Equals, 2)
+	c.Assert(result["n"], Equals, 50)
+
+	// Without options a single document is updated, and no match is not an error.
+	info, err = coll.UpdateWith(M{"k": 1}, M{"$set": M{"n": 0}}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(info.Matched, Equals, 1)
+
+	info, err = coll.UpdateWith(M{"k": 3}, M{"$set": M{"n": 0}}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(info.Matched, Equals, 0)
+	c.Assert(info.UpsertedId, IsNil)
+}
+
 func (s *S) TestUpsert(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -706,6 +1962,54 @@ func (s *S) TestUpsert(c *C) {
 	c.Assert(result["n"], Equals, 48)
 }
 
+func (s *S) TestUpsertSetOnInsert(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	err = coll.Insert(M{"k": 42, "n": 1, "created": "old"})
+	c.Assert(err, IsNil)
+
+	change := M{"$inc": M{"n": 1}, "$setOnInsert": M{"created": "new"}}
+
+	// An existing document ignores $setOnInsert.
+	info, err := coll.Upsert(M{"k": 42}, change)
+	c.Assert(err, IsNil)
+	c.Assert(info.Matched, Equals, 1)
+	c.Assert(info.UpsertedId, IsNil)
+
+	result := M{}
+	err = coll.Find(M{"k": 42}).One(result)
+	c.Assert(err, IsNil)
+	c.Assert(result["n"], Equals, 2)
+	c.Assert(result["created"], Equals, "old")
+
+	// A new document gets $setOnInsert applied.
+	info, err = coll.Upsert(M{"k": 43}, change)
+	c.Assert(err, IsNil)
+	c.Assert(info.Matched, Equals, 0)
+	c.Assert(info.UpsertedId, NotNil)
+
+	result = M{}
+	err = coll.Find(M{"_id": info.UpsertedId}).One(result)
+	c.Assert(err, IsNil)
+	c.Assert(result["k"], Equals, 43)
+	c.Assert(result["n"], Equals, 1)
+	c.Assert(result["created"], Equals, "new")
+
+	// Running it again matches the inserted document.
+	info, err = coll.Upsert(M{"k": 43}, change)
+	c.Assert(err, IsNil)
+	c.Assert(info.Matched, Equals, 1)
+
+	err = coll.Find(M{"k": 43}).One(result)
+	c.Assert(err, IsNil)
+	c.Assert(result["n"], Equals, 2)
+	c.Assert(result["created"], Equals, "new")
+}
+
 func (s *S) TestUpsertId(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -859,6 +2163,79 @@ func (s *S) TestRemoveUnsafe(c *C) {
 	c.Assert(coll.FindId(42).One(nil), IsNil)
 }
 
+func (s *S) TestWriteBuffering(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	session.SetSafe(nil)
+	session.SetWriteBuffering(true)
+
+	coll := session.DB("mydb").C("mycoll")
+	for i := 0; i < 1000; i++ {
+		err = coll.Insert(M{"_id": i})
+		c.Assert(err, IsNil)
+	}
+	err = coll.UpdateId(1, M{"$set": M{"n": 1}})
+	c.Assert(err, IsNil)
+	err = coll.RemoveId(2)
+	c.Assert(err, IsNil)
+
+	// Queries see the writes queued before them.
+	n, err := coll.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 999)
+
+	var result struct{ N int }
+	err = coll.FindId(1).One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.N, Equals, 1)
+
+	// Closing the session sends queued writes.
+	other := session.Copy()
+	err = other.DB("mydb").C("mycoll").Insert(M{"_id": 1000})
+	c.Assert(err, IsNil)
+	other.Close()
+
+	session.SetSafe(&mgo.Safe{})
+	n, err = coll.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1000)
+}
+
+func (s *S) TestRemoveNotFound(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	err = coll.Insert(M{"_id": 40})
+	c.Assert(err, IsNil)
+
+	err = coll.Remove(M{"_id": 41})
+	c.Assert(err, Equals, mgo.ErrNotFound)
+	err = coll.RemoveId(41)
+	c.Assert(err, Equals, mgo.ErrNotFound)
+
+	err = coll.RemoveId(40)
+	c.Assert(err, IsNil)
+	err = coll.RemoveId(40)
+	c.Assert(err, Equals, mgo.ErrNotFound)
+
+	info, err := coll.RemoveAll(M{"_id": 40})
+	c.Assert(err, IsNil)
+	c.Assert(info.Removed, Equals, 0)
+
+	// Without safe mode there's no way to tell.
+	session.SetSafe(nil)
+	err = coll.RemoveId(40)
+	c.Assert(err, IsNil)
+	info, err = coll.RemoveAll(M{"_id": 40})
+	c.Assert(err, IsNil)
+	c.Assert(info, IsNil)
+}
+
 func (s *S) TestRemoveAll(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -968,6 +2345,51 @@ func (s *S) TestDropCollection(c *C) {
 	c.Assert(len(filterDBs(names)), Equals, 0)
 }
 
+func (s *S) TestCompact(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for i := 0; i < 10; i++ {
+		err = coll.Insert(M{"n": i})
+		c.Assert(err, IsNil)
+	}
+
+	err = coll.Compact()
+	if qerr, ok := err.(*mgo.QueryError); ok && qerr.Code == 115 { // CommandNotSupported
+		c.Skip("compact not supported by the storage engine")
+	}
+	c.Assert(err, IsNil)
+
+	n, err := coll.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 10)
+
+	err = session.DB("mydb").C("nonexistent").Compact()
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestRepair(c *C) {
+	if s.versionAtLeast(4, 2) {
+		c.Skip("repairDatabase removed in 4.2")
+	}
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+	err = db.C("mycoll").Insert(M{"n": 1})
+	c.Assert(err, IsNil)
+
+	err = db.Repair()
+	c.Assert(err, IsNil)
+
+	n, err := db.C("mycoll").Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+}
+
 func (s *S) TestCreateCollectionCapped(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -994,6 +2416,42 @@ func (s *S) TestCreateCollectionCapped(c *C) {
 	c.Assert(n, Equals, 3)
 }
 
+func (s *S) TestDatabaseCreateCollectionTail(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+	err = db.CreateCollection("mycoll", &mgo.CollectionInfo{Capped: true, MaxBytes: 1024})
+	c.Assert(err, IsNil)
+	coll := db.C("mycoll")
+
+	ns := []int{40, 41, 42, 43}
+	for _, n := range ns {
+		err = coll.Insert(M{"n": n})
+		c.Assert(err, IsNil)
+	}
+
+	iter := coll.Find(M{"n": M{"$gte": 42}}).Sort("$natural").Tail(time.Second)
+
+	result := struct{ N int }{}
+	for i := 2; i != len(ns); i++ {
+		c.Assert(iter.Next(&result), Equals, true)
+		c.Assert(result.N, Equals, ns[i])
+	}
+
+	c.Assert(iter.Next(&result), Equals, false)
+	c.Assert(iter.Timeout(), Equals, true)
+
+	coll.Insert(M{"n": 44})
+	c.Assert(iter.Next(&result), Equals, true)
+	c.Assert(result.N, Equals, 44)
+	c.Assert(iter.Close(), IsNil)
+
+	err = db.CreateCollection("other", &mgo.CollectionInfo{Capped: true})
+	c.Assert(err, ErrorMatches, "Collection.Create: with Capped, MaxBytes must also be set")
+}
+
 func (s *S) TestCreateCollectionNoIndex(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -1375,6 +2833,31 @@ func (s *S) TestCountCollection(c *C) {
 	c.Assert(n, Equals, 3)
 }
 
+func (s *S) TestEstimatedCountCollection(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	n, err := coll.EstimatedCount()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+
+	ns := []int{40, 41, 42}
+	for _, n := range ns {
+		err := coll.Insert(M{"n": n})
+		c.Assert(err, IsNil)
+	}
+
+	exact, err := coll.Count()
+	c.Assert(err, IsNil)
+	n, err = coll.EstimatedCount()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, exact)
+	c.Assert(n, Equals, 3)
+}
+
 func (s *S) TestView(c *C) {
 	if !s.versionAtLeast(3, 4) {
 		c.Skip("depends on mongodb 3.4+")
@@ -1563,6 +3046,81 @@ func (s *S) TestCountSkipLimit(c *C) {
 	c.Assert(n, Equals, 4)
 }
 
+func (s *S) TestFindPage(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for n := 0; n < 7; n++ {
+		err := coll.Insert(M{"n": n})
+		c.Assert(err, IsNil)
+	}
+
+	query := coll.Find(M{"n": M{"$gte": 1}}).Sort("n")
+	pages := [][]int{{1, 2, 3}, {4, 5, 6}, nil}
+	for i, want := range pages {
+		iter, total, err := query.Page(i+1, 3)
+		c.Assert(err, IsNil)
+		c.Assert(total, Equals, 6)
+
+		var got []int
+		var result struct{ N int }
+		for iter.Next(&result) {
+			got = append(got, result.N)
+		}
+		c.Assert(iter.Close(), IsNil)
+		c.Assert(got, DeepEquals, want)
+	}
+
+	// Skip and limit set on the query don't affect the total.
+	iter, total, err := query.Skip(5).Limit(1).Page(1, 2)
+	c.Assert(err, IsNil)
+	c.Assert(total, Equals, 6)
+	c.Assert(iter.Close(), IsNil)
+
+	_, _, err = query.Page(0, 3)
+	c.Assert(err, ErrorMatches, "invalid page 0 with 3 documents per page")
+}
+
+func (s *S) TestAccurateCount(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	n, err := coll.AccurateCount()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+
+	ns := []int{40, 41, 42, 43, 44}
+	for _, n := range ns {
+		err := coll.Insert(M{"n": n})
+		c.Assert(err, IsNil)
+	}
+
+	n, err = coll.AccurateCount()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 5)
+
+	n, err = coll.Find(M{"n": M{"$gt": 41}}).AccurateCount()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 3)
+
+	n, err = coll.Find(M{"n": 100}).AccurateCount()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+
+	n, err = coll.Find(nil).Skip(1).Limit(3).AccurateCount()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 3)
+
+	n, err = coll.Find(nil).Skip(1).Limit(5).AccurateCount()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 4)
+}
+
 func (s *S) TestCountMaxTimeMS(c *C) {
 	if !s.versionAtLeast(2, 6) {
 		c.Skip("SetMaxTime only supported in 2.6+")
@@ -1676,8 +3234,8 @@ func (s *S) TestQuerySetMaxTime(c *C) {
 	}
 
 	query := coll.Find(nil)
-	query.SetMaxTime(1 * time.Millisecond)
-	query.Batch(2)
+	query = query.SetMaxTime(1 * time.Millisecond)
+	query = query.Batch(2)
 	var result []M
 	err = query.All(&result)
 	c.Assert(err, ErrorMatches, "operation exceeded time limit")
@@ -1724,12 +3282,12 @@ func (s *S) TestQueryComment(c *C) {
 	}
 
 	query := coll.Find(bson.M{"n": 41})
-	query.Comment("some comment")
+	query = query.Comment("some comment")
 	err = query.One(nil)
 	c.Assert(err, IsNil)
 
 	query = coll.Find(bson.M{"n": 41})
-	query.Comment("another comment")
+	query = query.Comment("another comment")
 	err = query.One(nil)
 	c.Assert(err, IsNil)
 
@@ -1766,6 +3324,36 @@ func (s *S) TestFindOneNotFound(c *C) {
 	c.Assert(err == mgo.ErrNotFound, Equals, true)
 }
 
+type failingSetter struct{}
+
+func (failingSetter) SetBSON(raw bson.Raw) error {
+	return errors.New("cannot decode value")
+}
+
+func (s *S) TestFindOneDecodeError(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	err = coll.Insert(M{"a": 1, "b": "two"})
+	c.Assert(err, IsNil)
+
+	var n int
+	err = coll.Find(M{"a": 1}).One(&n)
+	c.Assert(err, NotNil)
+	c.Assert(err, Not(Equals), mgo.ErrNotFound)
+
+	result := struct{ B *failingSetter }{}
+	err = coll.Find(M{"a": 1}).One(&result)
+	c.Assert(err, ErrorMatches, "cannot decode value")
+
+	// The same target with no matching document is still not found.
+	err = coll.Find(M{"a": 2}).One(&result)
+	c.Assert(err, Equals, mgo.ErrNotFound)
+}
+
 func (s *S) TestFindIterNotFound(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -1796,6 +3384,42 @@ func (s *S) TestFindNil(c *C) {
 	c.Assert(result.N, Equals, 1)
 }
 
+func (s *S) TestCopyWithDeadline(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"n": 1})
+	c.Assert(err, IsNil)
+
+	scopy := session.CopyWithDeadline(500 * time.Millisecond)
+	defer scopy.Close()
+
+	ccoll := coll.With(scopy)
+	err = ccoll.Insert(M{"n": 2})
+	c.Assert(err, IsNil)
+
+	// Operations in progress are cut at the deadline.
+	started := time.Now()
+	err = ccoll.Find(M{"$where": "sleep(2000); return true"}).One(nil)
+	c.Assert(err, NotNil)
+	c.Assert(time.Since(started) < 1500*time.Millisecond, Equals, true)
+
+	// Later ones fail up front, including on further copies.
+	err = ccoll.Find(nil).One(nil)
+	c.Assert(err, Equals, mgo.ErrDeadline)
+	scopy2 := scopy.Copy()
+	defer scopy2.Close()
+	err = coll.With(scopy2).Insert(M{"n": 3})
+	c.Assert(err, Equals, mgo.ErrDeadline)
+
+	// The original session is not affected.
+	n, err := coll.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+}
+
 func (s *S) TestFindId(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -1814,6 +3438,78 @@ func (s *S) TestFindId(c *C) {
 	c.Assert(result.N, Equals, 42)
 }
 
+func (s *S) TestFindIdCoerceObjectIds(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	id := bson.NewObjectId()
+	err = coll.Insert(M{"_id": id, "n": 1})
+	c.Assert(err, IsNil)
+
+	result := struct{ N int }{}
+
+	// Without coercion the hex string doesn't match the ObjectId.
+	err = coll.FindId(id.Hex()).One(&result)
+	c.Assert(err, Equals, mgo.ErrNotFound)
+
+	session.SetCoerceObjectIds(true)
+
+	err = coll.FindId(id.Hex()).One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.N, Equals, 1)
+
+	err = coll.UpdateId(id.Hex(), M{"$inc": M{"n": 1}})
+	c.Assert(err, IsNil)
+	err = coll.FindId(id).One(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result.N, Equals, 2)
+
+	// Invalid hex ids fail up front.
+	err = coll.FindId("abc").One(&result)
+	c.Assert(err, ErrorMatches, `invalid ObjectId "abc": must be 24 hex characters`)
+	n, err := coll.FindId("abc").Count()
+	c.Assert(err, ErrorMatches, "invalid ObjectId .*")
+	c.Assert(n, Equals, 0)
+	err = coll.UpdateId("abc", M{"$inc": M{"n": 1}})
+	c.Assert(err, ErrorMatches, "invalid ObjectId .*")
+	err = coll.RemoveId("abc")
+	c.Assert(err, ErrorMatches, "invalid ObjectId .*")
+
+	c.Assert(mgo.IsObjectIdHex(id.Hex()), Equals, true)
+	c.Assert(mgo.IsObjectIdHex("abc"), Equals, false)
+}
+
+func (s *S) TestFindObjectIdTimeRange(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"_id": bson.NewObjectId(), "n": 1})
+	c.Assert(err, IsNil)
+
+	// ObjectId timestamps have a resolution of one second.
+	time.Sleep(1100 * time.Millisecond)
+	cutoff := time.Now()
+
+	newer := bson.NewObjectId()
+	err = coll.Insert(M{"_id": newer, "n": 2})
+	c.Assert(err, IsNil)
+	c.Assert(newer.Time().Before(cutoff.Truncate(time.Second)), Equals, false)
+
+	var result []struct {
+		Id bson.ObjectId `bson:"_id"`
+		N  int
+	}
+	err = coll.Find(M{"_id": M{"$gt": bson.NewObjectIdWithTime(cutoff)}}).All(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 1)
+	c.Assert(result[0].Id, Equals, newer)
+	c.Assert(result[0].N, Equals, 2)
+}
+
 func (s *S) TestFindIterAll(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -1886,6 +3582,52 @@ func (s *S) TestFindIterTwiceWithSameQuery(c *C) {
 	c.Assert(result.N, Equals, 41)
 }
 
+func (s *S) TestFindForkedQueryConcurrently(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	for i := 40; i != 50; i++ {
+		err := coll.Insert(M{"n": i})
+		c.Assert(err, IsNil)
+	}
+
+	base := coll.Find(M{}).Sort("n")
+
+	type forked struct {
+		limit  int
+		result []M
+		err    error
+	}
+	done := make(chan forked)
+	for limit := 1; limit <= 8; limit++ {
+		go func(limit int) {
+			var result []M
+			err := base.Skip(limit).Limit(limit).All(&result)
+			done <- forked{limit, result, err}
+		}(limit)
+	}
+	for i := 0; i < 8; i++ {
+		f := <-done
+		c.Assert(f.err, IsNil)
+		expected := f.limit
+		if rest := 10 - f.limit; rest < expected {
+			expected = rest
+		}
+		c.Assert(f.result, HasLen, expected)
+		if len(f.result) > 0 {
+			c.Assert(f.result[0]["n"], Equals, 40+f.limit)
+		}
+	}
+
+	// The base query remains unaffected.
+	n, err := base.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 10)
+}
+
 func (s *S) TestFindIterWithoutResults(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -1903,6 +3645,37 @@ func (s *S) TestFindIterWithoutResults(c *C) {
 	c.Assert(result.N, Equals, 0)
 }
 
+func (s *S) TestFindSkipN(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	docs := make([]interface{}, 1000)
+	for i := range docs {
+		docs[i] = M{"n": i}
+	}
+	err = coll.Insert(docs...)
+	c.Assert(err, IsNil)
+
+	var result []struct{ N int }
+	err = coll.Find(nil).Sort("n").SkipN(990).All(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 10)
+	c.Assert(result[0].N, Equals, 990)
+	c.Assert(result[9].N, Equals, 999)
+
+	n, err := coll.Find(nil).SkipN(995).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 5)
+
+	if s.versionAtLeast(3, 2) {
+		err = coll.Find(nil).SkipN(math.MaxInt32 + 1).One(nil)
+		c.Assert(err, Equals, mgo.ErrNotFound)
+	}
+}
+
 func (s *S) TestFindIterLimit(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -2012,6 +3785,45 @@ func (s *S) TestResumeIter(c *C) {
 	c.Assert(len(batch), Equals, 0)
 }
 
+func (s *S) TestIterFromCursor(c *C) {
+	if !s.versionAtLeast(3, 2) {
+		c.Skip("getMore depends on MongoDB 3.2+")
+	}
+	const numDocuments = 10
+
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for i := 0; i < numDocuments; i++ {
+		coll.Insert(M{"n": i})
+	}
+
+	got := struct {
+		N int
+	}{}
+
+	// Consume exactly one batch, so no documents are left buffered.
+	iter := coll.Find(nil).Sort("n").Batch(2).Prefetch(0).Iter()
+	for i := 0; i < 2; i++ {
+		c.Assert(iter.Next(&got), Equals, true)
+		c.Assert(got.N, Equals, i)
+	}
+	id := iter.CursorId()
+	c.Assert(id, Not(Equals), int64(0))
+
+	resumed := coll.IterFromCursor(id)
+	for i := 2; i < numDocuments; i++ {
+		c.Assert(resumed.Next(&got), Equals, true)
+		c.Assert(resumed.Err(), IsNil)
+		c.Assert(got.N, Equals, i)
+	}
+	c.Assert(resumed.Next(&got), Equals, false)
+	c.Assert(resumed.Close(), IsNil)
+	c.Assert(resumed.CursorId(), Equals, int64(0))
+}
+
 var cursorTimeout = flag.Bool("cursor-timeout", false, "Enable cursor timeout tests")
 
 func (s *S) TestFindIterCursorTimeout(c *C) {
@@ -2056,6 +3868,55 @@ func (s *S) TestFindIterCursorTimeout(c *C) {
 	c.Assert(iter.Err(), Equals, mgo.ErrCursor)
 }
 
+func (s *S) TestFindIterResume(c *C) {
+	if !s.versionAtLeast(3, 2) {
+		c.Skip("killCursors command requires 3.2+")
+	}
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for i := 0; i < 10; i++ {
+		err = coll.Insert(M{"_id": i})
+		c.Assert(err, IsNil)
+	}
+
+	var doc struct {
+		Id int `bson:"_id"`
+	}
+	killCursor := func(iter *mgo.Iter) {
+		err := coll.Database.Run(bson.D{
+			{Name: "killCursors", Value: "mycoll"},
+			{Name: "cursors", Value: []int64{iter.CursorId()}},
+		}, nil)
+		c.Assert(err, IsNil)
+	}
+	query := coll.Find(nil).Sort("_id").Batch(2).Prefetch(0)
+
+	// Without resuming, the lost cursor is reported.
+	iter := query.Iter()
+	c.Assert(iter.Next(&doc), Equals, true)
+	c.Assert(iter.Next(&doc), Equals, true)
+	killCursor(iter)
+	c.Assert(iter.Next(&doc), Equals, false)
+	c.Assert(iter.Err(), Equals, mgo.ErrCursor)
+
+	session.SetIterResume(true)
+
+	iter = query.Iter()
+	var ids []int
+	for i := 0; i < 2 && iter.Next(&doc); i++ {
+		ids = append(ids, doc.Id)
+	}
+	killCursor(iter)
+	for iter.Next(&doc) {
+		ids = append(ids, doc.Id)
+	}
+	c.Assert(iter.Close(), IsNil)
+	c.Assert(ids, DeepEquals, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+}
+
 func (s *S) TestFindIterCursorNoTimeout(c *C) {
 	if !*cursorTimeout {
 		c.Skip("-cursor-timeout")
@@ -2255,6 +4116,42 @@ func (s *S) TestFindIterLimitWithMore(c *C) {
 	}
 }
 
+func (s *S) TestFindIterBatchBytes(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	payload := strings.Repeat("x", 10000)
+	var size int
+	for i := 0; i < 20; i++ {
+		doc := bson.D{{Name: "_id", Value: bson.NewObjectId()}, {Name: "n", Value: i}, {Name: "data", Value: payload}}
+		data, err := bson.Marshal(doc)
+		c.Assert(err, IsNil)
+		size = len(data)
+		c.Assert(coll.Insert(doc), IsNil)
+	}
+
+	session.Refresh() // Release socket.
+	mgo.ResetStats()
+
+	// After the first batch of 2, batches of 4 documents fit the budget.
+	query := coll.Find(nil).Sort("n").Batch(2).Prefetch(0).BatchBytes(4*size + size/2)
+	iter := query.Iter()
+	var result struct{ N int }
+	for i := 0; i < 20; i++ {
+		c.Assert(iter.Next(&result), Equals, true)
+		c.Assert(result.N, Equals, i)
+	}
+	c.Assert(iter.Next(&result), Equals, false)
+	c.Assert(iter.Close(), IsNil)
+
+	// One reply for the first batch, and one per getMore.
+	stats := mgo.GetStats()
+	c.Assert(stats.ReceivedOps, Equals, 1+5)
+}
+
 func (s *S) TestFindIterLimitWithBatch(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -2473,6 +4370,79 @@ func (s *S) TestFindTailTimeoutWithSleep(c *C) {
 	c.Assert(result.N, Equals, 48)
 }
 
+func (s *S) TestFindTailStop(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+	err = db.C("mycoll").Create(&mgo.CollectionInfo{Capped: true, MaxBytes: 1024})
+	c.Assert(err, IsNil)
+	coll := db.C("mycoll")
+
+	err = coll.Insert(M{"n": 42})
+	c.Assert(err, IsNil)
+
+	iter := coll.Find(nil).Sort("$natural").Tail(-1)
+
+	result := struct{ N int }{}
+	c.Assert(iter.Next(&result), Equals, true)
+	c.Assert(result.N, Equals, 42)
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		iter.Stop()
+	}()
+
+	c.Log("Will wait for Next to be stopped...")
+	c.Assert(iter.Next(&result), Equals, false)
+	c.Assert(iter.Err(), Equals, mgo.ErrStopped)
+	c.Assert(iter.Timeout(), Equals, false)
+	c.Assert(iter.Next(&result), Equals, false)
+	c.Assert(iter.Close(), Equals, mgo.ErrStopped)
+
+	// The session remains usable.
+	c.Assert(session.Ping(), IsNil)
+	err = coll.Insert(M{"n": 43})
+	c.Assert(err, IsNil)
+}
+
+func (s *S) TestFindTailTimeoutFakeClock(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	cresult := struct{ ErrMsg string }{}
+
+	db := session.DB("mydb")
+	err = db.Run(bson.D{{Name: "create", Value: "mycoll"}, {Name: "capped", Value: true}, {Name: "size", Value: 1024}}, &cresult)
+	c.Assert(err, IsNil)
+	c.Assert(cresult.ErrMsg, Equals, "")
+	coll := db.C("mycoll")
+
+	err = coll.Insert(M{"n": 40})
+	c.Assert(err, IsNil)
+
+	// Every reading of the clock moves it forward by an hour, so the tail
+	// times out after the first empty getMore regardless of wall time.
+	clock := mgo.NewFakeClock(time.Now())
+	clock.Step = time.Hour
+	defer mgo.HackClock(clock)()
+
+	iter := coll.Find(nil).Sort("$natural").Tail(time.Hour)
+
+	result := struct{ N int }{}
+	c.Assert(iter.Next(&result), Equals, true)
+	c.Assert(result.N, Equals, 40)
+
+	started := time.Now()
+	c.Assert(iter.Next(&result), Equals, false)
+	c.Assert(iter.Err(), IsNil)
+	c.Assert(iter.Timeout(), Equals, true)
+	c.Assert(time.Since(started) < time.Minute, Equals, true)
+	c.Assert(iter.Close(), IsNil)
+}
+
 // Test tailable cursors in a situation where Next never gets to sleep once
 // to respect the timeout requested on Tail.
 func (s *S) TestFindTailTimeoutNoSleep(c *C) {
@@ -2638,7 +4608,7 @@ func (s *S) TestFindTailNoTimeout(c *C) {
 	select {
 	case ok := <-gotNext:
 		c.Assert(ok, Equals, false)
-		c.Assert(iter.Err(), ErrorMatches, "Closed explicitly")
+		c.Assert(iter.Err(), Equals, mgo.ErrClosed)
 		c.Assert(iter.Timeout(), Equals, false)
 	case <-time.After(1e9):
 		c.Fatal("Closing the session did not unblock Next")
@@ -2937,7 +4907,7 @@ func (s *S) TestFindIterSnapshot(c *C) {
 	}
 
 	query := coll.Find(M{"n": M{"$gt": -1}}).Batch(2).Prefetch(0)
-	query.Snapshot()
+	query = query.Snapshot()
 	iter := query.Iter()
 
 	seen := map[int]bool{}
@@ -2979,8 +4949,8 @@ func (s *S) TestSort(c *C) {
 	coll.Insert(M{"a": 1, "b": 0})
 
 	query := coll.Find(M{})
-	query.Sort("-a") // Should be ignored.
-	query.Sort("-b", "a")
+	query = query.Sort("-a") // Should be replaced.
+	query = query.Sort("-b", "a")
 	iter := query.Iter()
 
 	l := make([]int, 18)
@@ -3055,8 +5025,8 @@ func (s *S) TestSortScoreText(c *C) {
 	c.Assert(err, IsNil)
 
 	query := coll.Find(M{"$text": M{"$search": "foo"}})
-	query.Select(M{"score": M{"$meta": "textScore"}})
-	query.Sort("$textScore:score")
+	query = query.Select(M{"score": M{"$meta": "textScore"}})
+	query = query.Sort("$textScore:score")
 	iter := query.Iter()
 
 	var r struct{ A, B string }
@@ -3072,6 +5042,115 @@ func (s *S) TestSortScoreText(c *C) {
 	})
 }
 
+func (s *S) TestSortNatural(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+	err = db.C("mycoll").Create(&mgo.CollectionInfo{Capped: true, MaxBytes: 4096})
+	c.Assert(err, IsNil)
+	coll := db.C("mycoll")
+
+	ns := []int{3, 1, 4, 1, 5}
+	for _, n := range ns {
+		err = coll.Insert(M{"n": n})
+		c.Assert(err, IsNil)
+	}
+
+	var result []struct{ N int }
+	err = coll.Find(nil).Natural(-1).All(&result)
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, len(ns))
+	for i, r := range result {
+		c.Assert(r.N, Equals, ns[len(ns)-1-i])
+	}
+
+	err = coll.Find(nil).Natural(1).All(&result)
+	c.Assert(err, IsNil)
+	for i, r := range result {
+		c.Assert(r.N, Equals, ns[i])
+	}
+}
+
+func (s *S) TestTextSearch(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	if !s.versionAtLeast(2, 6) {
+		c.Skip("$text depends on 2.6+")
+	}
+
+	coll := session.DB("mydb").C("mycoll")
+
+	err = coll.Insert(M{"a": "once: foo"})
+	c.Assert(err, IsNil)
+
+	// Without a text index the server complains.
+	err = coll.Find(nil).TextSearch("foo").One(nil)
+	c.Assert(err, ErrorMatches, ".*text index required.*")
+
+	err = coll.EnsureIndexKey("$text:a")
+	c.Assert(err, IsNil)
+
+	err = coll.Insert(M{"a": "many: foo foo foo"})
+	c.Assert(err, IsNil)
+	err = coll.Insert(M{"a": "twice: foo foo"})
+	c.Assert(err, IsNil)
+	err = coll.Insert(M{"a": "none"})
+	c.Assert(err, IsNil)
+
+	var results []struct {
+		A     string
+		Score float64
+	}
+	err = coll.Find(nil).TextSearch("foo").All(&results)
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 3)
+	c.Assert(results[0].A, Equals, "many: foo foo foo")
+	c.Assert(results[1].A, Equals, "twice: foo foo")
+	c.Assert(results[2].A, Equals, "once: foo")
+	c.Assert(results[0].Score > results[1].Score, Equals, true)
+	c.Assert(results[1].Score > results[2].Score, Equals, true)
+
+	n, err := coll.Find(M{"a": M{"$ne": "once: foo"}}).TextSearch("foo").Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+}
+
+func (s *S) TestSortTextScore(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	if !s.versionAtLeast(2, 6) {
+		c.Skip("$text depends on 2.6+")
+	}
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.EnsureIndexKey("$text:a")
+	c.Assert(err, IsNil)
+
+	for _, a := range []string{"twice: foo foo", "once: foo", "none", "many: foo foo foo"} {
+		err = coll.Insert(M{"a": a})
+		c.Assert(err, IsNil)
+	}
+
+	var results []struct {
+		A     string
+		Score float64
+	}
+	err = coll.Find(M{"$text": M{"$search": "foo"}}).Sort("$textScore:score").All(&results)
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 3)
+	c.Assert(results[0].A, Equals, "many: foo foo foo")
+	c.Assert(results[2].A, Equals, "once: foo")
+	for i := 1; i < len(results); i++ {
+		c.Assert(results[i-1].Score > results[i].Score, Equals, true)
+	}
+}
+
 func (s *S) TestPrefetching(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -3313,6 +5392,114 @@ func (s *S) TestSafeInsert(c *C) {
 	c.Assert(stats.SentOps, Equals, 1)
 }
 
+func (s *S) TestInsertSplitsBatches(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	var result struct {
+		MaxWriteBatchSize int `bson:"maxWriteBatchSize"`
+	}
+	err = session.Run("isMaster", &result)
+	c.Assert(err, IsNil)
+	if result.MaxWriteBatchSize == 0 {
+		result.MaxWriteBatchSize = 1000
+	}
+
+	// More documents than a single write command may hold.
+	coll := session.DB("mydb").C("mycoll")
+	docs := make([]interface{}, 2*result.MaxWriteBatchSize+1)
+	for i := range docs {
+		docs[i] = M{"_id": i}
+	}
+	err = coll.Insert(docs...)
+	c.Assert(err, IsNil)
+	n, err := coll.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, len(docs))
+
+	// More data than a single write command may hold.
+	coll = session.DB("mydb").C("othercoll")
+	docs = make([]interface{}, 20)
+	for i := range docs {
+		docs[i] = M{"_id": i, "data": make([]byte, 1024*1024)}
+	}
+	err = coll.Insert(docs...)
+	c.Assert(err, IsNil)
+	n, err = coll.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, len(docs))
+}
+
+func (s *S) TestInsertDocTooLarge(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	big := M{"data": make([]byte, 17*1024*1024)}
+
+	err = coll.Insert(M{"n": 1}, big)
+	c.Assert(err, FitsTypeOf, &mgo.DocTooLargeError{})
+	tooLarge := err.(*mgo.DocTooLargeError)
+	c.Assert(tooLarge.Index, Equals, 1)
+	c.Assert(tooLarge.MaxSize, Equals, 16*1024*1024)
+	c.Assert(tooLarge.Size > tooLarge.MaxSize, Equals, true)
+
+	// Nothing was sent.
+	n, err := coll.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *S) TestInsertSafeOverridesSession(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	err = coll.Insert(M{"_id": 1})
+	c.Assert(err, IsNil)
+
+	session.SetSafe(nil)
+	mgo.ResetStats()
+
+	// The single safe insert reports the duplicate.
+	err = coll.InsertSafe(&mgo.Safe{}, M{"_id": 1})
+	c.Assert(err, ErrorMatches, ".*E11000 duplicate.*")
+
+	// It must have sent the getLastError QUERY_OP, or a write command
+	// acknowledging the write on 2.6+.
+	stats := mgo.GetStats()
+	if s.versionAtLeast(2, 6) {
+		c.Assert(stats.SentOps, Equals, 1)
+		c.Assert(stats.ReceivedOps, Equals, 1)
+	} else {
+		c.Assert(stats.SentOps, Equals, 2)
+	}
+
+	// The session remains unsafe.
+	c.Assert(session.Safe(), IsNil)
+	err = coll.Insert(M{"_id": 1})
+	c.Assert(err, IsNil)
+
+	err = coll.UpdateSafe(&mgo.Safe{}, M{"_id": 2}, M{"$set": M{"n": 1}})
+	c.Assert(err, Equals, mgo.ErrNotFound)
+	err = coll.RemoveSafe(&mgo.Safe{}, M{"_id": 2})
+	c.Assert(err, Equals, mgo.ErrNotFound)
+	err = coll.RemoveSafe(&mgo.Safe{}, M{"_id": 1})
+	c.Assert(err, IsNil)
+
+	// And the other way around.
+	session.SetSafe(&mgo.Safe{})
+	err = coll.Insert(M{"_id": 1})
+	c.Assert(err, IsNil)
+	err = coll.InsertSafe(nil, M{"_id": 1})
+	c.Assert(err, IsNil)
+	c.Assert(session.Safe(), NotNil)
+}
+
 func (s *S) TestSafeParameters(c *C) {
 	session, err := mgo.Dial("localhost:40011")
 	c.Assert(err, IsNil)
@@ -3377,6 +5564,39 @@ func (s *S) TestQueryErrorNext(c *C) {
 	c.Assert(iter.Err(), Equals, err)
 }
 
+func (s *S) TestQueryErrorNextLaterBatch(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for i := 0; i < 10; i++ {
+		err = coll.Insert(M{"_id": i})
+		c.Assert(err, IsNil)
+	}
+
+	// The error is only raised once the scan reaches _id 6, in a later batch.
+	query := coll.Find(M{"$where": "if (this._id == 6) { throw 'boom' }; return true"})
+	iter := query.Sort("_id").Batch(2).Iter()
+
+	var result struct {
+		Id int `bson:"_id"`
+	}
+	n := 0
+	for iter.Next(&result) {
+		c.Assert(result.Id, Equals, n)
+		n++
+	}
+	c.Assert(n >= 2, Equals, true)
+	c.Assert(n < 6, Equals, true)
+
+	err = iter.Err()
+	c.Assert(err, ErrorMatches, ".*boom.*")
+	_, ok := err.(*mgo.QueryError)
+	c.Assert(ok, Equals, true)
+	c.Assert(iter.Close(), Equals, err)
+}
+
 var indexTests = []struct {
 	index    mgo.Index
 	expected M
@@ -3756,6 +5976,31 @@ func (s *S) TestEnsureIndexKey(c *C) {
 	}
 }
 
+func (s *S) TestEnsureIndexKeyIndexes(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	err = coll.EnsureIndexKey("-a")
+	c.Assert(err, IsNil)
+
+	indexes, err := coll.Indexes()
+	c.Assert(err, IsNil)
+
+	var found *mgo.Index
+	for i := range indexes {
+		if indexes[i].Name == "a_-1" {
+			found = &indexes[i]
+		}
+	}
+	c.Assert(found, NotNil)
+	c.Assert(found.Key, DeepEquals, []string{"-a"})
+	c.Assert(found.Unique, Equals, false)
+	c.Assert(found.Sparse, Equals, false)
+}
+
 func (s *S) TestEnsureIndexDropIndex(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -3919,17 +6164,59 @@ func (s *S) TestEnsureIndexCaching(c *C) {
 	stats := mgo.GetStats()
 	c.Assert(stats.SentOps, Equals, 0)
 
-	// Resetting the cache should make it contact the server again.
-	session.ResetIndexCache()
+	// Resetting the cache should make it contact the server again.
+	session.ResetIndexCache()
+
+	err = coll.EnsureIndexKey("a")
+	c.Assert(err, IsNil)
+
+	stats = mgo.GetStats()
+	c.Assert(stats.SentOps > 0, Equals, true)
+
+	// Dropping the index should also drop the cached index key.
+	err = coll.DropIndex("a")
+	c.Assert(err, IsNil)
+
+	mgo.ResetStats()
+
+	err = coll.EnsureIndexKey("a")
+	c.Assert(err, IsNil)
+
+	stats = mgo.GetStats()
+	c.Assert(stats.SentOps > 0, Equals, true)
+}
+
+func (s *S) TestEnsureIndexCachingDrop(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	err = coll.EnsureIndexKey("a")
+	c.Assert(err, IsNil)
+
+	// Dropping the collection through a copy must invalidate the cache
+	// shared with the original session.
+	copied := session.Copy()
+	err = copied.DB("mydb").C("mycoll").DropCollection()
+	copied.Close()
+	c.Assert(err, IsNil)
+
+	mgo.ResetStats()
 
 	err = coll.EnsureIndexKey("a")
 	c.Assert(err, IsNil)
 
-	stats = mgo.GetStats()
+	stats := mgo.GetStats()
 	c.Assert(stats.SentOps > 0, Equals, true)
 
-	// Dropping the index should also drop the cached index key.
-	err = coll.DropIndex("a")
+	indexes, err := coll.Indexes()
+	c.Assert(err, IsNil)
+	c.Assert(indexes, HasLen, 2)
+
+	// Same thing when the whole database is dropped.
+	err = session.DB("mydb").DropDatabase()
 	c.Assert(err, IsNil)
 
 	mgo.ResetStats()
@@ -4125,6 +6412,30 @@ func (s *S) TestDistinct(c *C) {
 	c.Assert(result, DeepEquals, []int{3, 4, 6})
 }
 
+func (s *S) TestSortedDistinct(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	for _, i := range []int{1, 4, 6, 2, 2, 3, 4} {
+		coll.Insert(M{"n": i})
+	}
+
+	var result []int
+	err = coll.Find(M{"n": M{"$gt": 1}}).SortedDistinct("n", &result)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, []int{2, 3, 4, 6})
+
+	coll.Insert(M{"n": "b"}, M{"n": "a"}, M{"n": 1.5})
+
+	var mixed []interface{}
+	err = coll.Find(nil).SortedDistinct("n", &mixed)
+	c.Assert(err, IsNil)
+	c.Assert(mixed, DeepEquals, []interface{}{1, 1.5, 2, 3, 4, 6, "a", "b"})
+}
+
 func (s *S) TestMapReduce(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -4402,6 +6713,26 @@ func (s *S) TestBuildInfo(c *C) {
 	}
 }
 
+func (s *S) TestWireVersion(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	min, max, err := session.WireVersion()
+	c.Assert(err, IsNil)
+	c.Assert(min >= 0, Equals, true)
+	c.Assert(max >= min, Equals, true)
+	if s.versionAtLeast(2, 6) {
+		c.Assert(max >= 2, Equals, true)
+	}
+	if s.versionAtLeast(3, 0) {
+		c.Assert(max >= 3, Equals, true)
+	}
+	if s.versionAtLeast(3, 2) {
+		c.Assert(max >= 4, Equals, true)
+	}
+}
+
 func (s *S) TestZeroTimeRoundtrip(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -4420,6 +6751,50 @@ func (s *S) TestZeroTimeRoundtrip(c *C) {
 	c.Assert(t, Equals, time.Time{})
 }
 
+func (s *S) TestCurrentOpKillOp(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"n": 1})
+	c.Assert(err, IsNil)
+
+	// Start an operation that runs for long enough to be killed.
+	done := make(chan error, 1)
+	go func() {
+		slow := session.Copy()
+		defer slow.Close()
+		done <- slow.DB("mydb").C("mycoll").Find(M{"$where": "sleep(10000) || true"}).One(nil)
+	}()
+
+	var op *mgo.OpDoc
+	for i := 0; i < 50 && op == nil; i++ {
+		time.Sleep(100 * time.Millisecond)
+		ops, err := session.CurrentOp()
+		c.Assert(err, IsNil)
+		c.Assert(len(ops) > 0, Equals, true)
+		for i := range ops {
+			if ops[i].Ns == "mydb.mycoll" && ops[i].Active {
+				op = &ops[i]
+			}
+		}
+	}
+	c.Assert(op, NotNil)
+	c.Assert(op.OpId, NotNil)
+	c.Assert(op.Op, Equals, "query")
+
+	err = session.KillOp(op.OpId)
+	c.Assert(err, IsNil)
+
+	select {
+	case err := <-done:
+		c.Assert(err, ErrorMatches, ".*(interrupted|killed).*")
+	case <-time.After(5 * time.Second):
+		c.Fatalf("operation was not killed")
+	}
+}
+
 func (s *S) TestFsyncLock(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -4546,6 +6921,37 @@ func (s *S) TestPipeIter(c *C) {
 	c.Assert(iter.Close(), IsNil)
 }
 
+func (s *S) TestPipeOut(c *C) {
+	if !s.versionAtLeast(2, 6) {
+		c.Skip("$out only works on 2.6+")
+	}
+
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	ns := []int{40, 41, 42, 43, 44, 45, 46}
+	for _, n := range ns {
+		coll.Insert(M{"n": n})
+	}
+
+	pipe := coll.Pipe([]M{{"$match": M{"n": M{"$gte": 42}}}, {"$out": "outcoll"}})
+	info, err := pipe.Out()
+	c.Assert(err, IsNil)
+	c.Assert(info.Database, Equals, "mydb")
+	c.Assert(info.Collection, Equals, "outcoll")
+	c.Assert(info.Count, Equals, 5)
+
+	n, err := session.DB("mydb").C("outcoll").Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 5)
+
+	_, err = coll.Pipe([]M{{"$match": M{}}}).Out()
+	c.Assert(err, ErrorMatches, "pipeline does not end with a \\$out stage")
+}
+
 func (s *S) TestPipeAll(c *C) {
 	if !s.versionAtLeast(2, 1) {
 		c.Skip("Pipe only works on 2.1+")
@@ -4714,6 +7120,221 @@ func (s *S) TestFindIterCloseKillsCursor(c *C) {
 	c.Assert(serverCursorsOpen(session), Equals, cursors)
 }
 
+func (s *S) TestFindIterForEach(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	ns := []int{40, 41, 42, 43, 44, 45, 46}
+	for _, n := range ns {
+		err = coll.Insert(M{"n": n})
+		c.Assert(err, IsNil)
+	}
+
+	var seen []int
+	result := struct{ N int }{}
+	err = coll.Find(nil).Sort("n").ForEach(&result, func() error {
+		seen = append(seen, result.N)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(seen, DeepEquals, ns)
+}
+
+func (s *S) TestFindIterForEachErrorKillsCursor(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	cursors := serverCursorsOpen(session)
+
+	coll := session.DB("mydb").C("mycoll")
+	ns := []int{40, 41, 42, 43, 44, 45, 46}
+	for _, n := range ns {
+		err = coll.Insert(M{"n": n})
+		c.Assert(err, IsNil)
+	}
+
+	stop := errors.New("stop")
+	count := 0
+	iter := coll.Find(nil).Batch(2).Iter()
+	err = iter.ForEach(&bson.M{}, func() error {
+		count++
+		if count == 3 {
+			return stop
+		}
+		return nil
+	})
+	c.Assert(err, Equals, stop)
+	c.Assert(count, Equals, 3)
+	c.Assert(serverCursorsOpen(session), Equals, cursors)
+}
+
+func (s *S) TestFindIterConcurrent(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	ns := []int{40, 41, 42, 43, 44, 45, 46}
+	for _, n := range ns {
+		err = coll.Insert(M{"n": n})
+		c.Assert(err, IsNil)
+	}
+
+	iter := coll.Find(nil).Sort("n").Batch(2).IterConcurrent()
+	result := struct{ N int }{}
+	for _, n := range ns {
+		c.Assert(iter.Next(&result), Equals, true)
+		c.Assert(result.N, Equals, n)
+		// Simulate some processing while more results arrive.
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(iter.Next(&result), Equals, false)
+	c.Assert(iter.Err(), IsNil)
+	c.Assert(iter.Close(), IsNil)
+}
+
+func (s *S) TestFindIterConcurrentCloseKillsCursor(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	cursors := serverCursorsOpen(session)
+
+	coll := session.DB("mydb").C("mycoll")
+	for n := 0; n < 100; n++ {
+		err = coll.Insert(M{"n": n})
+		c.Assert(err, IsNil)
+	}
+
+	iter := coll.Find(nil).Batch(2).IterConcurrent()
+	c.Assert(iter.Next(&bson.M{}), Equals, true)
+	c.Assert(iter.Close(), IsNil)
+	c.Assert(serverCursorsOpen(session), Equals, cursors)
+}
+
+func (s *S) TestFindIterConcurrentError(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"n": 1})
+	c.Assert(err, IsNil)
+
+	// Documents can't be unmarshalled into an int.
+	iter := coll.Find(nil).IterConcurrent()
+	var result int
+	c.Assert(iter.Next(&result), Equals, false)
+	c.Assert(iter.Err(), NotNil)
+	c.Assert(iter.Close(), NotNil)
+}
+
+func (s *S) TestFindIterConcurrentGetMoreError(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for n := 0; n < 10; n++ {
+		err = coll.Insert(M{"n": n})
+		c.Assert(err, IsNil)
+	}
+
+	// The first batch succeeds, and a later getMore made by the background
+	// goroutine fails on the server.
+	query := coll.Find(M{"$where": "if (this.n == 5) { throw 'boom' }; return true"})
+	iter := query.Batch(2).Prefetch(0).IterConcurrent()
+	var result struct{ N int }
+	got := 0
+	for iter.Next(&result) {
+		got++
+	}
+	c.Assert(got >= 2 && got < 10, Equals, true)
+	c.Assert(iter.Err(), ErrorMatches, ".*boom.*")
+	c.Assert(iter.Close(), ErrorMatches, ".*boom.*")
+}
+
+func (s *S) TestFindIterConcurrentFaster(c *C) {
+	if *fast {
+		c.Skip("-fast")
+	}
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for n := 0; n < 20; n++ {
+		err = coll.Insert(M{"n": n})
+		c.Assert(err, IsNil)
+	}
+
+	// Each batch of 5 documents takes the server about 100ms to produce, and
+	// the CPU-bound consumer about as long to process.
+	query := coll.Find(M{"$where": "sleep(20) || true"}).Batch(5).Prefetch(0)
+	spin := func() {
+		for end := time.Now().Add(20 * time.Millisecond); time.Now().Before(end); {
+		}
+	}
+	var result struct{ N int }
+
+	started := time.Now()
+	iter := query.Iter()
+	for iter.Next(&result) {
+		spin()
+	}
+	c.Assert(iter.Close(), IsNil)
+	sequential := time.Since(started)
+
+	started = time.Now()
+	citer := query.IterConcurrent()
+	for citer.Next(&result) {
+		spin()
+	}
+	c.Assert(citer.Close(), IsNil)
+	concurrent := time.Since(started)
+
+	c.Logf("sequential: %v, concurrent: %v", sequential, concurrent)
+	c.Assert(concurrent < sequential*3/4, Equals, true)
+}
+
+func (s *S) TestFindIterNextTimeout(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for i := 0; i < 3; i++ {
+		err = coll.Insert(M{"n": i})
+		c.Assert(err, IsNil)
+	}
+
+	// Every document takes a while to be matched, so each getMore is slow.
+	query := coll.Find(M{"$where": "sleep(500) || true"}).Sort("n").Batch(1)
+	iter := query.Iter()
+	iter.SetNextTimeout(100 * time.Millisecond)
+
+	var result struct{ N int }
+	var got []int
+	timeouts := 0
+	for {
+		if iter.Next(&result) {
+			got = append(got, result.N)
+			continue
+		}
+		if iter.Timeout() {
+			timeouts++
+			continue
+		}
+		break
+	}
+	c.Assert(iter.Close(), IsNil)
+	c.Assert(got, DeepEquals, []int{0, 1, 2})
+	c.Assert(timeouts > 0, Equals, true)
+}
+
 func (s *S) TestFindIterDoneWithBatches(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -4904,6 +7525,41 @@ func (s *S) TestBypassValidation(c *C) {
 	c.Assert(ns, DeepEquals, []int{4})
 }
 
+func (s *S) TestBypassValidationApply(c *C) {
+	if !s.versionAtLeast(3, 2) {
+		c.Skip("validation supported on 3.2+")
+	}
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	err = coll.Insert(M{"n": 1})
+	c.Assert(err, IsNil)
+
+	err = coll.Database.Run(bson.D{
+		{Name: "collMod", Value: "mycoll"},
+		{Name: "validator", Value: M{"s": M{"$type": "string"}}},
+	}, nil)
+	c.Assert(err, IsNil)
+
+	change := mgo.Change{Update: M{"$set": M{"n": 2}}, ReturnNew: true}
+	var result struct{ N int }
+
+	_, err = coll.Find(M{"n": 1}).Apply(change, &result)
+	c.Assert(err, ErrorMatches, "Document failed validation")
+
+	session.SetBypassValidation(true)
+
+	_, err = coll.Find(M{"n": 1}).Apply(change, &result)
+	c.Assert(err, IsNil)
+	c.Assert(result.N, Equals, 2)
+
+	// Removals are not affected.
+	_, err = coll.Find(M{"n": 2}).Apply(mgo.Change{Remove: true}, nil)
+	c.Assert(err, IsNil)
+}
+
 func (s *S) TestVersionAtLeast(c *C) {
 	tests := [][][]int{
 		{{3, 2, 1}, {3, 2, 0}},
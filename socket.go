@@ -29,6 +29,7 @@ package mgo
 import (
 	"errors"
 	"fmt"
+	"math"
 	"net"
 	"sync"
 	"time"
@@ -56,6 +57,7 @@ type mongoSocket struct {
 	closeAfterIdle bool
 	lastTimeUsed   time.Time // for time based idle socket release
 	sendMeta       sync.Once
+	wbuf           []byte // Operations queued by QueryBuffered
 }
 
 type queryOpFlags uint32
@@ -73,10 +75,11 @@ type queryOp struct {
 	query       interface{}
 	collection  string
 	serverTags  []bson.D
+	readPref    bson.D // Explicit $readPreference for mongos, if any.
 	selector    interface{}
 	replyFunc   replyFunc
 	mode        Mode
-	skip        int32
+	skip        int64
 	limit       int32
 	options     queryWrapper
 	hasOptions  bool
@@ -98,7 +101,10 @@ type queryWrapper struct {
 }
 
 func (op *queryOp) finalQuery(socket *mongoSocket) interface{} {
-	if op.flags&flagSlaveOk != 0 && socket.ServerInfo().Mongos {
+	if op.readPref != nil && socket.ServerInfo().Mongos {
+		op.hasOptions = true
+		op.options.ReadPreference = op.readPref
+	} else if op.flags&flagSlaveOk != 0 && socket.ServerInfo().Mongos {
 		var modeName string
 		switch op.mode {
 		case Strong:
@@ -273,6 +279,10 @@ func (socket *mongoSocket) Release() {
 		server := socket.server
 		closeAfterIdle := socket.closeAfterIdle
 		socket.Unlock()
+		if err := socket.Flush(); err != nil {
+			socket.kill(err, true)
+			return
+		}
 		socket.LogoutAll()
 		if closeAfterIdle {
 			socket.Close()
@@ -350,6 +360,7 @@ func (socket *mongoSocket) kill(err error, abend bool) {
 	}
 	logf("Socket %p to %s: closing: %s (abend=%v)", socket, socket.addr, err.Error(), abend)
 	socket.dead = err
+	socket.wbuf = nil
 	socket.conn.Close()
 	stats.socketsAlive(-1)
 	replyFuncs := socket.replyFuncs
@@ -403,7 +414,41 @@ var bytesBufferPool = sync.Pool{
 	},
 }
 
+// writeBufferSize is how many bytes of operations QueryBuffered queues
+// before writing them out.
+const writeBufferSize = 64 * 1024
+
+// Query sends ops to the server, along with any operations previously
+// queued by QueryBuffered.
 func (socket *mongoSocket) Query(ops ...interface{}) (err error) {
+	return socket.query(false, ops)
+}
+
+// QueryBuffered works like Query, but when none of ops expects a reply
+// they're queued rather than sent right away, so that several operations
+// are written to the connection at once.  Queued operations are sent
+// ahead of the next operation sent with Query, once writeBufferSize bytes
+// are queued, on Flush, or when the socket is released for reuse.
+func (socket *mongoSocket) QueryBuffered(ops ...interface{}) (err error) {
+	return socket.query(true, ops)
+}
+
+// Flush sends any operations queued by QueryBuffered.
+func (socket *mongoSocket) Flush() error {
+	socket.Lock()
+	buf := socket.wbuf
+	socket.wbuf = nil
+	socket.Unlock()
+	if len(buf) == 0 {
+		return nil
+	}
+	debugf("Socket %p to %s: flushing %d bytes of queued ops", socket, socket.addr, len(buf))
+	socket.updateDeadline(writeDeadline)
+	_, err := socket.conn.Write(buf)
+	return netError(err)
+}
+
+func (socket *mongoSocket) query(buffered bool, ops []interface{}) (err error) {
 
 	if lops := socket.flushLogout(); len(lops) > 0 {
 		ops = append(lops, ops...)
@@ -464,7 +509,10 @@ func (socket *mongoSocket) Query(ops ...interface{}) (err error) {
 			buf = addHeader(buf, 2004)
 			buf = addInt32(buf, int32(op.flags))
 			buf = addCString(buf, op.collection)
-			buf = addInt32(buf, op.skip)
+			if op.skip > math.MaxInt32 {
+				return errors.New("skip exceeds the maximum supported by the server")
+			}
+			buf = addInt32(buf, int32(op.skip))
 			buf = addInt32(buf, op.limit)
 			buf, err = addBSON(buf, op.finalQuery(socket))
 			if err != nil {
@@ -551,6 +599,20 @@ func (socket *mongoSocket) Query(ops ...interface{}) (err error) {
 		socket.replyFuncs[requestId] = request.replyFunc
 		requestId++
 	}
+	if buffered && requestCount == 0 {
+		socket.wbuf = append(socket.wbuf, buf...)
+		if len(socket.wbuf) < writeBufferSize {
+			socket.Unlock()
+			debugf("Socket %p to %s: queued %d op(s) (%d bytes)", socket, socket.addr, len(ops), len(buf))
+			stats.sentOps(len(ops))
+			return nil
+		}
+		buf = socket.wbuf
+		socket.wbuf = nil
+	} else if len(socket.wbuf) > 0 {
+		buf = append(socket.wbuf, buf...)
+		socket.wbuf = nil
+	}
 	socket.Unlock()
 	debugf("Socket %p to %s: sending %d op(s) (%d bytes)", socket, socket.addr, len(ops), len(buf))
 
@@ -560,7 +622,7 @@ func (socket *mongoSocket) Query(ops ...interface{}) (err error) {
 	if !wasWaiting && requestCount > 0 {
 		socket.updateDeadline(readDeadline)
 	}
-	return err
+	return netError(err)
 }
 
 func fill(r net.Conn, b []byte) error {
@@ -571,14 +633,83 @@ func fill(r net.Conn, b []byte) error {
 		ni, err = r.Read(b[n:])
 		n += ni
 	}
+	return netError(err)
+}
+
+// TimeoutError is the error returned for ErrTimeout conditions.  Err holds
+// the original network error, which names the server address.
+//
+// TimeoutError matches ErrTimeout via its Is method, and also satisfies
+// net.Error, reporting a timeout.
+type TimeoutError struct {
+	Err error
+}
+
+func (e *TimeoutError) Error() string   { return e.Err.Error() }
+func (e *TimeoutError) Timeout() bool   { return true }
+func (e *TimeoutError) Temporary() bool { return true }
+
+// Unwrap returns the original network error.
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+// Is returns whether target is ErrTimeout.
+func (e *TimeoutError) Is(target error) bool { return target == ErrTimeout }
+
+// IsTimeout returns whether err informs of a socket timeout, being either
+// ErrTimeout itself or a *TimeoutError.
+func IsTimeout(err error) bool {
+	if err == ErrTimeout {
+		return true
+	}
+	_, ok := err.(*TimeoutError)
+	return ok
+}
+
+// netError wraps err in a *TimeoutError when it reports a deadline expiry,
+// so that callers may tell it apart from a closed connection.
+func netError(err error) error {
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return &TimeoutError{Err: err}
+	}
 	return err
 }
 
+// docChunkSize is the size of the chunks docAllocator carves small reply
+// documents out of.
+const docChunkSize = 32 * 1024
+
+// docAllocator hands out reply document buffers carved from larger chunks,
+// so that reading a batch of small documents costs a few allocations rather
+// than one per document.
+//
+// Chunks are never recycled through a pool: decoded values such as bson.Raw
+// and bson.Binary keep referencing the document bytes after Iter.Next
+// returns, so reusing them would silently corrupt those values. Documents
+// larger than a quarter chunk get their own buffer. The flip side is that a
+// small document retained by the application, such as a bson.Raw value,
+// keeps its whole chunk of docChunkSize bytes alive until it's released.
+type docAllocator struct {
+	chunk []byte
+}
+
+func (a *docAllocator) alloc(n int) []byte {
+	if n > docChunkSize/4 {
+		return make([]byte, n)
+	}
+	if len(a.chunk) < n {
+		a.chunk = make([]byte, docChunkSize)
+	}
+	b := a.chunk[:n:n]
+	a.chunk = a.chunk[n:]
+	return b
+}
+
 // Estimated minimum cost per socket: 1 goroutine + memory for the largest
 // document ever seen.
 func (socket *mongoSocket) readLoop() {
 	p := make([]byte, 36) // 16 from header + 20 from OP_REPLY fixed fields
 	s := make([]byte, 4)
+	var docs docAllocator
 	conn := socket.conn // No locking, conn never changes.
 	for {
 		err := fill(conn, p)
@@ -632,7 +763,7 @@ func (socket *mongoSocket) readLoop() {
 					return
 				}
 
-				b := make([]byte, int(getInt32(s, 0)))
+				b := docs.alloc(int(getInt32(s, 0)))
 
 				// copy(b, s) in an efficient way.
 				b[0] = s[0]
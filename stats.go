@@ -93,6 +93,14 @@ type Stats struct {
 	PoolTimeouts        int
 }
 
+// ServerStat holds the socket pool state for a single server.
+type ServerStat struct {
+	// InUse is the number of live sockets currently reserved.
+	InUse int
+	// Idle is the number of live sockets available for reuse.
+	Idle int
+}
+
 func (stats *Stats) cluster(delta int) {
 	if stats != nil {
 		statsMutex.Lock()
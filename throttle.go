@@ -0,0 +1,50 @@
+package mgo
+
+import (
+	"sync"
+	"time"
+)
+
+// opThrottle spaces operations evenly so that no more than a configured
+// number of them start within any one second.
+//
+// It behaves as a token bucket holding a single token that refills at the
+// configured rate: callers that find the bucket empty wait for the next
+// token rather than being rejected.
+//
+// opThrottle is safe for concurrent use.
+type opThrottle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newOpThrottle returns a throttle admitting opsPerSecond operations per
+// second, or nil if opsPerSecond is not positive.
+func newOpThrottle(opsPerSecond int) *opThrottle {
+	if opsPerSecond <= 0 {
+		return nil
+	}
+	return &opThrottle{interval: time.Second / time.Duration(opsPerSecond)}
+}
+
+// wait blocks until the caller may start its operation. It's a no-op on a
+// nil throttle.
+func (t *opThrottle) wait() {
+	if t == nil {
+		return
+	}
+	clock := getClock()
+	t.mu.Lock()
+	now := clock.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	delay := t.next.Sub(now)
+	t.next = t.next.Add(t.interval)
+	t.mu.Unlock()
+
+	if delay > 0 {
+		clock.Sleep(delay)
+	}
+}
@@ -0,0 +1,54 @@
+package mgo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOpThrottle(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1e9, 0))
+	defer setClock(clock)()
+
+	const rate = 100
+	const ops = 11
+
+	throttle := newOpThrottle(rate)
+	for i := 0; i < ops; i++ {
+		throttle.wait()
+	}
+
+	// The first operation starts immediately, and each following one
+	// waits for its own slot.
+	want := make([]time.Duration, ops-1)
+	for i := range want {
+		want[i] = time.Second / rate
+	}
+	if got := clock.Slept(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("%d operations slept %v, expected %v", ops, got, want)
+	}
+
+	// Slots left unused while idle are not saved up for later.
+	clock.Advance(time.Second)
+	throttle.wait()
+	if got := clock.Slept(); len(got) != ops-1 {
+		t.Fatalf("operation after idle period slept %v", got[ops-1:])
+	}
+}
+
+func TestOpThrottleDisabled(t *testing.T) {
+	if newOpThrottle(0) != nil || newOpThrottle(-1) != nil {
+		t.Fatal("expected no throttle for a non-positive rate")
+	}
+
+	clock := NewFakeClock(time.Unix(1e9, 0))
+	defer setClock(clock)()
+
+	var throttle *opThrottle
+	for i := 0; i < 1000; i++ {
+		throttle.wait()
+	}
+	if got := clock.Slept(); len(got) != 0 {
+		t.Fatalf("nil throttle slept %v", got)
+	}
+}
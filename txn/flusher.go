@@ -600,7 +600,7 @@ NextDoc:
 func (f *flusher) reload(t *transaction) error {
 	var newt transaction
 	query := f.tc.FindId(t.Id)
-	query.Select(bson.D{{Name: "s", Value: 1}, {Name: "n", Value: 1}, {Name: "r", Value: 1}})
+	query = query.Select(bson.D{{Name: "s", Value: 1}, {Name: "n", Value: 1}, {Name: "r", Value: 1}})
 	if err := query.One(&newt); err != nil {
 		return fmt.Errorf("failed to reload transaction: %v", err)
 	}
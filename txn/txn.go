@@ -502,7 +502,7 @@ func (r *Runner) loadMulti(ids []bson.ObjectId, preloaded map[bson.ObjectId]*tra
 
 	query := r.tc.Find(bson.M{"_id": bson.M{"$in": ids}})
 	// Not sure that this actually has much of an effect when using All()
-	query.Batch(len(ids))
+	query = query.Batch(len(ids))
 	err := query.All(&txns)
 	if err == mgo.ErrNotFound {
 		return fmt.Errorf("could not find a transaction in batch: %v", ids)